@@ -1,12 +1,30 @@
 package main
 
 import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode"
 )
 
 const (
@@ -21,6 +39,15 @@ type MarketplaceConfig struct {
 	Name    string   `json:"name"`
 	Owner   Owner    `json:"owner"`
 	Plugins []Plugin `json:"plugins"`
+	// Skipped holds the errors for plugin entries dropped by a lenient
+	// read.
+	Skipped []error `json:"-"`
+	// Files lists every file the config was read from: marketplace.json,
+	// $ref targets, and skillsFile lists.
+	Files []string `json:"-"`
+	// Warnings holds notes about the config that do not stop the run,
+	// such as inferred plugin names.
+	Warnings []string `json:"-"`
 }
 
 type Owner struct {
@@ -33,13 +60,131 @@ type Plugin struct {
 	Name        string   `json:"name"`
 	Source      string   `json:"source"`
 	Description string   `json:"description"`
-	Skills      []string `json:"skills"`
+	Skills      []string `json:"skills,omitempty"`
+	// Build is an optional shell command run in Source before the
+	// plugin's skills are synced.
+	Build string `json:"build,omitempty"`
+	// SkillsFile names a file listing further skill paths, one per line,
+	// which are merged into Skills when the marketplace is read.
+	SkillsFile string `json:"skillsFile,omitempty"`
+	// Merged maps the name of each skill given in object form to its
+	// source paths, relative to Source, in override order.
+	Merged map[string][]string `json:"-"`
+	// Aliases maps a skill name to the alternative names it is linked
+	// under by -aliases, replacing the skill's frontmatter aliases.
+	Aliases map[string][]string `json:"aliases,omitempty"`
+}
+
+// mergedSkill is the object form of a "skills" entry: one skill assembled
+// from several source directories, later sources overriding earlier ones.
+type mergedSkill struct {
+	Name    string   `json:"name"`
+	Sources []string `json:"sources"`
+}
+
+// UnmarshalJSON accepts each "skills" entry either as a path or as a
+// mergedSkill object. Merged skills are listed in Skills as
+// ./skills/<name> so they are reported like any other skill.
+func (p *Plugin) UnmarshalJSON(data []byte) error {
+	type plainPlugin Plugin
+	var decoded struct {
+		plainPlugin
+		Skills []json.RawMessage `json:"skills,omitempty"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*p = Plugin(decoded.plainPlugin)
+	p.Skills = nil
+	for _, entry := range decoded.Skills {
+		var skillPath string
+		if err := json.Unmarshal(entry, &skillPath); err == nil {
+			p.Skills = append(p.Skills, skillPath)
+			continue
+		}
+		var merged mergedSkill
+		if err := json.Unmarshal(entry, &merged); err != nil {
+			return fmt.Errorf("skills entry must be a path or an object with name and sources: %s", entry)
+		}
+		if merged.Name == "" || merged.Name == "." || merged.Name == ".." || strings.ContainsAny(merged.Name, `/\`) {
+			return fmt.Errorf("merged skill has an invalid name %q", merged.Name)
+		}
+		if p.Merged == nil {
+			p.Merged = make(map[string][]string)
+		}
+		p.Merged[merged.Name] = merged.Sources
+		p.Skills = append(p.Skills, "./skills/"+merged.Name)
+	}
+	return nil
 }
 
 type SyncStats struct {
-	SkillsSynced int
-	SkillsFailed int
-	FilesCreated int
+	SkillsSynced       int
+	SkillsFailed       int
+	FilesCreated       int
+	ManifestsRefreshed int
+	AliasesLinked      int
+	SkillsExcluded     int
+	// CaseCollisions counts files whose path differs from another file in
+	// the same skill only by case.
+	CaseCollisions int
+	// FilesSkippedNewer counts destination files kept by -skip-newer.
+	FilesSkippedNewer int
+	// SkillsKept counts skills left as they were because overwriting
+	// their local edits was not confirmed.
+	SkillsKept int
+	// SyncedNames lists the Codex names of the synced skills, in order.
+	SyncedNames []string
+}
+
+// SyncOptions holds the settings that control how skills are synced.
+type SyncOptions struct {
+	TargetDir string
+	Verbose   bool
+	DryRun    bool
+	UsePrefix bool
+	// SkipBuild disables plugin build commands.
+	SkipBuild bool
+	// SanitizeNames slugifies Codex skill names for use as directory names.
+	SanitizeNames bool
+	// NameCase is the -name-case style applied to Codex skill names:
+	// "preserve", "lower", or "kebab".
+	NameCase string
+	// PreserveTimes copies modification times from source files and
+	// directories to the destination.
+	PreserveTimes bool
+	// SkipNewer keeps destination files modified more recently than their
+	// source instead of overwriting them.
+	SkipNewer bool
+	// OutputMode, when non-zero, replaces the source mode on synced files;
+	// directories get the same bits plus execute wherever read is set.
+	OutputMode os.FileMode
+	// Manifest writes a syncManifestName file recording the checksum of
+	// every synced file into each skill.
+	Manifest bool
+	// ManifestOnly rewrites each synced skill's manifest from the files
+	// already in the destination, without copying anything.
+	ManifestOnly bool
+	// PreserveSymlinks recreates symlinks in the destination instead of
+	// copying the files they point to.
+	PreserveSymlinks bool
+	// VerboseErrors prints each failure's full error chain.
+	VerboseErrors bool
+	// AssumeYes answers yes to every prompt without asking.
+	AssumeYes bool
+	// JSONFormat controls how the sync manifest and build info are encoded.
+	JSONFormat JSONFormat
+	// Strict fails a skill with file names that differ only by case
+	// instead of warning about them.
+	Strict bool
+	// ExcludeSkills holds the skill names, or plugin/skill keys, read
+	// from -exclude-skills-file; those skills are not synced.
+	ExcludeSkills map[string]bool
+	// BuildInfo writes a generated buildInfoName file into each skill.
+	BuildInfo bool
+	// BuildTime is the build time recorded by BuildInfo, shared by every
+	// skill in the run.
+	BuildTime time.Time
 }
 
 func main() {
@@ -47,24 +192,87 @@ func main() {
 	outputDir := flag.String("output", "", "Output directory for Codex skills (default: ~/.codex/skills)")
 	pluginsDir := flag.String("plugins", "./plugins", "Directory containing Claude plugins")
 	marketplaceFile := flag.String("marketplace", "./.claude-plugin/marketplace.json", "Path to marketplace.json")
+	logFile := flag.String("log-file", "", "Also write all output, timestamped and without colors, to this file")
+	logMaxSize := flag.Int64("log-max-size", 0, "Rotate -log-file to <path>.1 once it would grow past this many bytes; 0 never rotates")
+	watchConfigFlag := flag.Bool("watch-config", false, "Re-run whenever marketplace.json or a file it references changes")
+	lenient := flag.Bool("lenient", false, "Skip malformed plugin entries in marketplace.json with a warning instead of failing")
 	verbose := flag.Bool("verbose", false, "Enable verbose logging")
 	dryRun := flag.Bool("dry-run", false, "Perform a dry run without copying files")
 	projectLevel := flag.Bool("project", false, "Install to .codex/skills in current directory instead of ~/.codex/skills")
 	usePrefix := flag.Bool("prefix", false, "Prefix skill names with plugin name (e.g., core-commit-messages)")
+	timeout := flag.Duration("timeout", 0, "Abort the run if it takes longer than this (e.g., 5m); 0 disables the limit")
+	skipBuild := flag.Bool("skip-build", false, "Do not run plugin build commands")
+	sanitizeNames := flag.Bool("sanitize-names", false, "Slugify Codex skill names (lowercase, hyphens, safe characters only)")
+	nameCase := flag.String("name-case", "preserve", "Case of Codex skill names: preserve, lower, or kebab")
+	pluginsFilter := flag.String("plugins-filter", "", "Comma-separated plugin names to sync; others are skipped (default: all plugins)")
+	buildInfo := flag.Bool("build-info", false, "Write a "+buildInfoName+" file with the source git commit, branch, dirty flag, and build time into each synced skill")
+	outputMode := flag.String("output-mode", "", "Octal permissions for synced files (e.g., 0644); default copies the source mode")
+	manifest := flag.Bool("manifest", false, "Write a "+syncManifestName+" file with the SHA-256 of every synced file into each skill")
+	manifestOnly := flag.Bool("manifest-only", false, "Only refresh the sync manifest of each already-synced skill from its current files")
+	preserveTimes := flag.Bool("preserve-times", false, "Preserve source modification times on synced files and directories")
+	skipNewer := flag.Bool("skip-newer", false, "Keep destination files that are newer than their source instead of overwriting them (requires -preserve-times)")
+	preserveSymlinks := flag.Bool("preserve-symlinks", false, "Recreate symlinks in the destination instead of copying what they point to")
+	verboseErrors := flag.Bool("verbose-errors", false, "Print the full chain of wrapped errors beneath each failure")
+	strict := flag.Bool("strict", false, "Fail instead of warning when a skill entry is outside its plugin source, a skill has file names differing only by case, or two skills' destinations differ only by case")
+	listTargets := flag.Bool("list-targets", false, "Print each skill's source and destination directory and exit without syncing")
+	format := flag.String("format", "text", "Output format for -list-targets and -doctor: text or json")
+	compactJSON := flag.Bool("compact-json", false, "Write generated JSON (-format json output, sync manifests, build info) on a single line instead of indenting it")
+	doctor := flag.Bool("doctor", false, "Check the environment, target directory and marketplace.json, print a report, and exit non-zero if anything is broken")
+	fromStdin := flag.Bool("from-stdin", false, "Only process the skills listed on stdin, one plugin/skill selector per line")
+	excludeSkillsFile := flag.String("exclude-skills-file", "", "Skip the skills named in this file, one skill or plugin/skill per line (# starts a comment)")
+	aliases := flag.Bool("aliases", false, "Link each skill's aliases from frontmatter or marketplace.json to the synced skill")
+	assumeYes := flag.Bool("assume-yes", false, "Answer yes to all prompts (e.g., overwriting a synced skill with local edits)")
+	flag.BoolVar(assumeYes, "y", false, "Shorthand for -assume-yes")
 	flag.Parse()
+	jsonFormat := JSONFormat{Compact: *compactJSON}
 
-	// Determine output directory
-	var targetDir string
-	if *outputDir != "" {
-		targetDir = *outputDir
-	} else if *projectLevel {
-		targetDir = ".codex/skills"
-	} else {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			fatal("Failed to get home directory: %v", err)
+	if *logFile != "" {
+		if *logMaxSize < 0 {
+			fatal("-log-max-size must not be negative")
+		}
+		var err error
+		if logOut, err = startLogOutput(*logFile, *logMaxSize); err != nil {
+			fatal("Failed to open -log-file: %v", err)
+		}
+		defer logOut.Close()
+	}
+
+	if *watchConfigFlag {
+		// Every re-run would need the work list again
+		if *fromStdin {
+			fatal("-from-stdin cannot be combined with -watch-config")
+		}
+		watchConfig(*marketplaceFile)
+		return
+	}
+
+	if *doctor {
+		if *format != "text" && *format != "json" {
+			fatal("Unknown -format %q (expected text or json)", *format)
+		}
+		checks := runDoctor(*marketplaceFile, *lenient, *outputDir, *projectLevel)
+		healthy := doctorHealthy(checks)
+		if *format == "json" {
+			data, err := marshalJSON(struct {
+				Healthy bool          `json:"healthy"`
+				Checks  []DoctorCheck `json:"checks"`
+			}{healthy, checks}, jsonFormat)
+			if err != nil {
+				fatal("Failed to encode report: %v", err)
+			}
+			fmt.Println(string(data))
+		} else {
+			printDoctorReport(checks, healthy)
 		}
-		targetDir = filepath.Join(home, ".codex", "skills")
+		if !healthy {
+			exit(1)
+		}
+		return
+	}
+
+	targetDir, err := resolveTargetDir(*outputDir, *projectLevel)
+	if err != nil {
+		fatal("Failed to resolve target directory: %v", err)
 	}
 
 	// Convert to absolute path
@@ -73,198 +281,2335 @@ func main() {
 		fatal("Failed to resolve target directory: %v", err)
 	}
 
+	opts := &SyncOptions{
+		TargetDir:        absTargetDir,
+		Verbose:          *verbose,
+		DryRun:           *dryRun,
+		UsePrefix:        *usePrefix,
+		PreserveTimes:    *preserveTimes,
+		SkipNewer:        *skipNewer,
+		PreserveSymlinks: *preserveSymlinks,
+		SkipBuild:        *skipBuild,
+		SanitizeNames:    *sanitizeNames,
+		NameCase:         *nameCase,
+		BuildInfo:        *buildInfo,
+		BuildTime:        time.Now(),
+		Manifest:         *manifest || *manifestOnly,
+		ManifestOnly:     *manifestOnly,
+		VerboseErrors:    *verboseErrors,
+		AssumeYes:        *assumeYes,
+		Strict:           *strict,
+		JSONFormat:       jsonFormat,
+	}
+	if opts.OutputMode, err = parseOutputMode(*outputMode); err != nil {
+		fatal("Invalid -output-mode: %v", err)
+	}
+	if opts.NameCase != "preserve" && opts.NameCase != "lower" && opts.NameCase != "kebab" {
+		fatal("Unknown -name-case %q (expected preserve, lower, or kebab)", opts.NameCase)
+	}
+	// Without source times every synced file would look newer than its
+	// source on the next run, and nothing would ever be updated
+	if opts.SkipNewer && !opts.PreserveTimes {
+		fatal("-skip-newer compares modification times and requires -preserve-times")
+	}
+	var selectors []string
+	if *fromStdin {
+		if selectors, err = readSkillSelectors(os.Stdin); err != nil {
+			fatal("Failed to read skill selectors from stdin: %v", err)
+		}
+	}
+	if *excludeSkillsFile != "" {
+		if opts.ExcludeSkills, err = readExcludeSkillsFile(*excludeSkillsFile); err != nil {
+			fatal("Failed to read -exclude-skills-file: %v", err)
+		}
+	}
+
+	if *listTargets {
+		if *format != "text" && *format != "json" {
+			fatal("Unknown -format %q (expected text or json)", *format)
+		}
+		marketplace, err := readMarketplace(*marketplaceFile, *lenient)
+		if err != nil {
+			fatal("Failed to read marketplace.json: %v", err)
+		}
+		targets := listSkillTargets(marketplace, parsePluginsFilter(*pluginsFilter), opts)
+		if *format == "json" {
+			data, err := marshalJSON(struct {
+				TargetDir string        `json:"target_dir"`
+				Skills    []SkillTarget `json:"skills"`
+			}{absTargetDir, targets}, jsonFormat)
+			if err != nil {
+				fatal("Failed to encode targets: %v", err)
+			}
+			fmt.Println(string(data))
+		} else {
+			printSkillTargets(absTargetDir, targets)
+		}
+		return
+	}
+
 	// Print configuration
 	printHeader("Codex Skills Sync")
 	fmt.Printf("%sTarget directory:%s %s\n", colorBlue, colorReset, absTargetDir)
 	fmt.Printf("%sPlugins directory:%s %s\n", colorBlue, colorReset, *pluginsDir)
-	if *dryRun {
+	filter := parsePluginsFilter(*pluginsFilter)
+	if filter != nil {
+		fmt.Printf("%sPlugins filter:%s %s\n", colorBlue, colorReset, *pluginsFilter)
+	}
+	if opts.DryRun {
 		fmt.Printf("%sDry run mode: No files will be modified%s\n", colorYellow, colorReset)
 	}
 	fmt.Println()
 
+	// Fail fast on an unusable target rather than on the first skill
+	if !opts.DryRun {
+		created, err := checkTargetDir(absTargetDir)
+		if err != nil {
+			fatal("%v", err)
+		}
+		if created {
+			fmt.Printf("%s[WARN]%s Target directory %s does not exist; it will be created\n", colorYellow, colorReset, absTargetDir)
+		}
+	}
+
 	// Read marketplace.json
-	marketplace, err := readMarketplace(*marketplaceFile)
+	marketplace, err := readMarketplace(*marketplaceFile, *lenient)
 	if err != nil {
 		fatal("Failed to read marketplace.json: %v", err)
 	}
+	for _, skipErr := range marketplace.Skipped {
+		fmt.Printf("%s[WARN]%s Skipped %v\n", colorYellow, colorReset, skipErr)
+	}
+	if len(marketplace.Skipped) > 0 {
+		fmt.Printf("%s[WARN]%s %d plugin entries skipped due to parse errors\n", colorYellow, colorReset, len(marketplace.Skipped))
+	}
+	for _, warning := range marketplace.Warnings {
+		fmt.Printf("%s[WARN]%s %s\n", colorYellow, colorReset, warning)
+	}
+	if outside := skillPathsOutsideSource(marketplace); len(outside) > 0 {
+		for _, problem := range outside {
+			if *strict {
+				fmt.Printf("%s[ERROR]%s %s\n", colorRed, colorReset, problem)
+			} else {
+				fmt.Printf("%s[WARN]%s %s\n", colorYellow, colorReset, problem)
+			}
+		}
+		if *strict {
+			fatal("%d skill entries are outside their plugin source", len(outside))
+		}
+	}
+	for _, name := range unknownPlugins(filter, marketplace) {
+		fmt.Printf("%s[WARN]%s -plugins-filter names unknown plugin '%s'\n", colorYellow, colorReset, name)
+	}
+	for _, name := range unknownExcludedSkills(opts.ExcludeSkills, marketplace) {
+		fmt.Printf("%s[WARN]%s -exclude-skills-file names unknown skill '%s'\n", colorYellow, colorReset, name)
+	}
+
+	if opts.SanitizeNames || opts.NameCase != "preserve" {
+		original := func(pluginName, skillName string) string {
+			unsanitized := *opts
+			unsanitized.SanitizeNames = false
+			unsanitized.NameCase = "preserve"
+			return syncedSkillName(pluginName, skillName, &unsanitized)
+		}
+		sanitized := func(pluginName, skillName string) string {
+			return syncedSkillName(pluginName, skillName, opts)
+		}
+		if err := checkSanitizedNames(marketplace, original, sanitized, opts.Verbose); err != nil {
+			fatal("Failed to rename skills: %v", err)
+		}
+	}
+
+	// Abort before one skill's sync replaces another's
+	skillDir := func(pluginName, skillName string) string {
+		return filepath.Join(opts.TargetDir, syncedSkillName(pluginName, skillName, opts))
+	}
+	caseClashes, err := checkOutputPaths(marketplace, skillDir)
+	if err != nil {
+		fatal("Output path collision: %v", err)
+	}
+	for _, clash := range caseClashes {
+		if *strict {
+			fatal("Output path collision: %s", clash)
+		}
+		fmt.Printf("%s[WARN]%s %s\n", colorYellow, colorReset, clash)
+	}
+
+	if *fromStdin {
+		for _, warning := range selectSkills(marketplace, selectors) {
+			fmt.Printf("%s[WARN]%s %s\n", colorYellow, colorReset, warning)
+		}
+	}
+
+	var skillAliases []SkillAlias
+	if *aliases {
+		if skillAliases, err = resolveSkillAliases(marketplace, filter, opts); err != nil {
+			fatal("Failed to resolve aliases: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
 
 	// Sync skills
 	stats := &SyncStats{}
 	for _, plugin := range marketplace.Plugins {
-		syncPlugin(plugin, absTargetDir, *verbose, *dryRun, *usePrefix, stats)
+		if filter != nil && !filter[plugin.Name] {
+			fmt.Printf("%s[SKIP]%s Plugin '%s' filtered\n", colorYellow, colorReset, plugin.Name)
+			continue
+		}
+		if err := syncPlugin(ctx, plugin, opts, stats); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				fatal("Timed out after %s", *timeout)
+			}
+			fatal("Failed to sync plugin '%s': %v", plugin.Name, err)
+		}
+	}
+
+	if len(skillAliases) > 0 {
+		fmt.Printf("\n%s=== Linking aliases ===%s\n", colorBlue, colorReset)
+		linkSkillAliases(skillAliases, opts, stats)
 	}
 
 	// Print summary
-	printSummary(stats, *dryRun)
+	printSummary(stats, opts.DryRun)
 }
 
-func readMarketplace(path string) (*MarketplaceConfig, error) {
-	data, err := os.ReadFile(path)
+// resolveTargetDir returns the directory skills are synced to: -output,
+// else .codex/skills for -project, else ~/.codex/skills.
+func resolveTargetDir(outputDir string, projectLevel bool) (string, error) {
+	if outputDir != "" {
+		return outputDir, nil
+	}
+	if projectLevel {
+		return ".codex/skills", nil
+	}
+	home, err := os.UserHomeDir()
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("failed to get home directory: %w", err)
 	}
+	return filepath.Join(home, ".codex", "skills"), nil
+}
 
-	var config MarketplaceConfig
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, err
+// checkTargetDir confirms the target directory can be written to. A target
+// that does not exist yet is fine as long as the closest existing parent is
+// writable, since syncing creates it; created reports that case.
+func checkTargetDir(targetDir string) (created bool, err error) {
+	dir := targetDir
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return false, fmt.Errorf("%s is not a directory; remove it or pass -output to sync somewhere else", dir)
+			}
+			break
+		}
+		// ENOTDIR means a parent is a file; keep climbing to report it
+		if !os.IsNotExist(err) && !errors.Is(err, syscall.ENOTDIR) {
+			return false, fmt.Errorf("cannot access %s: %v", dir, err)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false, fmt.Errorf("no existing parent directory found for %s", targetDir)
+		}
+		dir = parent
 	}
 
-	return &config, nil
+	// Permission bits do not tell the whole story (ACLs, read-only mounts),
+	// so try writing a file
+	probe, err := os.CreateTemp(dir, ".codex-sync-check-*")
+	if err != nil {
+		return false, fmt.Errorf("%s is not writable: %v; fix its permissions or pass -output (or -project) to sync somewhere else", dir, err)
+	}
+	probe.Close()
+	return dir != targetDir, os.Remove(probe.Name())
 }
 
-func syncPlugin(plugin Plugin, targetDir string, verbose bool, dryRun bool, usePrefix bool, stats *SyncStats) {
-	if len(plugin.Skills) == 0 {
-		if verbose {
-			fmt.Printf("%s[SKIP]%s Plugin '%s' has no skills\n", colorYellow, colorReset, plugin.Name)
-		}
-		return
+// DoctorCheck is one entry of the -doctor report. Status is "ok", "warn"
+// or "fail"; only failures make the report unhealthy.
+type DoctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// runDoctor checks everything a sync depends on, using the same target
+// and marketplace resolution as a real run, and never stops early so the
+// report shows every problem at once.
+func runDoctor(marketplaceFile string, lenient bool, outputDir string, projectLevel bool) []DoctorCheck {
+	var checks []DoctorCheck
+	add := func(name, status, detail string) {
+		checks = append(checks, DoctorCheck{Name: name, Status: status, Detail: detail})
 	}
 
-	fmt.Printf("\n%s=== Syncing plugin: %s ===%s\n", colorBlue, plugin.Name, colorReset)
+	add("go", "ok", fmt.Sprintf("%s %s/%s", runtime.Version(), runtime.GOOS, runtime.GOARCH))
 
-	for _, skillPath := range plugin.Skills {
-		// Extract skill name from the path (e.g., "./skills/commit-messages" -> "commit-messages")
-		skillName := filepath.Base(skillPath)
+	if gitPath, err := exec.LookPath("git"); err != nil {
+		add("git", "warn", "git not found on PATH; -build-info cannot record provenance")
+	} else {
+		add("git", "ok", gitPath)
+	}
 
-		// Construct the actual path by combining plugin source with skills directory
-		// e.g., "./plugins/core" + "/skills/" + "commit-messages" = "./plugins/core/skills/commit-messages"
-		actualSkillPath := filepath.Join(plugin.Source, "skills", skillName)
+	// The home directory only matters when it holds the default target
+	if home, err := os.UserHomeDir(); err != nil {
+		status := "warn"
+		if outputDir == "" && !projectLevel {
+			status = "fail"
+		}
+		add("home", status, err.Error())
+	} else {
+		add("home", "ok", home)
+	}
 
-		if err := syncSkill(plugin.Name, actualSkillPath, targetDir, verbose, dryRun, usePrefix, stats); err != nil {
-			fmt.Printf("%s[ERROR]%s Failed to sync %s: %v\n", colorRed, colorReset, skillPath, err)
-			stats.SkillsFailed++
+	if targetDir, err := resolveTargetDir(outputDir, projectLevel); err != nil {
+		add("target", "fail", err.Error())
+	} else if absTargetDir, err := filepath.Abs(targetDir); err != nil {
+		add("target", "fail", err.Error())
+	} else if created, err := checkTargetDir(absTargetDir); err != nil {
+		add("target", "fail", err.Error())
+	} else if created {
+		add("target", "ok", absTargetDir+" (will be created)")
+	} else {
+		add("target", "ok", absTargetDir)
+	}
+
+	marketplace, err := readMarketplace(marketplaceFile, lenient)
+	if err != nil {
+		add("marketplace", "fail", err.Error())
+		return checks
+	}
+	if len(marketplace.Skipped) > 0 {
+		add("marketplace", "warn", fmt.Sprintf("%s: %d plugins, %d entries skipped", marketplaceFile, len(marketplace.Plugins), len(marketplace.Skipped)))
+	} else {
+		add("marketplace", "ok", fmt.Sprintf("%s: %d plugins", marketplaceFile, len(marketplace.Plugins)))
+	}
+
+	for _, plugin := range marketplace.Plugins {
+		source := plugin.Source
+		if archive, _, ok := splitZipPath(source); ok {
+			source = archive
+		}
+		name := "plugin " + plugin.Name
+		if _, err := os.Stat(source); err != nil {
+			add(name, "fail", fmt.Sprintf("source %s: %v", plugin.Source, err))
 		} else {
-			stats.SkillsSynced++
+			add(name, "ok", plugin.Source)
 		}
 	}
+	return checks
 }
 
-func syncSkill(pluginName, skillPath, targetDir string, verbose bool, dryRun bool, usePrefix bool, stats *SyncStats) error {
-	// Extract skill name from path (e.g., "./skills/commit-messages" -> "commit-messages")
-	skillName := filepath.Base(skillPath)
+// doctorHealthy reports whether no check failed.
+func doctorHealthy(checks []DoctorCheck) bool {
+	for _, check := range checks {
+		if check.Status == "fail" {
+			return false
+		}
+	}
+	return true
+}
 
-	// Create Codex skill name (with optional plugin prefix)
-	var codexSkillName string
-	if usePrefix {
-		codexSkillName = fmt.Sprintf("%s-%s", pluginName, skillName)
+// printDoctorReport prints the -doctor checks and the final verdict.
+func printDoctorReport(checks []DoctorCheck, healthy bool) {
+	printHeader("Codex Sync Doctor")
+	for _, check := range checks {
+		switch check.Status {
+		case "fail":
+			fmt.Printf("%s[FAIL]%s %s: %s\n", colorRed, colorReset, check.Name, check.Detail)
+		case "warn":
+			fmt.Printf("%s[WARN]%s %s: %s\n", colorYellow, colorReset, check.Name, check.Detail)
+		default:
+			fmt.Printf("%s[OK]%s   %s: %s\n", colorGreen, colorReset, check.Name, check.Detail)
+		}
+	}
+	if healthy {
+		fmt.Printf("\n%s✓ Healthy%s\n", colorGreen, colorReset)
 	} else {
-		codexSkillName = skillName
+		fmt.Printf("\n%s✗ Unhealthy%s\n", colorRed, colorReset)
 	}
+}
+
+// rawMarketplace mirrors MarketplaceConfig but keeps plugin entries
+// undecoded so "$ref" entries can be resolved.
+type rawMarketplace struct {
+	Name    string            `json:"name"`
+	Owner   Owner             `json:"owner"`
+	Plugins []json.RawMessage `json:"plugins"`
+}
 
-	// Source and destination paths
-	srcDir, err := filepath.Abs(skillPath)
+// readMarketplace reads and resolves marketplace.json. With lenient set,
+// malformed plugin entries are skipped and recorded in Skipped rather than
+// failing the read.
+func readMarketplace(path string, lenient bool) (*MarketplaceConfig, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("failed to resolve source path: %w", err)
+		return nil, err
 	}
 
-	dstDir := filepath.Join(targetDir, codexSkillName)
+	var raw rawMarketplace
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
 
-	// Check if source exists
-	if _, err := os.Stat(srcDir); os.IsNotExist(err) {
-		return fmt.Errorf("source directory does not exist: %s", srcDir)
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
 	}
 
-	// Check if SKILL.md exists
-	skillFile := filepath.Join(srcDir, "SKILL.md")
-	if _, err := os.Stat(skillFile); os.IsNotExist(err) {
-		return fmt.Errorf("SKILL.md not found in %s", srcDir)
+	files := []string{absPath}
+	resolved, skipped, err := resolvePluginEntries(raw.Plugins, filepath.Dir(absPath), []string{absPath}, lenient, &files)
+	if err != nil {
+		return nil, err
 	}
 
-	if verbose {
-		fmt.Printf("  %s → %s\n", srcDir, dstDir)
+	var plugins []Plugin
+	var warnings []string
+	for _, plugin := range resolved {
+		err := expandPluginHome(&plugin)
+		if err == nil && plugin.SkillsFile != "" {
+			files = append(files, plugin.SkillsFile)
+		}
+		var warning string
+		if err == nil {
+			warning, err = inferPluginName(&plugin)
+		}
+		if err == nil {
+			err = mergeSkillsFile(&plugin)
+		}
+		cleanPluginPaths(&plugin)
+		if err != nil {
+			if !lenient {
+				return nil, err
+			}
+			skipped = append(skipped, err)
+			continue
+		}
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+		plugins = append(plugins, plugin)
 	}
 
-	if dryRun {
-		fmt.Printf("%s[DRY RUN]%s Would copy: %s\n", colorYellow, colorReset, codexSkillName)
-		return nil
+	return &MarketplaceConfig{Name: raw.Name, Owner: raw.Owner, Plugins: plugins, Skipped: skipped, Files: files, Warnings: warnings}, nil
+}
+
+// skillPathsOutsideSource describes each skills entry that resolves outside
+// its plugin's source directory. Entries are relative to the source, so an
+// absolute path or one climbing out with ".." is almost always a mistake.
+func skillPathsOutsideSource(marketplace *MarketplaceConfig) []string {
+	var problems []string
+	for _, plugin := range marketplace.Plugins {
+		for _, skillPath := range plugin.Skills {
+			resolved := skillPath
+			if !filepath.IsAbs(resolved) {
+				resolved = filepath.Join(plugin.Source, skillPath)
+			}
+			if !pathWithin(filepath.Clean(plugin.Source), filepath.Clean(resolved)) {
+				problems = append(problems, fmt.Sprintf("Plugin '%s' skill entry %q is outside its source %s", plugin.Name, skillPath, plugin.Source))
+			}
+		}
 	}
+	return problems
+}
 
-	// Remove existing destination if it exists
-	if _, err := os.Lstat(dstDir); err == nil {
-		if err := os.RemoveAll(dstDir); err != nil {
-			return fmt.Errorf("failed to remove existing destination: %w", err)
+// pathWithin reports whether target is dir itself or lies beneath it. The
+// comparison is lexical; symlinks are not resolved.
+func pathWithin(dir, target string) bool {
+	rel, err := filepath.Rel(dir, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// expandHome replaces a leading "~" in path with the user's home directory,
+// as a shell would. Only "~" and "~/..." are expanded; "~user" forms are
+// left as they are.
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot expand %s: %w", path, err)
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// expandPluginHome expands a leading "~" in the plugin's source, skillsFile
+// and skill paths. It runs before anything else looks at those paths, so
+// the rest of the script only ever sees expanded ones.
+func expandPluginHome(plugin *Plugin) error {
+	var err error
+	if plugin.Source, err = expandHome(plugin.Source); err != nil {
+		return fmt.Errorf("plugin %s: %w", plugin.Name, err)
+	}
+	if plugin.SkillsFile, err = expandHome(plugin.SkillsFile); err != nil {
+		return fmt.Errorf("plugin %s: %w", plugin.Name, err)
+	}
+	for i, skillPath := range plugin.Skills {
+		if plugin.Skills[i], err = expandHome(skillPath); err != nil {
+			return fmt.Errorf("plugin %s: %w", plugin.Name, err)
 		}
 	}
+	return nil
+}
 
-	// Ensure parent directory exists
-	parentDir := filepath.Dir(dstDir)
-	if err := os.MkdirAll(parentDir, 0755); err != nil {
-		return fmt.Errorf("failed to create parent directory: %w", err)
+// cleanPluginPaths normalizes the plugin's source and skill paths with
+// filepath.Clean, so "./plugins/core/" and "plugins//core" resolve the
+// same way everywhere. An empty source is left empty for inferPluginName
+// to report.
+func cleanPluginPaths(plugin *Plugin) {
+	if plugin.Source != "" {
+		plugin.Source = filepath.Clean(plugin.Source)
 	}
+	for i, skillPath := range plugin.Skills {
+		plugin.Skills[i] = filepath.Clean(skillPath)
+	}
+}
 
-	// Create destination directory
-	if err := os.MkdirAll(dstDir, 0755); err != nil {
-		return fmt.Errorf("failed to create destination directory: %w", err)
+// inferPluginName fills in a missing plugin name from the last element of
+// its source path, returning a warning describing the inference. The name
+// ends up in zip and directory names, so it must be filesystem-safe.
+func inferPluginName(plugin *Plugin) (string, error) {
+	if plugin.Name != "" {
+		return "", nil
+	}
+	name := filepath.Base(filepath.Clean(plugin.Source))
+	if plugin.Source == "" || name == "." || name == ".." || name == string(filepath.Separator) ||
+		strings.ContainsAny(name, `/\:*?"<>|`) || strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return "", fmt.Errorf("plugin with source %q has no name and none can be inferred from its source", plugin.Source)
+	}
+	plugin.Name = name
+	return fmt.Sprintf("Plugin with source %s has no name; using %q", plugin.Source, name), nil
+}
+
+// watchConfig runs the script in a child process, then polls
+// marketplace.json, the files it references via $ref, and any skillsFile
+// lists, re-running whenever one of them changes. Polling keeps the script
+// dependency-free; changes are debounced so an editor's save sequence only
+// triggers one run. It returns on SIGINT.
+func watchConfig(marketplaceFile string) {
+	exe, err := os.Executable()
+	if err != nil {
+		fatal("Failed to locate executable: %v", err)
 	}
+	args := watchChildArgs()
 
-	// Recursively copy all files
-	fileCount := 0
-	err = filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
 
-		// Get relative path from source directory
-		relPath, err := filepath.Rel(srcDir, path)
-		if err != nil {
-			return err
-		}
+	run := func() {
+		cmd := exec.Command(exe, args...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Run()
+	}
 
-		// Destination path
-		destPath := filepath.Join(dstDir, relPath)
+	run()
+	files := watchedConfigFiles(marketplaceFile, nil)
+	state := statConfigFiles(files)
+	fmt.Printf("\n%sWatching %d config files for changes (Ctrl+C to stop)%s\n", colorBlue, len(files), colorReset)
 
-		// If it's a directory, create it
-		if info.IsDir() {
-			return os.MkdirAll(destPath, info.Mode())
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-interrupt:
+			return
+		case <-ticker.C:
 		}
 
-		// Copy file
-		if err := copyFile(path, destPath); err != nil {
-			return fmt.Errorf("failed to copy %s: %w", relPath, err)
+		current := statConfigFiles(files)
+		changed := changedConfigFiles(state, current)
+		if len(changed) == 0 {
+			continue
 		}
 
-		fileCount++
-		if verbose {
-			fmt.Printf("    %s✓%s Copied: %s\n", colorGreen, colorReset, relPath)
+		// Wait for the files to settle before re-running
+		for {
+			time.Sleep(300 * time.Millisecond)
+			settled := statConfigFiles(files)
+			if len(changedConfigFiles(current, settled)) == 0 {
+				break
+			}
+			current = settled
 		}
 
-		return nil
-	})
+		fmt.Printf("\n%s[RELOAD]%s %s changed\n", colorBlue, colorReset, strings.Join(changed, ", "))
+		run()
+		files = watchedConfigFiles(marketplaceFile, files)
+		state = statConfigFiles(files)
+	}
+}
 
+// watchedConfigFiles lists the config files to watch. If marketplace.json
+// cannot be read (for example mid-edit), the previous list is kept.
+func watchedConfigFiles(marketplaceFile string, previous []string) []string {
+	marketplace, err := readMarketplace(marketplaceFile, true)
 	if err != nil {
-		return err
+		if previous != nil {
+			return previous
+		}
+		return []string{marketplaceFile}
 	}
+	return marketplace.Files
+}
 
-	stats.FilesCreated += fileCount
-	fmt.Printf("%s[SYNCED]%s %s (%d files copied)\n", colorGreen, colorReset, codexSkillName, fileCount)
+// configFileState records enough about a file to notice it changing.
+type configFileState struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
 
-	return nil
+func statConfigFiles(files []string) map[string]configFileState {
+	state := make(map[string]configFileState, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			state[file] = configFileState{modTime: info.ModTime(), size: info.Size(), exists: true}
+		} else {
+			state[file] = configFileState{}
+		}
+	}
+	return state
 }
 
-func copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
-	if err != nil {
-		return err
+func changedConfigFiles(before, after map[string]configFileState) []string {
+	var changed []string
+	for file, state := range after {
+		if prev := before[file]; !prev.modTime.Equal(state.modTime) || prev.size != state.size || prev.exists != state.exists {
+			changed = append(changed, file)
+		}
 	}
-	defer sourceFile.Close()
+	sort.Strings(changed)
+	return changed
+}
 
-	destFile, err := os.Create(dst)
+// mergeSkillsFile appends the skills listed in a plugin's SkillsFile to its
+// inline Skills, skipping duplicates. The file holds one skill path per
+// line; blank lines and lines starting with # are ignored. Like Source, the
+// file path is relative to the working directory.
+func mergeSkillsFile(plugin *Plugin) error {
+	if plugin.SkillsFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(plugin.SkillsFile)
 	if err != nil {
-		return err
+		return fmt.Errorf("plugin %s: failed to read skillsFile: %w", plugin.Name, err)
 	}
-	defer destFile.Close()
 
-	if _, err := io.Copy(destFile, sourceFile); err != nil {
-		return err
+	seen := make(map[string]bool)
+	for _, skill := range plugin.Skills {
+		seen[skill] = true
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || seen[line] {
+			continue
+		}
+		seen[line] = true
+		skillPath, err := expandHome(line)
+		if err != nil {
+			return fmt.Errorf("plugin %s: %w", plugin.Name, err)
+		}
+		plugin.Skills = append(plugin.Skills, skillPath)
 	}
+	return nil
+}
 
-	// Copy file permissions
-	sourceInfo, err := os.Stat(src)
-	if err != nil {
-		return err
+// resolvePluginEntries decodes plugin entries, replacing any
+// {"$ref": "<file>"} entry with the plugin (or array of plugins) defined in
+// that file. Refs are resolved relative to the file containing them and may
+// be nested; chain holds the files currently being resolved so cycles are
+// reported instead of recursing forever. When lenient is set, entries that
+// fail to decode are dropped and their errors returned instead of failing
+// the whole read. Every $ref file read is appended to files.
+func resolvePluginEntries(entries []json.RawMessage, baseDir string, chain []string, lenient bool, files *[]string) ([]Plugin, []error, error) {
+	var plugins []Plugin
+	var skipped []error
+	for i, entry := range entries {
+		resolved, resolvedSkipped, err := resolvePluginEntry(entry, baseDir, chain, lenient, files)
+		if err != nil {
+			err = fmt.Errorf("plugin entry %d: %w", i, err)
+			if !lenient {
+				return nil, nil, err
+			}
+			skipped = append(skipped, err)
+			continue
+		}
+		plugins = append(plugins, resolved...)
+		skipped = append(skipped, resolvedSkipped...)
 	}
 
-	return os.Chmod(dst, sourceInfo.Mode())
+	return plugins, skipped, nil
+}
+
+// resolvePluginEntry decodes a single plugin entry, following it if it is
+// a $ref.
+func resolvePluginEntry(entry json.RawMessage, baseDir string, chain []string, lenient bool, files *[]string) ([]Plugin, []error, error) {
+	var ref struct {
+		Ref string `json:"$ref"`
+	}
+	if err := json.Unmarshal(entry, &ref); err != nil {
+		return nil, nil, err
+	}
+
+	if ref.Ref == "" {
+		var plugin Plugin
+		if err := json.Unmarshal(entry, &plugin); err != nil {
+			return nil, nil, err
+		}
+		return []Plugin{plugin}, nil, nil
+	}
+
+	refPath := ref.Ref
+	if !filepath.IsAbs(refPath) {
+		refPath = filepath.Join(baseDir, refPath)
+	}
+	for _, visiting := range chain {
+		if visiting == refPath {
+			return nil, nil, fmt.Errorf("cyclic $ref: %s -> %s", strings.Join(chain, " -> "), refPath)
+		}
+	}
+
+	*files = append(*files, refPath)
+	data, err := os.ReadFile(refPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read $ref %s: %w", ref.Ref, err)
+	}
+
+	// A referenced file holds either a single plugin or an array of them
+	var children []json.RawMessage
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &children); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse $ref %s: %w", ref.Ref, err)
+		}
+	} else {
+		children = []json.RawMessage{trimmed}
+	}
+
+	resolved, skipped, err := resolvePluginEntries(children, filepath.Dir(refPath), append(chain[:len(chain):len(chain)], refPath), lenient, files)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", ref.Ref, err)
+	}
+	for i, skipErr := range skipped {
+		skipped[i] = fmt.Errorf("%s: %w", ref.Ref, skipErr)
+	}
+	return resolved, skipped, nil
+}
+
+// parsePluginsFilter turns the comma-separated -plugins-filter value into a
+// set of plugin names, or nil when every plugin should be synced.
+func parsePluginsFilter(value string) map[string]bool {
+	var filter map[string]bool
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			if filter == nil {
+				filter = make(map[string]bool)
+			}
+			filter[name] = true
+		}
+	}
+	return filter
+}
+
+// unknownPlugins returns the names in filter that match no plugin in the
+// marketplace, sorted.
+func unknownPlugins(filter map[string]bool, marketplace *MarketplaceConfig) []string {
+	known := make(map[string]bool)
+	for _, plugin := range marketplace.Plugins {
+		known[plugin.Name] = true
+	}
+	var unknown []string
+	for name := range filter {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// syncPlugin syncs each of a plugin's skills. Individual skill failures are
+// counted in stats; an error is only returned when the context is cancelled.
+func syncPlugin(ctx context.Context, plugin Plugin, opts *SyncOptions, stats *SyncStats) error {
+	if len(plugin.Skills) == 0 {
+		if opts.Verbose {
+			fmt.Printf("%s[SKIP]%s Plugin '%s' has no skills\n", colorYellow, colorReset, plugin.Name)
+		}
+		return nil
+	}
+
+	fmt.Printf("\n%s=== Syncing plugin: %s ===%s\n", colorBlue, plugin.Name, colorReset)
+
+	// Run the plugin's build step; if it fails none of its skills can be
+	// trusted, so they are all counted as failed
+	if plugin.Build != "" && !opts.SkipBuild && !opts.ManifestOnly {
+		if opts.DryRun {
+			fmt.Printf("%s[DRY RUN]%s Would run build: %s\n", colorYellow, colorReset, plugin.Build)
+		} else {
+			fmt.Printf("%s[BUILD]%s %s\n", colorBlue, colorReset, plugin.Build)
+			if err := runPluginBuild(ctx, plugin, opts.Verbose); err != nil {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return ctxErr
+				}
+				fmt.Printf("%s[ERROR]%s Plugin '%s' %v\n", colorRed, colorReset, plugin.Name, err)
+				printErrorChain(err, opts)
+				stats.SkillsFailed += len(plugin.Skills)
+				return nil
+			}
+		}
+	}
+
+	for _, skillPath := range plugin.Skills {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Extract skill name from the path (e.g., "./skills/commit-messages" -> "commit-messages")
+		skillName := filepath.Base(skillPath)
+
+		if skillExcluded(plugin.Name, skillName, opts) {
+			fmt.Printf("%s[SKIP]%s %s excluded\n", colorYellow, colorReset, skillName)
+			stats.SkillsExcluded++
+			continue
+		}
+
+		// Merged skills are only assembled by package-skills.go
+		if _, ok := plugin.Merged[skillName]; ok {
+			fmt.Printf("%s[ERROR]%s Failed to sync %s: merged skills are not supported; package them with package-skills.go first\n", colorRed, colorReset, skillName)
+			stats.SkillsFailed++
+			continue
+		}
+
+		// Construct the actual path by combining plugin source with skills directory
+		// e.g., "./plugins/core" + "/skills/" + "commit-messages" = "./plugins/core/skills/commit-messages"
+		actualSkillPath := filepath.Join(plugin.Source, "skills", skillName)
+
+		if err := syncSkill(ctx, plugin.Name, actualSkillPath, opts, stats); errors.Is(err, errLocalEditsKept) {
+			fmt.Printf("%s[SKIP]%s %s: local edits kept\n", colorYellow, colorReset, skillName)
+			stats.SkillsKept++
+		} else if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			fmt.Printf("%s[ERROR]%s Failed to sync %s: %v\n", colorRed, colorReset, skillPath, err)
+			printErrorChain(err, opts)
+			stats.SkillsFailed++
+		} else if !opts.ManifestOnly {
+			stats.SkillsSynced++
+			stats.SyncedNames = append(stats.SyncedNames, syncedSkillName(plugin.Name, skillName, opts))
+		}
+	}
+
+	return nil
+}
+
+// errorChain describes each error in err's Unwrap chain on its own line:
+// its concrete type and the part of its message not repeated by the error
+// it wraps.
+func errorChain(err error) []string {
+	var lines []string
+	for err != nil {
+		next := errors.Unwrap(err)
+		msg := err.Error()
+		if next != nil {
+			msg = strings.TrimSuffix(strings.TrimSuffix(msg, next.Error()), ": ")
+		}
+		lines = append(lines, fmt.Sprintf("%T: %s", err, msg))
+		err = next
+	}
+	return lines
+}
+
+// printErrorChain prints err's chain beneath its [ERROR] line under
+// -verbose-errors, indenting each wrapped error one step further.
+func printErrorChain(err error, opts *SyncOptions) {
+	if !opts.VerboseErrors {
+		return
+	}
+	for i, line := range errorChain(err) {
+		fmt.Printf("    %s%s\n", strings.Repeat("  ", i), line)
+	}
+}
+
+// readSkillSelectors reads the -from-stdin work list: one plugin/skill
+// selector per line, with blank lines and # comments ignored.
+func readSkillSelectors(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var selectors []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		selectors = append(selectors, line)
+	}
+	return selectors, nil
+}
+
+// selectSkills narrows the marketplace to the skills named by selectors
+// and returns a warning for each selector that is malformed or matches no
+// skill. Plugins left without skills are dropped.
+func selectSkills(marketplace *MarketplaceConfig, selectors []string) []string {
+	var warnings []string
+	wanted := make(map[string]bool)
+	for _, selector := range selectors {
+		pluginName, skillName, ok := strings.Cut(selector, "/")
+		if !ok || pluginName == "" || skillName == "" || strings.Contains(skillName, "/") {
+			warnings = append(warnings, fmt.Sprintf("Skipped invalid selector %q (expected plugin/skill)", selector))
+			continue
+		}
+		wanted[selector] = true
+	}
+
+	found := make(map[string]bool)
+	var plugins []Plugin
+	for _, plugin := range marketplace.Plugins {
+		var skills []string
+		for _, skillPath := range plugin.Skills {
+			key := plugin.Name + "/" + filepath.Base(skillPath)
+			if wanted[key] {
+				skills = append(skills, skillPath)
+				found[key] = true
+			}
+		}
+		if len(skills) > 0 {
+			plugin.Skills = skills
+			plugins = append(plugins, plugin)
+		}
+	}
+	marketplace.Plugins = plugins
+
+	for _, selector := range selectors {
+		if wanted[selector] && !found[selector] {
+			warnings = append(warnings, fmt.Sprintf("Skipped selector %q: no such skill in marketplace.json", selector))
+			found[selector] = true
+		}
+	}
+	return warnings
+}
+
+// readExcludeSkillsFile reads an -exclude-skills-file: one skill name, or
+// plugin/skill key, per line, with blank lines and # comments ignored.
+func readExcludeSkillsFile(path string) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	excluded := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		excluded[line] = true
+	}
+	return excluded, nil
+}
+
+// skillExcluded reports whether -exclude-skills-file names the skill,
+// either on its own or as plugin/skill.
+func skillExcluded(pluginName, skillName string, opts *SyncOptions) bool {
+	return opts.ExcludeSkills[skillName] || opts.ExcludeSkills[pluginName+"/"+skillName]
+}
+
+// unknownExcludedSkills returns the entries of an exclusion list that match
+// no skill in the marketplace, sorted.
+func unknownExcludedSkills(excluded map[string]bool, marketplace *MarketplaceConfig) []string {
+	known := make(map[string]bool)
+	for _, plugin := range marketplace.Plugins {
+		for _, skillPath := range plugin.Skills {
+			skillName := filepath.Base(skillPath)
+			known[skillName] = true
+			known[plugin.Name+"/"+skillName] = true
+		}
+	}
+	var unknown []string
+	for name := range excluded {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// syncedSkillName returns the Codex skill directory name for a skill, with
+// the plugin prefix applied when requested.
+func syncedSkillName(pluginName, skillName string, opts *SyncOptions) string {
+	name := skillName
+	if opts.UsePrefix {
+		name = fmt.Sprintf("%s-%s", pluginName, skillName)
+	}
+	name = applyNameCase(name, opts.NameCase)
+	if opts.SanitizeNames {
+		return slugifyName(name)
+	}
+	return name
+}
+
+// applyNameCase converts name to a -name-case style: "lower" lowercases
+// it, "kebab" also splits camelCase words and turns underscores and spaces
+// into hyphens, and "preserve" leaves it unchanged.
+func applyNameCase(name, nameCase string) string {
+	switch nameCase {
+	case "lower":
+		return strings.ToLower(name)
+	case "kebab":
+		return kebabCase(name)
+	}
+	return name
+}
+
+// kebabCase lowercases name with hyphens between words, treating case
+// changes, underscores, and spaces as word boundaries. A run of capitals is
+// kept as one word, so "HTTPServer" becomes "http-server".
+func kebabCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	hyphen := true // suppresses leading and repeated hyphens
+	for i, r := range runes {
+		if r == '_' || r == ' ' || r == '-' {
+			if !hyphen {
+				b.WriteRune('-')
+				hyphen = true
+			}
+			continue
+		}
+		if unicode.IsUpper(r) && !hyphen && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteRune('-')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+		hyphen = false
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// slugifyName lowercases name, turns spaces and underscores into hyphens,
+// and drops anything other than ASCII letters, digits, '.', and '-', so the
+// result is safe as a file name on any filesystem.
+func slugifyName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.':
+			b.WriteRune(r)
+		case r == '-' || r == ' ' || r == '_':
+			if s := b.String(); s != "" && !strings.HasSuffix(s, "-") {
+				b.WriteRune('-')
+			}
+		}
+	}
+	return strings.Trim(b.String(), "-.")
+}
+
+// checkSanitizedNames reports each skill whose sanitized or re-cased name
+// differs from its original name (under verbose) and fails if the change
+// leaves a name empty or gives two skills the same name.
+func checkSanitizedNames(marketplace *MarketplaceConfig, original, sanitized func(pluginName, skillName string) string, verbose bool) error {
+	owners := make(map[string]string)
+	for _, plugin := range marketplace.Plugins {
+		for _, skillPath := range plugin.Skills {
+			skillName := filepath.Base(skillPath)
+			skill := plugin.Name + "/" + skillName
+			before, after := original(plugin.Name, skillName), sanitized(plugin.Name, skillName)
+			if after == "" {
+				return fmt.Errorf("%s has no usable characters in its name", skill)
+			}
+			if owner, ok := owners[after]; ok {
+				return fmt.Errorf("%s and %s would both be named %q", owner, skill, after)
+			}
+			owners[after] = skill
+			if verbose && before != after {
+				fmt.Printf("%s[RENAMED]%s %s -> %s\n", colorBlue, colorReset, before, after)
+			}
+		}
+	}
+	return nil
+}
+
+// skillDirs resolves a skill's Codex name, absolute source directory and
+// destination directory.
+func skillDirs(pluginName, skillPath string, opts *SyncOptions) (codexSkillName, srcDir, dstDir string, err error) {
+	// Extract skill name from path (e.g., "./skills/commit-messages" -> "commit-messages")
+	skillName := filepath.Base(skillPath)
+
+	// Create Codex skill name (with optional plugin prefix)
+	codexSkillName = syncedSkillName(pluginName, skillName, opts)
+
+	srcDir, err = filepath.Abs(skillPath)
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to resolve source path %s: %w", skillPath, err)
+	}
+	return codexSkillName, srcDir, filepath.Join(opts.TargetDir, codexSkillName), nil
+}
+
+// resolveSkillRoot checks that the skill directory srcDir exists and, when
+// it is a symlink, returns the directory it points to. Walks do not follow
+// a symlinked root, so without this a linked skill would look empty. A
+// broken link is reported as such rather than as a missing directory.
+func resolveSkillRoot(srcDir string) (string, error) {
+	info, err := os.Lstat(srcDir)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("source directory does not exist: %s", srcDir)
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return srcDir, nil
+	}
+
+	target, err := filepath.EvalSymlinks(srcDir)
+	if err != nil {
+		link, _ := os.Readlink(srcDir)
+		return "", fmt.Errorf("skill directory %s is a broken symlink to %s", srcDir, link)
+	}
+	if info, err := os.Stat(target); err != nil {
+		return "", err
+	} else if !info.IsDir() {
+		return "", fmt.Errorf("skill directory %s links to %s, which is not a directory", srcDir, target)
+	}
+	return target, nil
+}
+
+// checkOutputPaths fails when two skills would be written to the same
+// output path. Paths differing only by case share one path on macOS and
+// Windows but not elsewhere, so those clashes are returned as warnings for
+// the caller to report, or to treat as fatal under -strict.
+func checkOutputPaths(marketplace *MarketplaceConfig, outputPath func(pluginName, skillName string) string) ([]string, error) {
+	type owner struct{ skill, path string }
+	exact := make(map[string]string)
+	folded := make(map[string]owner)
+	var caseClashes []string
+	for _, plugin := range marketplace.Plugins {
+		for _, skillPath := range plugin.Skills {
+			skillName := filepath.Base(skillPath)
+			skill := plugin.Name + "/" + skillName
+			path := outputPath(plugin.Name, skillName)
+			if first, ok := exact[path]; ok {
+				return nil, fmt.Errorf("%s and %s would both be written to %s", first, skill, path)
+			}
+			exact[path] = skill
+			first, ok := folded[strings.ToLower(path)]
+			if !ok {
+				folded[strings.ToLower(path)] = owner{skill, path}
+				continue
+			}
+			caseClashes = append(caseClashes, fmt.Sprintf("%s and %s would be written to %s and %s, the same path on case-insensitive filesystems", first.skill, skill, first.path, path))
+		}
+	}
+	return caseClashes, nil
+}
+
+// SkillTarget is one skill's entry in -list-targets output.
+type SkillTarget struct {
+	Plugin      string `json:"plugin"`
+	Skill       string `json:"skill"`
+	Source      string `json:"source"`
+	Destination string `json:"destination,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// listSkillTargets resolves where each skill would be synced from and to,
+// using the same path logic as syncSkill.
+func listSkillTargets(marketplace *MarketplaceConfig, filter map[string]bool, opts *SyncOptions) []SkillTarget {
+	targets := []SkillTarget{}
+	for _, plugin := range marketplace.Plugins {
+		if filter != nil && !filter[plugin.Name] {
+			continue
+		}
+		for _, skillPath := range plugin.Skills {
+			skillName := filepath.Base(skillPath)
+			if skillExcluded(plugin.Name, skillName, opts) {
+				continue
+			}
+			target := SkillTarget{Plugin: plugin.Name, Skill: skillName}
+			actualSkillPath := filepath.Join(plugin.Source, "skills", skillName)
+			if _, ok := plugin.Merged[skillName]; ok {
+				target.Source = actualSkillPath
+				target.Error = "merged skills are not supported; package them with package-skills.go first"
+			} else if _, srcDir, dstDir, err := skillDirs(plugin.Name, actualSkillPath, opts); err != nil {
+				target.Source = actualSkillPath
+				target.Error = err.Error()
+			} else {
+				target.Source = srcDir
+				target.Destination = dstDir
+			}
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+func printSkillTargets(targetDir string, targets []SkillTarget) {
+	fmt.Printf("%sTarget directory:%s %s\n", colorBlue, colorReset, targetDir)
+	for _, target := range targets {
+		fmt.Printf("\n%s=== %s/%s ===%s\n", colorBlue, target.Plugin, target.Skill, colorReset)
+		if target.Error != "" {
+			fmt.Printf("%s[ERROR]%s %s\n", colorRed, colorReset, target.Error)
+			continue
+		}
+		fmt.Printf("  from: %s\n", target.Source)
+		fmt.Printf("  to:   %s\n", target.Destination)
+	}
+	fmt.Printf("\n%sTotal:%s %d skills\n", colorBlue, colorReset, len(targets))
+}
+
+func syncSkill(ctx context.Context, pluginName, skillPath string, opts *SyncOptions, stats *SyncStats) error {
+	codexSkillName, srcDir, dstDir, err := skillDirs(pluginName, skillPath, opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.ManifestOnly {
+		return refreshSyncManifest(codexSkillName, dstDir, opts, stats)
+	}
+
+	// Skills inside a zip archive are extracted rather than copied
+	if archive, inner, ok := splitZipPath(srcDir); ok {
+		return syncSkillFromZip(ctx, archive, inner, codexSkillName, dstDir, opts, stats)
+	}
+
+	// Check if source exists, following a symlinked skill directory
+	if srcDir, err = resolveSkillRoot(srcDir); err != nil {
+		return err
+	}
+
+	// Check if SKILL.md exists
+	skillFile := filepath.Join(srcDir, "SKILL.md")
+	if _, err := os.Stat(skillFile); os.IsNotExist(err) {
+		return fmt.Errorf("SKILL.md not found in %s", srcDir)
+	}
+
+	if opts.Verbose {
+		fmt.Printf("  %s → %s\n", srcDir, dstDir)
+	}
+
+	if opts.DryRun {
+		return previewSync(srcDir, dstDir, codexSkillName, opts)
+	}
+
+	// Remove existing destination if it exists. With -skip-newer it is
+	// moved aside instead, so newer files can be carried over and the old
+	// copy put back if the sync fails.
+	var previous string
+	if _, err := os.Lstat(dstDir); err == nil {
+		if !confirmOverwrite(codexSkillName, dstDir, opts) {
+			return errLocalEditsKept
+		}
+		if opts.SkipNewer {
+			aside, err := os.MkdirTemp(filepath.Dir(dstDir), "."+filepath.Base(dstDir)+".old-")
+			if err != nil {
+				return fmt.Errorf("failed to move existing destination %s aside: %w", dstDir, err)
+			}
+			defer os.RemoveAll(aside)
+			previous = filepath.Join(aside, filepath.Base(dstDir))
+			if err := os.Rename(dstDir, previous); err != nil {
+				return fmt.Errorf("failed to move existing destination %s aside: %w", dstDir, err)
+			}
+		} else if err := os.RemoveAll(dstDir); err != nil {
+			return fmt.Errorf("failed to remove existing destination %s: %w", dstDir, err)
+		}
+	}
+
+	// Don't leave a partially synced skill behind, and put back the copy
+	// moved aside before the deferred cleanup above deletes it
+	synced := false
+	defer func() {
+		if !synced {
+			os.RemoveAll(dstDir)
+			if previous != "" {
+				os.Rename(previous, dstDir)
+			}
+		}
+	}()
+
+	// Ensure parent directory exists
+	parentDir := filepath.Dir(dstDir)
+	if err := os.MkdirAll(parentDir, 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory %s: %w", parentDir, err)
+	}
+
+	// Create destination directory
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory %s: %w", dstDir, err)
+	}
+
+	// Recursively copy all files
+	fileCount := 0
+	skippedNewer := 0
+	var dirs []string
+	folded := make(map[string]string) // lowercased relative path -> relative path
+	err = filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Stop between files once the run's deadline has passed
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Get relative path from source directory
+		relPath, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+
+		// Destination path
+		destPath := filepath.Join(dstDir, relPath)
+
+		// Case-insensitive filesystems keep only one of the two
+		if !info.IsDir() {
+			if other, ok := folded[strings.ToLower(relPath)]; ok {
+				stats.CaseCollisions++
+				if opts.Strict {
+					return fmt.Errorf("%s and %s differ only by case", other, relPath)
+				}
+				fmt.Printf("%s[WARN]%s %s and %s differ only by case; one overwrites the other on macOS or Windows\n", colorYellow, colorReset, other, relPath)
+			} else {
+				folded[strings.ToLower(relPath)] = relPath
+			}
+		}
+
+		// If it's a directory, create it
+		if info.IsDir() {
+			dirs = append(dirs, relPath)
+			return os.MkdirAll(destPath, info.Mode())
+		}
+
+		// Walk does not follow symlinks, so they arrive here as entries
+		// of their own; the target is kept exactly as written
+		if opts.PreserveSymlinks && info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return fmt.Errorf("failed to read link %s: %w", relPath, err)
+			}
+			if err := os.Symlink(target, destPath); err != nil {
+				return fmt.Errorf("failed to link %s: %w", relPath, err)
+			}
+			fileCount++
+			if opts.Verbose {
+				fmt.Printf("    %s✓%s Linked: %s → %s\n", colorGreen, colorReset, relPath, target)
+			}
+			return nil
+		}
+
+		if previous != "" {
+			kept, err := keepNewerFile(filepath.Join(previous, relPath), destPath, info)
+			if err != nil {
+				return fmt.Errorf("failed to keep %s: %w", relPath, err)
+			}
+			if kept {
+				skippedNewer++
+				fmt.Printf("    %s[SKIP]%s %s: dest newer\n", colorYellow, colorReset, relPath)
+				return nil
+			}
+		}
+
+		// Copy file
+		if err := copyFile(path, destPath, opts); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", relPath, err)
+		}
+
+		fileCount++
+		if opts.Verbose {
+			fmt.Printf("    %s✓%s Copied: %s\n", colorGreen, colorReset, relPath)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// Directory times change whenever an entry is created inside them, so
+	// they can only be restored once every file has been copied
+	if opts.PreserveTimes {
+		for _, relPath := range dirs {
+			info, err := os.Stat(filepath.Join(srcDir, relPath))
+			if err != nil {
+				return err
+			}
+			if err := os.Chtimes(filepath.Join(dstDir, relPath), info.ModTime(), info.ModTime()); err != nil {
+				return fmt.Errorf("failed to preserve times on %s: %w", relPath, err)
+			}
+		}
+	}
+
+	if opts.BuildInfo {
+		if err := writeBuildInfo(srcDir, codexSkillName, dstDir, opts); err != nil {
+			return err
+		}
+		fileCount++
+	}
+
+	if err := finishSyncedSkill(codexSkillName, dstDir, opts); err != nil {
+		return err
+	}
+	synced = true
+
+	stats.FilesCreated += fileCount
+	stats.FilesSkippedNewer += skippedNewer
+	fmt.Printf("%s[SYNCED]%s %s (%d files copied)\n", colorGreen, colorReset, codexSkillName, fileCount)
+
+	return nil
+}
+
+// keepNewerFile copies the previous destination file to dst, keeping its
+// mode and modification time, when it was modified after the source file
+// described by info. It reports whether the previous file was kept. The
+// previous copy is left in place so a failed sync can restore it whole.
+func keepNewerFile(previous, dst string, info os.FileInfo) (bool, error) {
+	prevInfo, err := os.Lstat(previous)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !prevInfo.Mode().IsRegular() || !prevInfo.ModTime().After(info.ModTime()) {
+		return false, nil
+	}
+	return true, copyFile(previous, dst, &SyncOptions{PreserveTimes: true})
+}
+
+// SkillAlias is an alternative name linked to a synced skill.
+type SkillAlias struct {
+	Alias string
+	Skill string
+}
+
+// resolveSkillAliases collects the aliases of every skill that will be
+// synced, from the plugin's "aliases" override or else the skill's
+// frontmatter. An alias may not name a synced skill or another alias.
+func resolveSkillAliases(marketplace *MarketplaceConfig, filter map[string]bool, opts *SyncOptions) ([]SkillAlias, error) {
+	skills := map[string]bool{}
+	for _, plugin := range marketplace.Plugins {
+		if filter != nil && !filter[plugin.Name] {
+			continue
+		}
+		for _, skillPath := range plugin.Skills {
+			skills[syncedSkillName(plugin.Name, filepath.Base(skillPath), opts)] = true
+		}
+	}
+
+	var aliases []SkillAlias
+	owners := map[string]string{}
+	for _, plugin := range marketplace.Plugins {
+		if filter != nil && !filter[plugin.Name] {
+			continue
+		}
+		for _, skillPath := range plugin.Skills {
+			skillName := filepath.Base(skillPath)
+			if _, ok := plugin.Merged[skillName]; ok || skillExcluded(plugin.Name, skillName, opts) {
+				continue
+			}
+			names, ok := plugin.Aliases[skillName]
+			if !ok {
+				var err error
+				if names, err = readSkillAliases(filepath.Join(plugin.Source, "skills", skillName)); err != nil {
+					return nil, err
+				}
+			}
+			codexSkillName := syncedSkillName(plugin.Name, skillName, opts)
+			for _, alias := range names {
+				if alias == "" || alias == "." || alias == ".." || strings.ContainsAny(alias, `/\`) {
+					return nil, fmt.Errorf("skill '%s' has an invalid alias %q", codexSkillName, alias)
+				}
+				if skills[alias] {
+					return nil, fmt.Errorf("alias '%s' of skill '%s' is the name of a synced skill", alias, codexSkillName)
+				}
+				if owner, ok := owners[alias]; ok {
+					return nil, fmt.Errorf("alias '%s' is claimed by both '%s' and '%s'", alias, owner, codexSkillName)
+				}
+				owners[alias] = codexSkillName
+				aliases = append(aliases, SkillAlias{Alias: alias, Skill: codexSkillName})
+			}
+		}
+	}
+	return aliases, nil
+}
+
+// readSkillAliases reads the "aliases" frontmatter list of the skill in
+// skillDir. Skills inside zip archives, and skills without a SKILL.md,
+// have no aliases of their own; syncing reports the missing file.
+func readSkillAliases(skillDir string) ([]string, error) {
+	if _, _, ok := splitZipPath(skillDir); ok {
+		return nil, nil
+	}
+	data, err := os.ReadFile(filepath.Join(skillDir, "SKILL.md"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	frontmatter, err := parseFrontmatter(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid frontmatter in %s/SKILL.md: %w", skillDir, err)
+	}
+	return frontmatter.List("aliases"), nil
+}
+
+// Frontmatter holds the YAML frontmatter of a SKILL.md file. Only the
+// subset of YAML that skills use is supported: plain or quoted scalars,
+// folded (>) and literal (|) block scalars, and flow ([a, b]) or block
+// (- a) lists of scalars. Nested maps, such as metadata, are skipped.
+type Frontmatter struct {
+	scalars map[string]string
+	lists   map[string][]string
+}
+
+// String returns the scalar value of key, or "" when it is not set.
+func (f *Frontmatter) String(key string) string {
+	return f.scalars[key]
+}
+
+// List returns the list value of key. A scalar value is returned as a
+// single-element list.
+func (f *Frontmatter) List(key string) []string {
+	if list, ok := f.lists[key]; ok {
+		return list
+	}
+	if value, ok := f.scalars[key]; ok && value != "" {
+		return []string{value}
+	}
+	return nil
+}
+
+// Has reports whether key is present.
+func (f *Frontmatter) Has(key string) bool {
+	_, isScalar := f.scalars[key]
+	_, isList := f.lists[key]
+	return isScalar || isList
+}
+
+// parseFrontmatter extracts the frontmatter block delimited by "---" lines
+// at the top of a markdown file. A file without frontmatter yields an empty
+// result.
+func parseFrontmatter(data []byte) (*Frontmatter, error) {
+	frontmatter := &Frontmatter{scalars: map[string]string{}, lists: map[string][]string{}}
+
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != "---" {
+		return frontmatter, nil
+	}
+
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "---" {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return nil, fmt.Errorf("missing closing --- delimiter")
+	}
+
+	body := lines[1:end]
+	for i := 0; i < len(body); i++ {
+		line := body[i]
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("line %d: unexpected indentation", i+2)
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", i+2)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		// Collect the indented lines that belong to this key
+		var block []string
+		for i+1 < len(body) && (strings.TrimSpace(body[i+1]) == "" || body[i+1][0] == ' ' || body[i+1][0] == '\t') {
+			i++
+			block = append(block, body[i])
+		}
+
+		switch {
+		case value == ">" || value == ">-" || value == "|" || value == "|-":
+			var parts []string
+			for _, l := range block {
+				parts = append(parts, strings.TrimSpace(l))
+			}
+			separator := " "
+			if value[0] == '|' {
+				separator = "\n"
+			}
+			frontmatter.scalars[key] = strings.TrimSpace(strings.Join(parts, separator))
+		case strings.HasPrefix(value, "["):
+			if !strings.HasSuffix(value, "]") {
+				return nil, fmt.Errorf("line %d: unterminated list for %q", i+2, key)
+			}
+			list := []string{}
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				if item = unquoteYAML(strings.TrimSpace(item)); item != "" {
+					list = append(list, item)
+				}
+			}
+			frontmatter.lists[key] = list
+		case value == "" && isNestedMap(block):
+			// Nested maps are valid frontmatter but nothing reads them
+			continue
+		case value == "" && len(block) > 0:
+			list := []string{}
+			for _, l := range block {
+				item := strings.TrimSpace(l)
+				if item == "" {
+					continue
+				}
+				if !strings.HasPrefix(item, "-") {
+					return nil, fmt.Errorf("expected list item under %q, got %q", key, item)
+				}
+				list = append(list, unquoteYAML(strings.TrimSpace(item[1:])))
+			}
+			frontmatter.lists[key] = list
+		default:
+			// Plain scalars may continue on indented lines
+			parts := []string{unquoteYAML(value)}
+			for _, l := range block {
+				if l = strings.TrimSpace(l); l != "" {
+					parts = append(parts, l)
+				}
+			}
+			frontmatter.scalars[key] = strings.Join(parts, " ")
+		}
+	}
+
+	return frontmatter, nil
+}
+
+// isNestedMap reports whether the indented block under a key holds
+// "key: value" lines rather than list items.
+func isNestedMap(block []string) bool {
+	for _, line := range block {
+		if line = strings.TrimSpace(line); line != "" {
+			return !strings.HasPrefix(line, "-") && strings.Contains(line, ":")
+		}
+	}
+	return false
+}
+
+// unquoteYAML strips matching single or double quotes from a scalar.
+func unquoteYAML(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// linkSkillAliases points a symlink named after each alias at its synced
+// skill. Links left by an earlier run are replaced, but anything else
+// already at an alias's path is reported and kept.
+func linkSkillAliases(aliases []SkillAlias, opts *SyncOptions, stats *SyncStats) {
+	synced := map[string]bool{}
+	for _, name := range stats.SyncedNames {
+		synced[name] = true
+	}
+	for _, alias := range aliases {
+		if !synced[alias.Skill] {
+			fmt.Printf("%s[SKIP]%s Alias '%s': skill '%s' was not synced\n", colorYellow, colorReset, alias.Alias, alias.Skill)
+			continue
+		}
+		linkPath := filepath.Join(opts.TargetDir, alias.Alias)
+		if info, err := os.Lstat(linkPath); err == nil {
+			if info.Mode()&os.ModeSymlink == 0 {
+				fmt.Printf("%s[ERROR]%s Alias '%s': %s already exists and is not an alias link\n", colorRed, colorReset, alias.Alias, linkPath)
+				continue
+			}
+			if !opts.DryRun {
+				if err := os.Remove(linkPath); err != nil {
+					fmt.Printf("%s[ERROR]%s Alias '%s': %v\n", colorRed, colorReset, alias.Alias, err)
+					continue
+				}
+			}
+		}
+		if opts.DryRun {
+			fmt.Printf("%s[DRY RUN]%s Would link alias %s → %s\n", colorYellow, colorReset, alias.Alias, alias.Skill)
+			continue
+		}
+		// The link is relative so the target directory can be moved
+		if err := os.Symlink(alias.Skill, linkPath); err != nil {
+			fmt.Printf("%s[ERROR]%s Alias '%s': %v\n", colorRed, colorReset, alias.Alias, err)
+			continue
+		}
+		stats.AliasesLinked++
+		fmt.Printf("%s[ALIAS]%s %s → %s\n", colorGreen, colorReset, alias.Alias, alias.Skill)
+	}
+}
+
+// runPluginBuild runs a plugin's build command in its Source directory.
+// Output is streamed under -verbose and otherwise included in the error
+// when the build fails.
+func runPluginBuild(ctx context.Context, plugin Plugin, verbose bool) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", plugin.Build)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", plugin.Build)
+	}
+	cmd.Dir = plugin.Source
+
+	var output bytes.Buffer
+	if verbose {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	} else {
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+	}
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(output.String()); msg != "" {
+			return fmt.Errorf("build %q failed: %w\n%s", plugin.Build, err, msg)
+		}
+		return fmt.Errorf("build %q failed: %w", plugin.Build, err)
+	}
+	return nil
+}
+
+// splitZipPath splits a path that runs through a .zip file into the archive
+// path and the slash-separated path inside it. ok is false when no parent
+// of p is a zip file.
+func splitZipPath(p string) (archive, inner string, ok bool) {
+	parts := strings.Split(filepath.ToSlash(p), "/")
+	for i := len(parts) - 1; i > 0; i-- {
+		if !strings.EqualFold(path.Ext(parts[i-1]), ".zip") {
+			continue
+		}
+		candidate := filepath.FromSlash(strings.Join(parts[:i], "/"))
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+			return candidate, strings.Join(parts[i:], "/"), true
+		}
+	}
+	return "", "", false
+}
+
+// syncSkillFromZip extracts the skill directory prefix from a zip archive
+// into dstDir, for plugins whose Source is a .zip file.
+func syncSkillFromZip(ctx context.Context, archive, prefix, codexSkillName, dstDir string, opts *SyncOptions, stats *SyncStats) error {
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", archive, err)
+	}
+	defer reader.Close()
+
+	location := archive + "!/" + prefix
+
+	// Collect the skill's files, rejecting entries that would escape dstDir
+	var files []*zip.File
+	hasSkillFile := false
+	for _, file := range reader.File {
+		if strings.HasSuffix(file.Name, "/") || !strings.HasPrefix(file.Name, prefix+"/") {
+			continue
+		}
+		relPath := strings.TrimPrefix(file.Name, prefix+"/")
+		if path.IsAbs(relPath) || relPath != path.Clean(relPath) || strings.HasPrefix(relPath, "../") {
+			return fmt.Errorf("unsafe zip entry %s in %s", file.Name, archive)
+		}
+		if relPath == "SKILL.md" {
+			hasSkillFile = true
+		}
+		files = append(files, file)
+	}
+
+	if len(files) == 0 {
+		return fmt.Errorf("source directory does not exist: %s", location)
+	}
+	if !hasSkillFile {
+		return fmt.Errorf("SKILL.md not found in %s", location)
+	}
+
+	if opts.Verbose {
+		fmt.Printf("  %s → %s\n", location, dstDir)
+	}
+
+	if opts.DryRun {
+		fmt.Printf("%s[DRY RUN]%s Would copy: %s\n", colorYellow, colorReset, codexSkillName)
+		return nil
+	}
+
+	// Remove existing destination if it exists
+	if _, err := os.Lstat(dstDir); err == nil {
+		if !confirmOverwrite(codexSkillName, dstDir, opts) {
+			return errLocalEditsKept
+		}
+		if err := os.RemoveAll(dstDir); err != nil {
+			return fmt.Errorf("failed to remove existing destination %s: %w", dstDir, err)
+		}
+	}
+
+	fileCount := 0
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			os.RemoveAll(dstDir)
+			return err
+		}
+
+		relPath := strings.TrimPrefix(file.Name, prefix+"/")
+		if err := extractZipFile(file, filepath.Join(dstDir, filepath.FromSlash(relPath)), opts); err != nil {
+			// Don't leave a partially extracted skill behind
+			os.RemoveAll(dstDir)
+			return fmt.Errorf("failed to extract %s: %w", relPath, err)
+		}
+
+		fileCount++
+		if opts.Verbose {
+			fmt.Printf("    %s✓%s Extracted: %s\n", colorGreen, colorReset, relPath)
+		}
+	}
+
+	if opts.BuildInfo {
+		if err := writeBuildInfo(filepath.Dir(archive), codexSkillName, dstDir, opts); err != nil {
+			return err
+		}
+		fileCount++
+	}
+
+	if err := finishSyncedSkill(codexSkillName, dstDir, opts); err != nil {
+		return err
+	}
+
+	stats.FilesCreated += fileCount
+	fmt.Printf("%s[SYNCED]%s %s (%d files extracted)\n", colorGreen, colorReset, codexSkillName, fileCount)
+
+	return nil
+}
+
+// syncManifestName is the file in each synced skill directory recording
+// the checksum of every file as synced.
+const syncManifestName = ".codex-sync-manifest.json"
+
+// JSONFormat controls how generated JSON is encoded.
+type JSONFormat struct {
+	// Compact writes each document on a single line.
+	Compact bool `json:"compact"`
+}
+
+// marshalJSON encodes v for output, with two-space indentation unless
+// format is compact.
+func marshalJSON(v interface{}, format JSONFormat) ([]byte, error) {
+	if format.Compact {
+		return json.Marshal(v)
+	}
+	return json.MarshalIndent(v, "", "  ")
+}
+
+// SyncManifest records the content of a synced skill directory, giving
+// later runs a baseline to compare the destination against.
+type SyncManifest struct {
+	Skill string `json:"skill"`
+	// Files maps each slash-separated relative path to its SHA-256.
+	Files map[string]string `json:"files"`
+}
+
+// writeSyncManifest hashes every file under dstDir and writes the result
+// to the skill's manifest.
+func writeSyncManifest(skillName, dstDir string, format JSONFormat) error {
+	files, err := hashSkillFiles(dstDir, false)
+	if err != nil {
+		return err
+	}
+	manifest := SyncManifest{Skill: skillName, Files: files}
+
+	data, err := marshalJSON(manifest, format)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dstDir, syncManifestName), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write sync manifest: %w", err)
+	}
+	return nil
+}
+
+// errLocalEditsKept is returned by a sync that was not confirmed to
+// overwrite local edits in its destination.
+var errLocalEditsKept = errors.New("local edits kept")
+
+// confirmOverwrite asks before a sync replaces a destination whose files
+// no longer match its sync manifest, as happens after a hand edit.
+// Destinations without a manifest cannot be checked and are replaced
+// without asking, as are all destinations under -skip-newer, which keeps
+// newer files anyway.
+func confirmOverwrite(skillName, dstDir string, opts *SyncOptions) bool {
+	if opts.SkipNewer {
+		return true
+	}
+	edited, err := editedSinceSync(dstDir)
+	if err != nil {
+		fmt.Printf("%s[WARN]%s Cannot check %s for local edits: %v\n", colorYellow, colorReset, dstDir, err)
+		return confirm(fmt.Sprintf("Overwrite %s?", dstDir), opts)
+	}
+	if len(edited) == 0 {
+		return true
+	}
+	return confirm(fmt.Sprintf("%s has local edits to %s. Overwrite them?", skillName, strings.Join(edited, ", ")), opts)
+}
+
+// editedSinceSync lists the files in dstDir that were added, changed or
+// removed since its sync manifest was written. It returns nothing when
+// there is no manifest to compare against.
+func editedSinceSync(dstDir string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(dstDir, syncManifestName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var manifest SyncManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", syncManifestName, err)
+	}
+	current, err := hashSkillFiles(dstDir, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var edited []string
+	for relPath, sum := range current {
+		if manifest.Files[relPath] != sum {
+			edited = append(edited, relPath)
+		}
+	}
+	for relPath := range manifest.Files {
+		if _, ok := current[relPath]; !ok {
+			edited = append(edited, relPath)
+		}
+	}
+	sort.Strings(edited)
+	return edited, nil
+}
+
+// stdinReader is shared by all prompts so buffered input is not lost
+// between them.
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// confirm asks a yes/no question before a destructive action. -assume-yes
+// answers yes without asking; when stdin is not a terminal nobody can
+// answer, so the safe choice (no) is taken instead of blocking.
+func confirm(question string, opts *SyncOptions) bool {
+	if opts.AssumeYes {
+		return true
+	}
+
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		fmt.Printf("%s[WARN]%s %s Not confirmed (no terminal; use -assume-yes)\n", colorYellow, colorReset, question)
+		return false
+	}
+
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := stdinReader.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// hashSkillFiles returns the SHA-256 of every file under dir, keyed by
+// slash-separated relative path and leaving out the sync manifest. A
+// symlink is hashed as its target path unless followLinks is set, in
+// which case it is hashed as the content it points to, matching how a
+// default sync copies it.
+func hashSkillFiles(dir string, followLinks bool) (map[string]string, error) {
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || relPath == syncManifestName {
+			return nil
+		}
+
+		// A preserved symlink may point outside the skill or nowhere at
+		// all, so record the link itself rather than its target's content
+		if info.Mode()&os.ModeSymlink != 0 && !followLinks {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256([]byte(target))
+			files[filepath.ToSlash(relPath)] = hex.EncodeToString(sum[:])
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		h := sha256.New()
+		if _, err := io.Copy(h, file); err != nil {
+			return fmt.Errorf("failed to hash %s: %w", relPath, err)
+		}
+		files[filepath.ToSlash(relPath)] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	return files, err
+}
+
+// previewSync reports what syncing srcDir would change in dstDir, by
+// comparing file contents, without touching either.
+func previewSync(srcDir, dstDir, codexSkillName string, opts *SyncOptions) error {
+	if _, err := os.Stat(dstDir); os.IsNotExist(err) {
+		fmt.Printf("%s[DRY RUN]%s Would copy: %s (new skill)\n", colorYellow, colorReset, codexSkillName)
+		return nil
+	}
+
+	srcFiles, err := hashSkillFiles(srcDir, !opts.PreserveSymlinks)
+	if err != nil {
+		return fmt.Errorf("failed to read source %s: %w", srcDir, err)
+	}
+	dstFiles, err := hashSkillFiles(dstDir, false)
+	if err != nil {
+		return fmt.Errorf("failed to read destination %s: %w", dstDir, err)
+	}
+
+	var added, modified, deleted []string
+	for relPath, sum := range srcFiles {
+		if dstSum, ok := dstFiles[relPath]; !ok {
+			added = append(added, relPath)
+		} else if dstSum != sum {
+			modified = append(modified, relPath)
+		}
+	}
+	// Files the sync generates itself are rewritten rather than deleted
+	generated := map[string]bool{}
+	if opts.BuildInfo {
+		generated[buildInfoName] = true
+	}
+	for relPath := range dstFiles {
+		if _, ok := srcFiles[relPath]; !ok && !generated[relPath] {
+			deleted = append(deleted, relPath)
+		}
+	}
+
+	if len(added)+len(modified)+len(deleted) == 0 {
+		fmt.Printf("%s[DRY RUN]%s No changes: %s\n", colorYellow, colorReset, codexSkillName)
+		return nil
+	}
+
+	fmt.Printf("%s[DRY RUN]%s Would update: %s (%d new, %d modified, %d deleted)\n", colorYellow, colorReset, codexSkillName, len(added), len(modified), len(deleted))
+	if opts.Verbose {
+		for _, change := range []struct {
+			marker string
+			files  []string
+		}{{"+", added}, {"~", modified}, {"-", deleted}} {
+			sort.Strings(change.files)
+			for _, relPath := range change.files {
+				fmt.Printf("    %s %s\n", change.marker, relPath)
+			}
+		}
+	}
+	return nil
+}
+
+// buildInfoName is the file -build-info adds to each skill.
+const buildInfoName = ".build-info.json"
+
+// BuildInfo records the source state a skill was built from. The git
+// fields are omitted when the source is not in a git repository.
+type BuildInfo struct {
+	Commit    string    `json:"commit,omitempty"`
+	Branch    string    `json:"branch,omitempty"`
+	Dirty     *bool     `json:"dirty,omitempty"`
+	BuildTime time.Time `json:"build_time"`
+}
+
+// readBuildInfo describes the git state of ref in the repository containing
+// dir; an empty ref means the working tree. Dirty only covers dir and is
+// only checked for the working tree. On error the git fields are left
+// empty and BuildTime is still set.
+func readBuildInfo(dir, ref string, buildTime time.Time) (BuildInfo, error) {
+	info := BuildInfo{BuildTime: buildTime.UTC()}
+	if ref == "" {
+		ref = "HEAD"
+	}
+	commit, err := runGit(dir, "rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return info, err
+	}
+	dirty := false
+	if ref == "HEAD" {
+		status, err := runGit(dir, "status", "--porcelain", "--", ".")
+		if err != nil {
+			return info, err
+		}
+		dirty = len(bytes.TrimSpace(status)) > 0
+	}
+	info.Commit = strings.TrimSpace(string(commit))
+	info.Dirty = &dirty
+	// A detached HEAD has no branch to report
+	if branch, err := runGit(dir, "rev-parse", "--abbrev-ref", ref); err == nil && strings.TrimSpace(string(branch)) != "HEAD" {
+		info.Branch = strings.TrimSpace(string(branch))
+	}
+	return info, nil
+}
+
+// writeBuildInfo writes the buildInfoName file for a skill synced from
+// gitDir. Missing git metadata is reported as a warning rather than failing
+// the skill.
+func writeBuildInfo(gitDir, skillName, dstDir string, opts *SyncOptions) error {
+	info, err := readBuildInfo(gitDir, "", opts.BuildTime)
+	if err != nil {
+		fmt.Printf("%s[WARN]%s %s: %s has no git metadata: %v\n", colorYellow, colorReset, skillName, buildInfoName, err)
+	}
+	data, err := marshalJSON(info, opts.JSONFormat)
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if opts.OutputMode != 0 {
+		mode = opts.OutputMode
+	}
+	if err := os.WriteFile(longPath(filepath.Join(dstDir, buildInfoName)), append(data, '\n'), mode); err != nil {
+		return fmt.Errorf("failed to write %s: %w", buildInfoName, err)
+	}
+	if opts.Verbose {
+		fmt.Printf("    %s✓%s Generated: %s\n", colorGreen, colorReset, buildInfoName)
+	}
+	return nil
+}
+
+// runGit runs git in dir and returns its stdout, including stderr in the
+// error when the command fails.
+func runGit(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
+
+// finishSyncedSkill writes the skill's manifest under -manifest and, with
+// -output-mode, applies the requested permissions to the manifest and
+// every directory.
+func finishSyncedSkill(skillName, dstDir string, opts *SyncOptions) error {
+	if opts.Manifest {
+		if err := writeSyncManifest(skillName, dstDir, opts.JSONFormat); err != nil {
+			return err
+		}
+	}
+	if opts.OutputMode == 0 {
+		return nil
+	}
+
+	if opts.Manifest {
+		if err := os.Chmod(filepath.Join(dstDir, syncManifestName), opts.OutputMode); err != nil {
+			return err
+		}
+	}
+	dirMode := opts.OutputMode | (opts.OutputMode&0444)>>2
+	return filepath.Walk(dstDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		return os.Chmod(path, dirMode)
+	})
+}
+
+// parseOutputMode parses an octal permission string such as "0644". An
+// empty string returns 0, meaning the default modes are kept, so an
+// explicit mode of 0 is rejected rather than silently ignored.
+func parseOutputMode(value string) (os.FileMode, error) {
+	if value == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid octal mode %q", value)
+	}
+	if mode == 0 {
+		return 0, fmt.Errorf("mode %q grants no permissions at all", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// refreshSyncManifest rewrites the manifest of an already-synced skill
+// from the files currently in its destination, leaving them untouched.
+func refreshSyncManifest(skillName, dstDir string, opts *SyncOptions, stats *SyncStats) error {
+	if info, err := os.Stat(dstDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("%s has not been synced to %s", skillName, opts.TargetDir)
+	}
+
+	if opts.DryRun {
+		fmt.Printf("%s[DRY RUN]%s Would refresh manifest: %s\n", colorYellow, colorReset, skillName)
+		return nil
+	}
+
+	if err := finishSyncedSkill(skillName, dstDir, opts); err != nil {
+		return err
+	}
+
+	stats.ManifestsRefreshed++
+	fmt.Printf("%s[REFRESHED]%s %s\n", colorGreen, colorReset, skillName)
+	return nil
+}
+
+// extractZipFile writes a single zip entry to dst, creating parent
+// directories as needed.
+func extractZipFile(file *zip.File, dst string, opts *SyncOptions) error {
+	dst = longPath(dst)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	mode := file.Mode().Perm()
+	if opts.OutputMode != 0 {
+		mode = opts.OutputMode
+	} else if mode == 0 {
+		mode = 0644
+	}
+	destFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(destFile, reader); err != nil {
+		destFile.Close()
+		return err
+	}
+	if err := destFile.Close(); err != nil {
+		return err
+	}
+	// OpenFile's mode is subject to the umask; an explicit mode is not
+	if opts.OutputMode != 0 {
+		if err := os.Chmod(dst, opts.OutputMode); err != nil {
+			return err
+		}
+	}
+
+	if opts.PreserveTimes {
+		return os.Chtimes(dst, file.Modified, file.Modified)
+	}
+	return nil
+}
+
+func copyFile(src, dst string, opts *SyncOptions) error {
+	src, dst = longPath(src), longPath(dst)
+	sourceFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	destFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	if _, err := io.Copy(destFile, sourceFile); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", src, dst, err)
+	}
+
+	// Copy file permissions
+	sourceInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	mode := sourceInfo.Mode()
+	if opts.OutputMode != 0 {
+		mode = opts.OutputMode
+	}
+	if err := os.Chmod(dst, mode); err != nil {
+		return err
+	}
+
+	// Copy modification time
+	if opts.PreserveTimes {
+		return os.Chtimes(dst, sourceInfo.ModTime(), sourceInfo.ModTime())
+	}
+
+	return nil
+}
+
+// longPath returns an absolute path in its extended-length form on
+// Windows, so paths beyond the 260 character MAX_PATH limit can still be
+// created. Relative paths, paths already in that form, and every path on
+// other platforms are returned unchanged.
+func longPath(p string) string {
+	if runtime.GOOS != "windows" || !filepath.IsAbs(p) || strings.HasPrefix(p, `\\?\`) {
+		return p
+	}
+	// Windows does not normalize extended-length paths, so clean first
+	p = filepath.Clean(p)
+	if strings.HasPrefix(p, `\\`) {
+		return `\\?\UNC\` + p[2:]
+	}
+	return `\\?\` + p
+}
+
+// outputBuffer holds the output of one unit of work, such as a skill, and
+// writes it to out as a single block on Flush. Buffers sharing a mutex
+// never interleave their blocks, so output stays grouped per skill even
+// when skills are processed concurrently. A buffer itself belongs to one
+// goroutine; only Flush is synchronised.
+type outputBuffer struct {
+	out io.Writer
+	mu  *sync.Mutex
+	buf bytes.Buffer
+}
+
+// newOutputBuffer returns an empty buffer that flushes to out while
+// holding mu.
+func newOutputBuffer(out io.Writer, mu *sync.Mutex) *outputBuffer {
+	return &outputBuffer{out: out, mu: mu}
+}
+
+// Printf formats into the buffer.
+func (b *outputBuffer) Printf(format string, args ...interface{}) {
+	fmt.Fprintf(&b.buf, format, args...)
+}
+
+// Write appends p to the buffer, so an outputBuffer can stand in for any
+// io.Writer.
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	return b.buf.Write(p)
+}
+
+// Flush writes everything buffered so far as one block and empties the
+// buffer.
+func (b *outputBuffer) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, err := b.out.Write(b.buf.Bytes())
+	b.buf.Reset()
+	return err
 }
 
 func printHeader(title string) {
@@ -289,19 +2634,282 @@ func printSummary(stats *SyncStats, dryRun bool) {
 	if stats.SkillsFailed > 0 {
 		fmt.Printf("%sSkills failed:%s     %d\n", colorRed, colorReset, stats.SkillsFailed)
 	}
+	if stats.SkillsExcluded > 0 {
+		fmt.Printf("%sSkills excluded:%s   %d\n", colorBlue, colorReset, stats.SkillsExcluded)
+	}
 	if !dryRun {
 		fmt.Printf("%sFiles created:%s     %d\n", colorBlue, colorReset, stats.FilesCreated)
 	}
+	if stats.ManifestsRefreshed > 0 {
+		fmt.Printf("%sManifests:%s         %d refreshed\n", colorBlue, colorReset, stats.ManifestsRefreshed)
+	}
+	if stats.AliasesLinked > 0 {
+		fmt.Printf("%sAliases linked:%s    %d\n", colorBlue, colorReset, stats.AliasesLinked)
+	}
+	if stats.CaseCollisions > 0 {
+		fmt.Printf("%sCase collisions:%s   %d\n", colorYellow, colorReset, stats.CaseCollisions)
+	}
+	if stats.FilesSkippedNewer > 0 {
+		fmt.Printf("%sSkipped as newer:%s  %d\n", colorYellow, colorReset, stats.FilesSkippedNewer)
+	}
+	if stats.SkillsKept > 0 {
+		fmt.Printf("%sSkills kept:%s       %d\n", colorYellow, colorReset, stats.SkillsKept)
+	}
 	fmt.Println()
 
 	if stats.SkillsSynced > 0 && !dryRun {
 		fmt.Printf("%s✓ Successfully synced skills to Codex!%s\n\n", colorGreen, colorReset)
 		fmt.Printf("You can now use these skills in Codex by typing $<skill-name>\n")
-		fmt.Printf("Example: $commit-messages or $react\n\n")
+		fmt.Printf("Example: %s\n\n", usageExample(stats.SyncedNames))
+	}
+}
+
+// usageExample names up to two of the synced skills the way they are
+// invoked in Codex.
+func usageExample(names []string) string {
+	if len(names) == 0 {
+		return "$<skill-name>"
+	}
+	if len(names) == 1 {
+		return "$" + names[0]
+	}
+	return fmt.Sprintf("$%s or $%s", names[0], names[1])
+}
+
+// logOut tees the run's stdout and stderr into -log-file; nil without it.
+var logOut *logOutput
+
+// logOutput copies everything written to stdout and stderr into a log
+// file, each line timestamped and stripped of color codes, while the
+// console output stays as it is.
+type logOutput struct {
+	file     *rotatingLog
+	console  []*os.File
+	pipes    []*os.File
+	done     []chan struct{}
+	restored bool
+}
+
+// startLogOutput replaces os.Stdout and os.Stderr with pipes whose output
+// is written both to the console and to the log at path.
+func startLogOutput(path string, maxSize int64) (*logOutput, error) {
+	file := &rotatingLog{path: path, maxSize: maxSize}
+	if err := file.open(os.O_APPEND); err != nil {
+		return nil, err
+	}
+	out := &logOutput{file: file}
+	for _, stream := range []**os.File{&os.Stdout, &os.Stderr} {
+		r, w, err := os.Pipe()
+		if err != nil {
+			out.Close()
+			return nil, err
+		}
+		console := *stream
+		*stream = w
+		out.console = append(out.console, console)
+		out.pipes = append(out.pipes, w)
+		done := make(chan struct{})
+		out.done = append(out.done, done)
+		go func() {
+			defer close(done)
+			lines := &logLines{log: file}
+			io.Copy(console, io.TeeReader(r, lines))
+			lines.Flush()
+			r.Close()
+		}()
+	}
+	return out, nil
+}
+
+// Close restores the console streams, waits for everything already
+// written to reach the log, and closes it. It is safe on a nil logOutput.
+func (o *logOutput) Close() {
+	if o == nil {
+		return
+	}
+	o.restore()
+	o.file.Close()
+}
+
+// WriteLine logs a line that was written to the console directly. It is
+// safe on a nil logOutput.
+func (o *logOutput) WriteLine(line string) {
+	if o != nil {
+		o.file.WriteLine(line)
+	}
+}
+
+// restore points os.Stdout and os.Stderr back at the console and waits
+// until the pipes have been drained, so output written before is not
+// overtaken by output written after.
+func (o *logOutput) restore() {
+	if o == nil || o.restored {
+		return
+	}
+	o.restored = true
+	if len(o.console) > 0 {
+		os.Stdout = o.console[0]
+	}
+	if len(o.console) > 1 {
+		os.Stderr = o.console[1]
+	}
+	for _, w := range o.pipes {
+		w.Close()
+	}
+	for _, done := range o.done {
+		<-done
+	}
+}
+
+// logLines buffers one stream's output so only whole lines reach the log,
+// keeping stdout and stderr lines from interleaving mid-line.
+type logLines struct {
+	log     *rotatingLog
+	pending []byte
+}
+
+func (l *logLines) Write(p []byte) (int, error) {
+	l.pending = append(l.pending, p...)
+	for {
+		i := bytes.IndexAny(l.pending, "\r\n")
+		if i < 0 {
+			break
+		}
+		if i > 0 {
+			l.log.WriteLine(string(l.pending[:i]))
+		}
+		l.pending = l.pending[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes a final line that did not end in a newline.
+func (l *logLines) Flush() {
+	if len(l.pending) > 0 {
+		l.log.WriteLine(string(l.pending))
+		l.pending = nil
+	}
+}
+
+// rotatingLog appends timestamped lines to a file. Once the file would
+// grow past maxSize it is renamed to <path>.1, replacing any earlier
+// backup, and a fresh file is started; 0 never rotates.
+type rotatingLog struct {
+	path    string
+	maxSize int64
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+}
+
+func (l *rotatingLog) open(flag int) error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|flag, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// WriteLine logs line with the current time, without color codes.
+// Errors are dropped so a failing log never interrupts the run.
+func (l *rotatingLog) WriteLine(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return
+	}
+	entry := time.Now().Format(time.RFC3339) + " " + stripANSI(line) + "\n"
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(entry)) > l.maxSize {
+		l.file.Close()
+		l.file = nil
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return
+		}
+		if err := l.open(os.O_TRUNC); err != nil {
+			return
+		}
+	}
+	n, _ := l.file.WriteString(entry)
+	l.size += int64(n)
+}
+
+func (l *rotatingLog) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// stripANSI removes the color escape sequences used for console output.
+func stripANSI(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\033' && i+1 < len(s) && s[i+1] == '[' {
+			j := i + 2
+			for j < len(s) && (s[j] == ';' || (s[j] >= '0' && s[j] <= '9')) {
+				j++
+			}
+			if j < len(s) {
+				i = j
+				continue
+			}
+		}
+		b.WriteByte(s[i])
 	}
+	return b.String()
+}
+
+// watchChildArgs returns the command line for a -watch-config re-run:
+// the current arguments without -watch-config, and without the log flags
+// since the watching process already writes the log.
+func watchChildArgs() []string {
+	var args []string
+	skipValue := false
+	for _, arg := range os.Args[1:] {
+		if skipValue {
+			skipValue = false
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") {
+			args = append(args, arg)
+			continue
+		}
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		switch name {
+		case "watch-config":
+			continue
+		case "log-file", "log-max-size":
+			skipValue = !hasValue
+			continue
+		}
+		args = append(args, arg)
+	}
+	return args
+}
+
+// exit ends the process with code once the log output has been written.
+func exit(code int) {
+	logOut.Close()
+	os.Exit(code)
 }
 
 func fatal(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, "%sERROR: %s%s\n", colorRed, fmt.Sprintf(format, args...), colorReset)
-	os.Exit(1)
+	// The stdout and stderr pipes drain independently, so output still
+	// in them must reach the console before the error does
+	logOut.restore()
+	msg := fmt.Sprintf(format, args...)
+	fmt.Fprintf(os.Stderr, "%sERROR: %s%s\n", colorRed, msg, colorReset)
+	logOut.WriteLine("ERROR: " + msg)
+	exit(1)
 }
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestOutputBufferKeepsBlocksTogether(t *testing.T) {
+	const writers, lines = 8, 50
+
+	var out bytes.Buffer
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			buf := newOutputBuffer(&out, &mu)
+			for i := 0; i < lines; i++ {
+				buf.Printf("skill-%d line %d\n", w, i)
+			}
+			if err := buf.Flush(); err != nil {
+				t.Errorf("Flush: %v", err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(got) != writers*lines {
+		t.Fatalf("got %d lines, want %d", len(got), writers*lines)
+	}
+	for start := 0; start < len(got); start += lines {
+		var w int
+		if _, err := fmt.Sscanf(got[start], "skill-%d line 0", &w); err != nil {
+			t.Fatalf("block at line %d starts with %q", start, got[start])
+		}
+		for i := 0; i < lines; i++ {
+			if want := fmt.Sprintf("skill-%d line %d", w, i); got[start+i] != want {
+				t.Fatalf("line %d = %q, want %q", start+i, got[start+i], want)
+			}
+		}
+	}
+}
+
+func TestOutputBufferFlushEmptiesBuffer(t *testing.T) {
+	var out bytes.Buffer
+	buf := newOutputBuffer(&out, &sync.Mutex{})
+	fmt.Fprint(buf, "first\n")
+	if err := buf.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	buf.Printf("second\n")
+	if err := buf.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "first\nsecond\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+// writeFiles creates each slash-separated path in files under dir with the
+// given contents.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadMarketplaceResolvesRefs(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "single plugin",
+			files: map[string]string{
+				"marketplace.json":  `{"plugins": [{"$ref": "./plugins/core.json"}, {"name": "web", "source": "./web"}]}`,
+				"plugins/core.json": `{"name": "core", "source": "./core"}`,
+			},
+			want: []string{"core", "web"},
+		},
+		{
+			name: "array of plugins",
+			files: map[string]string{
+				"marketplace.json": `{"plugins": [{"$ref": "plugins.json"}]}`,
+				"plugins.json":     `[{"name": "a", "source": "./a"}, {"name": "b", "source": "./b"}]`,
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "nested refs resolve relative to their file",
+			files: map[string]string{
+				"marketplace.json":     `{"plugins": [{"$ref": "team/all.json"}]}`,
+				"team/all.json":        `[{"$ref": "nested/one.json"}]`,
+				"team/nested/one.json": `{"name": "one", "source": "./one"}`,
+			},
+			want: []string{"one"},
+		},
+		{
+			name: "cycle",
+			files: map[string]string{
+				"marketplace.json": `{"plugins": [{"$ref": "a.json"}]}`,
+				"a.json":           `{"$ref": "b.json"}`,
+				"b.json":           `{"$ref": "a.json"}`,
+			},
+			wantErr: "cyclic $ref",
+		},
+		{
+			name: "self reference",
+			files: map[string]string{
+				"marketplace.json": `{"plugins": [{"$ref": "marketplace.json"}]}`,
+			},
+			wantErr: "cyclic $ref",
+		},
+		{
+			name: "missing file",
+			files: map[string]string{
+				"marketplace.json": `{"plugins": [{"$ref": "gone.json"}]}`,
+			},
+			wantErr: "failed to read $ref gone.json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+
+			marketplace, err := readMarketplace(filepath.Join(dir, "marketplace.json"), false)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, plugin := range marketplace.Plugins {
+				names = append(names, plugin.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("plugins = %q, want %q", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestLongPath(t *testing.T) {
+	tests := []struct {
+		in, windows string
+	}{
+		{`C:\skills\core\SKILL.md`, `\\?\C:\skills\core\SKILL.md`},
+		{`C:\skills\core\..\web\.\SKILL.md`, `\\?\C:\skills\web\SKILL.md`},
+		{`\\server\share\skills`, `\\?\UNC\server\share\skills`},
+		{`\\?\C:\already\long`, `\\?\C:\already\long`},
+		{`relative\path`, `relative\path`},
+		{"/usr/local/skills", "/usr/local/skills"},
+	}
+	for _, tt := range tests {
+		want := tt.in
+		if runtime.GOOS == "windows" {
+			want = tt.windows
+		}
+		if got := longPath(tt.in); got != want {
+			t.Errorf("longPath(%q) = %q on %s, want %q", tt.in, got, runtime.GOOS, want)
+		}
+	}
+}
+
+func TestLongPathCreatesDeepFile(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("MAX_PATH only applies on Windows")
+	}
+	dir := t.TempDir()
+	for len(dir) < 300 {
+		dir = filepath.Join(dir, strings.Repeat("d", 50))
+	}
+	if err := os.MkdirAll(longPath(dir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "SKILL.md")
+	if err := os.WriteFile(longPath(path), []byte("x"), 0644); err != nil {
+		t.Fatalf("writing %d character path: %v", len(path), err)
+	}
+}
+
+func TestErrorChain(t *testing.T) {
+	root := errors.New("disk full")
+	err := fmt.Errorf("failed to add docs/a.md: %w", &fs.PathError{Op: "write", Path: "/out/s.zip", Err: root})
+
+	want := []string{
+		"*fmt.wrapError: failed to add docs/a.md",
+		"*fs.PathError: write /out/s.zip",
+		"*errors.errorString: disk full",
+	}
+	if got := errorChain(err); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("errorChain = %q, want %q", got, want)
+	}
+	if !errors.Is(err, root) {
+		t.Error("wrapped error lost its cause")
+	}
+}
+
+func TestCopyFileErrorsKeepCause(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.md")
+	writeFiles(t, dir, map[string]string{"a.md": "x"})
+
+	tests := []struct {
+		name, src, dst string
+	}{
+		{"missing source", filepath.Join(dir, "gone.md"), filepath.Join(dir, "out.md")},
+		{"missing destination directory", src, filepath.Join(dir, "no-such-dir", "a.md")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := copyFile(tt.src, tt.dst, &SyncOptions{})
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("err = %v, want one wrapping fs.ErrNotExist", err)
+			}
+			var pathErr *fs.PathError
+			if !errors.As(err, &pathErr) {
+				t.Fatalf("err = %v, want one wrapping *fs.PathError", err)
+			}
+			if !strings.Contains(err.Error(), filepath.Base(pathErr.Path)) {
+				t.Errorf("err = %q does not name %s", err, pathErr.Path)
+			}
+		})
+	}
+}
+
+func TestCleanPluginPaths(t *testing.T) {
+	tests := []struct {
+		source     string
+		skills     []string
+		wantSource string
+		wantSkills []string
+	}{
+		{"./plugins/core/", []string{"./skills/tdd/"}, filepath.FromSlash("plugins/core"), []string{filepath.FromSlash("skills/tdd")}},
+		{"plugins//core", []string{"skills//tdd"}, filepath.FromSlash("plugins/core"), []string{filepath.FromSlash("skills/tdd")}},
+		{"./plugins/./core/../core", []string{"./skills/./tdd"}, filepath.FromSlash("plugins/core"), []string{filepath.FromSlash("skills/tdd")}},
+		{"./", []string{"./"}, ".", []string{"."}},
+		{"", nil, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			plugin := Plugin{Source: tt.source, Skills: append([]string(nil), tt.skills...)}
+			cleanPluginPaths(&plugin)
+			if plugin.Source != tt.wantSource {
+				t.Errorf("Source = %q, want %q", plugin.Source, tt.wantSource)
+			}
+			if strings.Join(plugin.Skills, ",") != strings.Join(tt.wantSkills, ",") {
+				t.Errorf("Skills = %q, want %q", plugin.Skills, tt.wantSkills)
+			}
+		})
+	}
+}
+
+func TestReadMarketplaceResolvesMessyPathsAlike(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"marketplace.json": `{"plugins": [
+			{"name": "a", "source": "./plugins/core/", "skills": ["./skills/tdd/"]},
+			{"name": "b", "source": "plugins//core", "skills": ["skills//tdd"]},
+			{"name": "c", "source": "./plugins/./core", "skills": ["./skills/./tdd"]}
+		]}`,
+	})
+
+	marketplace, err := readMarketplace(filepath.Join(dir, "marketplace.json"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := marketplace.Plugins[0]
+	want := filepath.Join(first.Source, first.Skills[0])
+	for _, plugin := range marketplace.Plugins[1:] {
+		if got := filepath.Join(plugin.Source, plugin.Skills[0]); got != want {
+			t.Errorf("plugin %s resolves its skill to %q, plugin %s to %q", plugin.Name, got, first.Name, want)
+		}
+		if filepath.Base(plugin.Source) != "core" {
+			t.Errorf("plugin %s: base of source %q is not core", plugin.Name, plugin.Source)
+		}
+	}
+}
+
+func TestExpandHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	tests := []struct {
+		in, want string
+	}{
+		{"~", home},
+		{"~/", home},
+		{"~/skills/tdd", filepath.Join(home, "skills", "tdd")},
+		{"~user/skills", "~user/skills"},
+		{"~user", "~user"},
+		{"./plugins/~/core", "./plugins/~/core"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := expandHome(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("expandHome(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandPluginHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	plugin := Plugin{Name: "p", Source: "~/plugins/p", SkillsFile: "~/skills.txt", Skills: []string{"~/shared/tdd", "./skills/web"}}
+	if err := expandPluginHome(&plugin); err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(home, "plugins", "p"); plugin.Source != want {
+		t.Errorf("Source = %q, want %q", plugin.Source, want)
+	}
+	if want := filepath.Join(home, "skills.txt"); plugin.SkillsFile != want {
+		t.Errorf("SkillsFile = %q, want %q", plugin.SkillsFile, want)
+	}
+	if want := []string{filepath.Join(home, "shared", "tdd"), "./skills/web"}; strings.Join(plugin.Skills, ",") != strings.Join(want, ",") {
+		t.Errorf("Skills = %q, want %q", plugin.Skills, want)
+	}
+}
+
+func TestResolveSkillRoot(t *testing.T) {
+	dir := t.TempDir()
+	// TempDir may itself sit behind a symlink, as /var does on macOS
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, map[string]string{
+		"real/SKILL.md": "---\nname: real\n---\n",
+		"file.md":       "x",
+	})
+	links := map[string]string{
+		"linked":  filepath.Join(dir, "real"),
+		"broken":  filepath.Join(dir, "gone"),
+		"to-file": filepath.Join(dir, "file.md"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Skipf("cannot create symlinks: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name, want, wantErr string
+	}{
+		{name: "real", want: filepath.Join(dir, "real")},
+		{name: "linked", want: filepath.Join(dir, "real")},
+		{name: "broken", wantErr: "is a broken symlink to " + filepath.Join(dir, "gone")},
+		{name: "to-file", wantErr: "which is not a directory"},
+		{name: "missing", wantErr: "source directory does not exist"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveSkillRoot(filepath.Join(dir, tt.name))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("resolveSkillRoot = %q, %v; want error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("resolveSkillRoot = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditedSinceSync(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(t *testing.T, dir string)
+		want []string
+	}{
+		{"unchanged", func(t *testing.T, dir string) {}, nil},
+		{"changed", func(t *testing.T, dir string) {
+			writeFiles(t, dir, map[string]string{"SKILL.md": "edited"})
+		}, []string{"SKILL.md"}},
+		{"added and removed", func(t *testing.T, dir string) {
+			writeFiles(t, dir, map[string]string{"notes.md": "new"})
+			if err := os.Remove(filepath.Join(dir, "docs", "guide.md")); err != nil {
+				t.Fatal(err)
+			}
+		}, []string{"docs/guide.md", "notes.md"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"SKILL.md": "x", "docs/guide.md": "y"})
+			if err := writeSyncManifest("s", dir, JSONFormat{}); err != nil {
+				t.Fatal(err)
+			}
+			tt.edit(t, dir)
+
+			edited, err := editedSinceSync(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(edited, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("edited = %q, want %q", edited, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditedSinceSyncWithoutManifest(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"SKILL.md": "x"})
+	if edited, err := editedSinceSync(dir); err != nil || edited != nil {
+		t.Errorf("editedSinceSync = %q, %v; want nothing", edited, err)
+	}
+}
+
+func TestParseOutputMode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{in: "", want: 0},
+		{in: "0644", want: 0644},
+		{in: "755", want: 0755},
+		{in: "0600", want: 0600},
+		{in: "0", wantErr: true},
+		{in: "0000", wantErr: true},
+		{in: "0888", wantErr: true},
+		{in: "01777", wantErr: true},
+		{in: "rw-r--r--", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseOutputMode(tt.in)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseOutputMode(%q) = %o, %v; want %o, error %v", tt.in, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckOutputPaths(t *testing.T) {
+	outputPath := func(pluginName, skillName string) string {
+		return filepath.Join("out", skillName)
+	}
+	marketplace := func(skills ...[]string) *MarketplaceConfig {
+		config := &MarketplaceConfig{}
+		for i, plugin := range skills {
+			config.Plugins = append(config.Plugins, Plugin{Name: fmt.Sprintf("p%d", i+1), Skills: plugin})
+		}
+		return config
+	}
+
+	clashes, err := checkOutputPaths(marketplace([]string{"./skills/a", "./skills/b"}), outputPath)
+	if err != nil || len(clashes) != 0 {
+		t.Errorf("distinct paths = %q, %v", clashes, err)
+	}
+
+	clashes, err = checkOutputPaths(marketplace([]string{"./skills/Review"}, []string{"./skills/review"}), outputPath)
+	if err != nil {
+		t.Fatalf("case-only clash failed: %v", err)
+	}
+	if len(clashes) != 1 || !strings.Contains(clashes[0], "p1/Review and p2/review") {
+		t.Errorf("case-only clash = %q", clashes)
+	}
+
+	// An exact clash with a path only seen through a case-only one
+	_, err = checkOutputPaths(marketplace([]string{"./skills/Review"}, []string{"./skills/review"}, []string{"./skills/review"}), outputPath)
+	if err == nil || !strings.Contains(err.Error(), "p2/review and p3/review would both be written to") {
+		t.Errorf("exact clash = %v", err)
+	}
+}
+
+func TestSyncSkillRestoresPreviousOnFailure(t *testing.T) {
+	src, target := t.TempDir(), t.TempDir()
+	// A directory in the manifest's place makes the sync fail after
+	// every file has been copied
+	writeFiles(t, src, map[string]string{
+		"s/SKILL.md":                         "---\nname: s\n---\n",
+		"s/new.md":                           "new",
+		"s/" + syncManifestName + "/keep.md": "",
+	})
+	writeFiles(t, target, map[string]string{"s/old.md": "old"})
+
+	opts := &SyncOptions{TargetDir: target, SkipNewer: true, Manifest: true}
+	if err := syncSkill(context.Background(), "p", filepath.Join(src, "s"), opts, &SyncStats{}); err == nil {
+		t.Fatal("syncSkill succeeded, want the manifest write to fail")
+	}
+
+	if data, err := os.ReadFile(filepath.Join(target, "s", "old.md")); err != nil || string(data) != "old" {
+		t.Errorf("previous destination not restored: %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "s", "new.md")); !os.IsNotExist(err) {
+		t.Errorf("partial sync left behind: %v", err)
+	}
+	entries, _ := os.ReadDir(target)
+	if len(entries) != 1 {
+		t.Errorf("target holds %d entries, want only the restored skill", len(entries))
+	}
+}
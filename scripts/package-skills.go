@@ -2,12 +2,49 @@ package main
 
 import (
 	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
+	"html/template"
 	"io"
+	"io/fs"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 const (
@@ -22,6 +59,21 @@ type MarketplaceConfig struct {
 	Name    string   `json:"name"`
 	Owner   Owner    `json:"owner"`
 	Plugins []Plugin `json:"plugins"`
+	// Skipped holds the errors for plugin entries dropped by a lenient
+	// read.
+	Skipped []error `json:"-"`
+	// Files lists every file the config was read from: marketplace.json,
+	// $ref targets, and skillsFile lists.
+	Files []string `json:"-"`
+	// Warnings holds notes about the config that do not stop the run,
+	// such as inferred plugin names.
+	Warnings []string `json:"-"`
+	// MergedSkills maps the absolute directory of each merged skill to the
+	// absolute source directories it is assembled from.
+	MergedSkills map[string][]string `json:"-"`
+	// SkillDefaults maps the absolute directory of each skill whose plugin
+	// sets skillDefaults to those defaults.
+	SkillDefaults map[string]*Frontmatter `json:"-"`
 }
 
 type Owner struct {
@@ -34,328 +86,8121 @@ type Plugin struct {
 	Name        string   `json:"name"`
 	Source      string   `json:"source"`
 	Description string   `json:"description"`
-	Skills      []string `json:"skills"`
+	Skills      []string `json:"skills,omitempty"`
+	// Build is an optional shell command run in Source before the
+	// plugin's skills are packaged.
+	Build string `json:"build,omitempty"`
+	// SkillsFile names a file listing further skill paths, one per line,
+	// which are merged into Skills when the marketplace is read.
+	SkillsFile string `json:"skillsFile,omitempty"`
+	// Merged maps the name of each skill given in object form to its
+	// source paths, relative to Source, in override order.
+	Merged map[string][]string `json:"-"`
+	// SkillDefaults holds frontmatter values applied to every skill in the
+	// plugin that does not set them itself. They only steer packaging,
+	// checks and reports; the SKILL.md in each zip is left as written.
+	SkillDefaults map[string]any `json:"skillDefaults,omitempty"`
+	// Defaults is SkillDefaults checked and converted to frontmatter.
+	Defaults *Frontmatter `json:"-"`
+}
+
+// mergedSkill is the object form of a "skills" entry: one skill assembled
+// from several source directories, later sources overriding earlier ones.
+type mergedSkill struct {
+	Name    string   `json:"name"`
+	Sources []string `json:"sources"`
+}
+
+// UnmarshalJSON accepts each "skills" entry either as a path or as a
+// mergedSkill object. Merged skills are listed in Skills as
+// ./skills/<name> so they are handled like any other skill.
+func (p *Plugin) UnmarshalJSON(data []byte) error {
+	type plainPlugin Plugin
+	var decoded struct {
+		plainPlugin
+		Skills []json.RawMessage `json:"skills,omitempty"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*p = Plugin(decoded.plainPlugin)
+	p.Skills = nil
+	for _, entry := range decoded.Skills {
+		var skillPath string
+		if err := json.Unmarshal(entry, &skillPath); err == nil {
+			p.Skills = append(p.Skills, skillPath)
+			continue
+		}
+		var merged mergedSkill
+		if err := json.Unmarshal(entry, &merged); err != nil {
+			return fmt.Errorf("skills entry must be a path or an object with name and sources: %s", entry)
+		}
+		if merged.Name == "" || merged.Name == "." || merged.Name == ".." || strings.ContainsAny(merged.Name, `/\`) {
+			return fmt.Errorf("merged skill has an invalid name %q", merged.Name)
+		}
+		if len(merged.Sources) == 0 {
+			return fmt.Errorf("merged skill %s has no sources", merged.Name)
+		}
+		if _, ok := p.Merged[merged.Name]; ok {
+			return fmt.Errorf("merged skill %s is listed twice", merged.Name)
+		}
+		if p.Merged == nil {
+			p.Merged = make(map[string][]string)
+		}
+		p.Merged[merged.Name] = merged.Sources
+		p.Skills = append(p.Skills, "./skills/"+merged.Name)
+	}
+	if len(p.SkillDefaults) > 0 {
+		defaults, err := skillDefaultsFrontmatter(p.SkillDefaults)
+		if err != nil {
+			return err
+		}
+		p.Defaults = defaults
+	}
+	return nil
+}
+
+// skillDefaultsFrontmatter converts a plugin's skillDefaults object to
+// frontmatter. Values may be strings, numbers, booleans, or lists of
+// those, which is all the frontmatter parser understands.
+func skillDefaultsFrontmatter(values map[string]any) (*Frontmatter, error) {
+	defaults := &Frontmatter{scalars: map[string]string{}, lists: map[string][]string{}}
+	for key, value := range values {
+		if list, ok := value.([]any); ok {
+			items := []string{}
+			for _, item := range list {
+				text, ok := skillDefaultScalar(item)
+				if !ok {
+					return nil, fmt.Errorf("skillDefaults %s: list items must be strings, numbers, or booleans", key)
+				}
+				items = append(items, text)
+			}
+			defaults.lists[key] = items
+			continue
+		}
+		text, ok := skillDefaultScalar(value)
+		if !ok {
+			return nil, fmt.Errorf("skillDefaults %s must be a string, number, boolean, or list", key)
+		}
+		defaults.scalars[key] = text
+	}
+	return defaults, nil
+}
+
+// skillDefaultScalar formats a decoded JSON scalar as frontmatter text.
+func skillDefaultScalar(value any) (string, bool) {
+	switch value := value.(type) {
+	case string:
+		return value, true
+	case float64, bool:
+		return fmt.Sprint(value), true
+	}
+	return "", false
+}
+
+// MarshalJSON writes merged skills back in their object form.
+func (p Plugin) MarshalJSON() ([]byte, error) {
+	type plainPlugin Plugin
+	encoded := struct {
+		plainPlugin
+		Skills []any `json:"skills,omitempty"`
+	}{plainPlugin: plainPlugin(p)}
+	for _, skillPath := range p.Skills {
+		name := filepath.Base(skillPath)
+		if sources, ok := p.Merged[name]; ok {
+			encoded.Skills = append(encoded.Skills, mergedSkill{Name: name, Sources: sources})
+		} else {
+			encoded.Skills = append(encoded.Skills, skillPath)
+		}
+	}
+	return json.Marshal(encoded)
 }
 
 type PackageStats struct {
 	SkillsPackaged int
 	SkillsFailed   int
 	FilesAdded     int
+	OrphansPurged  int
+	SkillsResumed  int
+	// SkillsUnchanged counts skills skipped by -since-git.
+	SkillsUnchanged int
+	// SkillsExcluded counts skills skipped by -exclude-skills-file.
+	SkillsExcluded int
+	// ZipsIdentical counts zips left in place by -dedupe because their
+	// contents did not change.
+	ZipsIdentical int
+	// FilesFlagged counts files with suspicious permissions under
+	// -audit-perms.
+	FilesFlagged int
+	// FilesInvalid counts data files that failed to parse under
+	// -validate-data.
+	FilesInvalid int
+	// CaseCollisions counts files whose zip entry differs from another
+	// entry in the same zip only by case.
+	CaseCollisions int
+	// Results records the outcome of each processed skill, in the order the
+	// skills were handled.
+	Results []SkillResult
+	// Plugins breaks the skill totals down by plugin name.
+	Plugins map[string]*PluginStats
+	// Removed lists the "plugin/skill" keys in the -baseline report that
+	// this run did not produce; nil without -baseline.
+	Removed []string
+	// Largest holds the biggest files packaged under -report-largest,
+	// largest first.
+	Largest []LargeFile
+	// TooManyFiles holds the results of skills packaged with more files
+	// than -warn-file-count allows.
+	TooManyFiles []SkillResult
+	// TotalZipSize is the combined size of the run's zips, measured only
+	// when SizeBudget is set.
+	TotalZipSize int64
+	// SizeBudget is the -max-total-size limit; 0 means unlimited.
+	SizeBudget int64
+}
+
+// LargeFile is a packaged file tracked by -report-largest.
+type LargeFile struct {
+	Skill string // packaged skill name
+	Path  string // path within the skill
+	Size  int64
+}
+
+// recordLargest adds file to Largest if it is among the limit biggest
+// seen so far. Largest stays sorted, so each call is a binary search plus
+// a short copy.
+func (s *PackageStats) recordLargest(file LargeFile, limit int) {
+	if limit <= 0 || (len(s.Largest) == limit && file.Size <= s.Largest[limit-1].Size) {
+		return
+	}
+	i := sort.Search(len(s.Largest), func(i int) bool { return s.Largest[i].Size < file.Size })
+	s.Largest = append(s.Largest, LargeFile{})
+	copy(s.Largest[i+1:], s.Largest[i:])
+	s.Largest[i] = file
+	if len(s.Largest) > limit {
+		s.Largest = s.Largest[:limit]
+	}
+}
+
+// PluginStats totals the skill results for a single plugin.
+type PluginStats struct {
+	SkillsPackaged int `json:"skills_packaged"`
+	SkillsFailed   int `json:"skills_failed"`
+	SkillsSkipped  int `json:"skills_skipped"`
+	FilesAdded     int `json:"files_added"`
+}
+
+// plugin returns the totals for the named plugin, creating them on first
+// use.
+func (s *PackageStats) plugin(name string) *PluginStats {
+	if s.Plugins == nil {
+		s.Plugins = map[string]*PluginStats{}
+	}
+	if s.Plugins[name] == nil {
+		s.Plugins[name] = &PluginStats{}
+	}
+	return s.Plugins[name]
+}
+
+// SkillResult records the outcome of processing a single skill.
+type SkillResult struct {
+	Plugin string `json:"plugin"`
+	Skill  string `json:"skill"`
+	Status string `json:"status"`
+	// Error holds the failure message, or the reason a skill was skipped
+	Error string `json:"error,omitempty"`
+	Files int    `json:"files"`
+	// Bytes is the size of the skill's zip, measured along with SHA256.
+	Bytes      int64         `json:"bytes"`
+	Duration   time.Duration `json:"-"`
+	DurationMs float64       `json:"duration_ms"`
+	// SHA256 is the checksum of the skill's zip, set when a JSON report
+	// or -baseline is requested. For a zip split by -split-size it covers
+	// the parts joined in order.
+	SHA256 string `json:"sha256,omitempty"`
+	// Parts holds the size and checksum of each part of a split zip.
+	Parts []PartChecksum `json:"parts,omitempty"`
+	// Change compares SHA256 with the -baseline report: "new", "changed",
+	// or "unchanged".
+	Change string `json:"change,omitempty"`
+}
+
+// PartChecksum records one part of a zip split by -split-size.
+type PartChecksum struct {
+	File   string `json:"file"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// Skill result statuses
+const (
+	statusSuccess = "success"
+	statusFailed  = "failed"
+	statusSkipped = "skipped"
+)
+
+// Reporter writes a report of the run once every skill has been processed.
+// Several reporters can be active at once.
+type Reporter interface {
+	Report(stats *PackageStats) error
+}
+
+// PackageOptions holds the settings that control how skills are packaged.
+type PackageOptions struct {
+	OutputDir string `json:"output_dir"`
+	Verbose   bool   `json:"verbose"`
+	DryRun    bool   `json:"dry_run"`
+	UsePrefix bool   `json:"prefix"`
+	// JSONFormat controls how the generated JSON files are encoded.
+	JSONFormat JSONFormat `json:"json_format"`
+	// GitRef, when set, reads skill files from this git ref instead of the
+	// working tree.
+	GitRef string `json:"git_ref,omitempty"`
+	// Events receives lifecycle events; nil when -events is not set.
+	Events *EventEmitter `json:"-"`
+	// Progress renders a progress bar; nil when -progress is not active.
+	Progress *ProgressBar `json:"-"`
+	// RequiredDirs lists subdirectories every skill must contain.
+	RequiredDirs []string `json:"require_dirs"`
+	// RequiredFiles lists files every skill must contain.
+	RequiredFiles []string `json:"require_files"`
+	// Exclude lists path.Match patterns for files never packaged.
+	Exclude []string `json:"exclude"`
+	// HonorGitattributes leaves out files marked export-ignore in
+	// .gitattributes, as git archive does.
+	HonorGitattributes bool `json:"honor_gitattributes"`
+	// ChangedFiles holds the absolute paths changed since the -since-git
+	// ref; nil packages every skill.
+	ChangedFiles map[string]bool `json:"-"`
+	// SinceGit is the ref ChangedFiles was computed against.
+	SinceGit string `json:"since_git,omitempty"`
+	// ExcludeSkills holds the skill names, or plugin/skill keys, read
+	// from -exclude-skills-file; those skills are not packaged.
+	ExcludeSkills map[string]bool `json:"exclude_skills,omitempty"`
+	// MaxFileSize fails a skill containing a larger file; 0 disables
+	// the limit.
+	MaxFileSize int64 `json:"max_file_size"`
+	// Dedupe keeps an existing zip whose contents match the new one, so
+	// its modification time does not change.
+	Dedupe bool `json:"dedupe"`
+	// VerifyExtraction extracts each new zip and compares it with the
+	// source files, failing the skill on any difference.
+	VerifyExtraction bool `json:"verify_extraction"`
+	// SplitSize splits a larger zip into part zips of about this size; 0
+	// keeps every skill in one zip.
+	SplitSize int64 `json:"split_size"`
+	// SkipBuild disables plugin build commands.
+	SkipBuild bool `json:"skip_build"`
+	// SkipScripts disables skill prepackage commands.
+	SkipScripts bool `json:"skip_scripts"`
+	// AssumeYes answers yes to every confirmation prompt.
+	AssumeYes bool `json:"assume_yes"`
+	// Resume skips skills whose zip already exists and opens cleanly.
+	Resume bool `json:"resume"`
+	// WarnDuplicates downgrades duplicate zip entries from an error to a
+	// warning, keeping the first file written.
+	WarnDuplicates bool `json:"warn_duplicates"`
+	// Lock holds the expected source hashes; nil when -lockfile is not set.
+	Lock *Lockfile `json:"-"`
+	// OutputMode, when non-zero, is applied to every zip file created.
+	OutputMode os.FileMode `json:"output_mode,omitempty"`
+	// IncludeParents lists directories, relative to each skill's source,
+	// bundled into its zip alongside the skill's own files.
+	IncludeParents []string `json:"include_parents"`
+	// Compression is the default compression setting for zip entries; a
+	// skill's frontmatter may override it.
+	Compression string `json:"compression"`
+	// CompressThreshold stores files smaller than this many bytes without
+	// compression; 0 compresses every file.
+	CompressThreshold int64 `json:"compress_threshold"`
+	// MarketplaceName is stamped into generated metadata such as manifests.
+	MarketplaceName string `json:"name,omitempty"`
+	// Manifest writes a <name>.zip.manifest.json sidecar next to each zip.
+	Manifest bool `json:"manifest"`
+	// GzipStats records the gzip-compressed size of SKILL.md in manifests.
+	GzipStats bool `json:"gzip_stats"`
+	// Labels are -label key=value pairs stamped into every zip comment and
+	// manifest.
+	Labels map[string]string `json:"labels"`
+	// SignKey signs each finished zip into a <name>.zip.sig sidecar; nil
+	// when -sign-key is not set.
+	SignKey ed25519.PrivateKey `json:"-"`
+	// ZipPassword encrypts every zip entry with AES-256 when non-empty.
+	ZipPassword string `json:"-"`
+	// SanitizeNames slugifies packaged skill names for use as file names.
+	SanitizeNames bool `json:"sanitize_names"`
+	// NameCase is the -name-case style applied to packaged skill names:
+	// "preserve", "lower", or "kebab".
+	NameCase string `json:"name_case"`
+	// OnCollision decides what happens when skills share a packaged name:
+	// "fail", "prefix", or "suffix".
+	OnCollision string `json:"on_collision"`
+	// NameOverrides maps "plugin/skill" to the packaged name chosen when
+	// resolving a collision.
+	NameOverrides map[string]string `json:"-"`
+	// NoRootPrefix writes zip entries at the archive root instead of under
+	// a directory named after the skill.
+	NoRootPrefix bool `json:"no_root_prefix"`
+	// StripPrefix is a slash-separated directory removed from the start of
+	// each entry's path within the skill; empty strips nothing.
+	StripPrefix string `json:"strip_prefix,omitempty"`
+	// MergedSkills maps a merged skill's directory to its source
+	// directories; see MarketplaceConfig.MergedSkills.
+	MergedSkills map[string][]string `json:"-"`
+	// SkillDefaults maps a skill's directory to its plugin's
+	// skillDefaults; see MarketplaceConfig.SkillDefaults.
+	SkillDefaults map[string]*Frontmatter `json:"-"`
+	// UpdateLock records source hashes in Lock instead of verifying them.
+	UpdateLock bool `json:"update_lock"`
+	// Fix rewrites SKILL.md files to correct fixable validation issues.
+	Fix bool `json:"fix"`
+	// AuditPerms flags world-writable, setuid and setgid files.
+	AuditPerms bool `json:"audit_perms"`
+	// RequireChangelog fails skills without a CHANGELOG.md, or whose
+	// latest changelog entry does not match the frontmatter version.
+	RequireChangelog bool `json:"require_changelog"`
+	// FrontmatterSchema, when set, is what every skill's frontmatter is
+	// validated against.
+	FrontmatterSchema *FrontmatterSchema `json:"-"`
+	// Strict turns -audit-perms warnings into skill failures.
+	Strict bool `json:"strict"`
+	// Force lets Fix overwrite files with uncommitted changes.
+	Force bool `json:"force"`
+	// VerboseErrors prints each failure's full error chain.
+	VerboseErrors bool `json:"verbose_errors"`
+	// ReportLargest is how many of the run's largest files to list in the
+	// summary; 0 disables the report.
+	ReportLargest int `json:"report_largest"`
+	// WarnFileCount warns about skills packaged with more files than
+	// this; 0 disables the warning.
+	WarnFileCount int `json:"warn_file_count"`
+	// ValidateData parses .json, .yaml and .yml files as they are packaged.
+	ValidateData bool `json:"validate_data"`
+	// BuildInfo adds a generated buildInfoName file to each zip.
+	BuildInfo bool `json:"build_info"`
+	// BuildTime is the build time recorded by BuildInfo, shared by every
+	// skill in the run.
+	BuildTime time.Time `json:"-"`
+}
+
+// Lockfile maps each skill, keyed as "plugin/skill", to the hash of the
+// source files it is packaged from.
+type Lockfile struct {
+	Skills map[string]string `json:"skills"`
+}
+
+// SkillSource provides read access to the files of a single skill,
+// independent of where those files are stored.
+type SkillSource interface {
+	// Location describes the source for log and error messages.
+	Location() string
+	// Exists reports whether the slash-separated relative path is present.
+	Exists(relPath string) (bool, error)
+	// DirExists reports whether the slash-separated relative path is a
+	// directory.
+	DirExists(relPath string) (bool, error)
+	// ReadFile returns the contents of the slash-separated relative path.
+	ReadFile(relPath string) ([]byte, error)
+	// Walk calls fn for every regular file in the skill.
+	Walk(fn func(file SourceFile) error) error
+}
+
+// SourceFile describes a single file yielded by a SkillSource.
+type SourceFile struct {
+	RelPath string
+	// Origin identifies where the file was read from, for messages
+	Origin  string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	Open    func() (io.ReadCloser, error)
+}
+
+// stdout receives all human-readable output. It is discarded under -quiet.
+var stdout io.Writer = os.Stdout
+
+// outputBuffer holds the output of one unit of work, such as a skill, and
+// writes it to out as a single block on Flush. Buffers sharing a mutex
+// never interleave their blocks, so output stays grouped per skill even
+// when skills are processed concurrently. A buffer itself belongs to one
+// goroutine; only Flush is synchronised.
+type outputBuffer struct {
+	out io.Writer
+	mu  *sync.Mutex
+	buf bytes.Buffer
+}
+
+// newOutputBuffer returns an empty buffer that flushes to out while
+// holding mu.
+func newOutputBuffer(out io.Writer, mu *sync.Mutex) *outputBuffer {
+	return &outputBuffer{out: out, mu: mu}
+}
+
+// Printf formats into the buffer.
+func (b *outputBuffer) Printf(format string, args ...interface{}) {
+	fmt.Fprintf(&b.buf, format, args...)
+}
+
+// Write appends p to the buffer, so an outputBuffer can stand in for any
+// io.Writer.
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	return b.buf.Write(p)
+}
+
+// Flush writes everything buffered so far as one block and empties the
+// buffer.
+func (b *outputBuffer) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, err := b.out.Write(b.buf.Bytes())
+	b.buf.Reset()
+	return err
+}
+
+// Event is a single progress event emitted as a JSON line under -events.
+type Event struct {
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	Plugin     string    `json:"plugin,omitempty"`
+	Skill      string    `json:"skill,omitempty"`
+	Files      int       `json:"files,omitempty"`
+	DurationMs float64   `json:"duration_ms,omitempty"`
+	Error      string    `json:"error,omitempty"`
+
+	// Totals, only set on run_done
+	SkillsPackaged *int `json:"skills_packaged,omitempty"`
+	SkillsFailed   *int `json:"skills_failed,omitempty"`
+	FilesAdded     *int `json:"files_added,omitempty"`
+}
+
+// EventEmitter writes events as JSON lines. A nil emitter discards events.
+type EventEmitter struct {
+	encoder *json.Encoder
+}
+
+// durationMs converts a duration to fractional milliseconds for events.
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func (e *EventEmitter) Emit(event Event) {
+	if e == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+	// Progress events are best effort; a broken pipe must not fail the run
+	_ = e.encoder.Encode(event)
+}
+
+// progressWindow is how many recent skill durations the ETA averages.
+const progressWindow = 20
+
+// ProgressBar draws a single updating status line on a terminal. It is
+// also an io.Writer: output written through it is printed above the bar,
+// which is then redrawn. A nil bar does nothing.
+type ProgressBar struct {
+	out       io.Writer
+	total     int
+	done      int
+	durations []time.Duration
+	drawn     bool
+}
+
+// Step records one finished skill. Zero durations (skipped skills) count
+// towards completion but not towards the ETA.
+func (p *ProgressBar) Step(d time.Duration) {
+	if p == nil {
+		return
+	}
+	p.done++
+	if d > 0 {
+		p.durations = append(p.durations, d)
+		if len(p.durations) > progressWindow {
+			p.durations = p.durations[1:]
+		}
+	}
+	p.draw()
+}
+
+func (p *ProgressBar) Write(b []byte) (int, error) {
+	p.clear()
+	n, err := p.out.Write(b)
+	if bytes.HasSuffix(b, []byte("\n")) {
+		p.draw()
+	}
+	return n, err
+}
+
+// Finish removes the bar so the summary starts on a clean line.
+func (p *ProgressBar) Finish() {
+	if p != nil {
+		p.clear()
+	}
+}
+
+func (p *ProgressBar) clear() {
+	if p.drawn {
+		fmt.Fprint(p.out, "\r\033[K")
+		p.drawn = false
+	}
+}
+
+func (p *ProgressBar) draw() {
+	if p.total == 0 {
+		return
+	}
+	const width = 30
+	filled := width * p.done / p.total
+	bar := strings.Repeat("=", filled)
+	if filled < width {
+		bar += ">" + strings.Repeat(" ", width-filled-1)
+	}
+
+	eta := "--:--"
+	if len(p.durations) > 0 {
+		var sum time.Duration
+		for _, d := range p.durations {
+			sum += d
+		}
+		remaining := (sum / time.Duration(len(p.durations))) * time.Duration(p.total-p.done)
+		secs := int(remaining.Round(time.Second).Seconds())
+		eta = fmt.Sprintf("%d:%02d", secs/60, secs%60)
+	}
+
+	p.clear()
+	fmt.Fprintf(p.out, "%d/%d skills, %d%% [%s] ETA %s", p.done, p.total, 100*p.done/p.total, bar, eta)
+	p.drawn = true
+}
+
+// isTerminal reports whether f is connected to a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func main() {
+	// Parse command-line flags
+	outputDir := flag.String("output", ".dist", "Output directory for skill zip files; may use {{.Date}}, {{.Time}}, or {{.Now.Format \"...\"}}")
+	marketplaceFile := flag.String("marketplace", "./.claude-plugin/marketplace.json", "Path to marketplace.json")
+	logFile := flag.String("log-file", "", "Also write all output, timestamped and without colors, to this file")
+	logMaxSize := flag.Int64("log-max-size", 0, "Rotate -log-file to <path>.1 once it would grow past this many bytes; 0 never rotates")
+	watchConfigFlag := flag.Bool("watch-config", false, "Re-run whenever marketplace.json or a file it references changes")
+	lenient := flag.Bool("lenient", false, "Skip malformed plugin entries in marketplace.json with a warning instead of failing")
+	marketplaceName := flag.String("name", "", "Marketplace name to use in reports and manifests (default: the name in marketplace.json)")
+	verbose := flag.Bool("verbose", false, "Enable verbose logging")
+	dryRun := flag.Bool("dry-run", false, "Perform a dry run without creating zip files")
+	dryRunFull := flag.Bool("dry-run-full", false, "Package everything into a temporary directory, report, then delete it")
+	usePrefix := flag.Bool("prefix", false, "Prefix skill names with plugin name (e.g., core-commit-messages)")
+	profile := flag.Bool("profile", false, "Print a per-skill timing report at the end of the run")
+	sinceGit := flag.String("since-git", "", "Only package skills with files changed since this git ref")
+	gitRef := flag.String("git-ref", "", "Package skills as they exist at this git ref instead of the working tree")
+	progress := flag.Bool("progress", false, "Show a progress bar with ETA when stdout is a terminal")
+	events := flag.Bool("events", false, "Emit JSON Lines progress events to stderr")
+	quiet := flag.Bool("quiet", false, "Suppress normal output on stdout")
+	summaryOnly := flag.Bool("summary-only", false, "Write per-skill output to stderr so stdout carries only the summary")
+	requireDirs := flag.String("require-dirs", "", "Comma-separated subdirectories every skill must contain (e.g., examples,references)")
+	requireChangelog := flag.Bool("require-changelog", false, "Fail skills without a CHANGELOG.md whose latest entry matches the frontmatter version, if any")
+	frontmatterSchema := flag.String("frontmatter-schema", "", "Fail skills whose frontmatter does not match this JSON schema (a subset: required, properties, types, enum, pattern, lengths, items)")
+	requireFiles := flag.String("require-files", "", "Comma-separated files every skill must contain (e.g., README.md)")
+	exclude := flag.String("exclude", "", "Comma-separated glob patterns for files to leave out of every zip (e.g., *.tmp,drafts)")
+	honorGitattributes := flag.Bool("honor-gitattributes", false, "Leave out files marked export-ignore in .gitattributes, as git archive does")
+	maxFileSize := flag.Int64("max-file-size", 0, "Fail skills containing a file larger than this many bytes; 0 disables the limit")
+	splitSize := flag.Int64("split-size", 0, "Split a skill's zip into <name>.part-N.zip files of at most this many bytes when it is larger; 0 disables splitting")
+	maxTotalSize := flag.Int64("max-total-size", 0, "Fail the run if all zips together are larger than this many bytes; 0 disables the limit")
+	jsonOut := flag.String("json-out", "", "Also write a JSON summary report to this path (- for stdout)")
+	junitOut := flag.String("junit-out", "", "Also write a JUnit XML report to this path")
+	resultFile := flag.String("result-file", "", "Also write each skill's result as JSON to this path: an object for a single skill, otherwise an array")
+	flag.StringVar(junitOut, "junit", "", "Alias for -junit-out")
+	baseline := flag.String("baseline", "", "Compare each zip's checksum with this earlier -json-out report and print what changed")
+	htmlIndex := flag.String("html-index", "", "Also write a static HTML catalog of the packaged zips to this path (e.g., .dist/index.html)")
+	groupBy := flag.String("group-by", "none", "Group skills in the -html-index catalog and -json-out report by frontmatter tag, by plugin, or not at all: tag, plugin, or none")
+	canonicalJSON := flag.Bool("canonical-json", false, "Sort object keys recursively in generated JSON files")
+	compactJSON := flag.Bool("compact-json", false, "Write generated JSON on a single line instead of indenting it")
+	dereferenceConfig := flag.Bool("dereference-config", false, "Print marketplace.json with all $ref entries inlined and exit")
+	timeout := flag.Duration("timeout", 0, "Abort the run if it takes longer than this (e.g., 5m); 0 disables the limit")
+	skipBuild := flag.Bool("skip-build", false, "Do not run plugin build commands")
+	skipScripts := flag.Bool("skip-scripts", false, "Do not run the prepackage commands declared in skill frontmatter")
+	assumeYes := flag.Bool("assume-yes", false, "Answer yes to all prompts (e.g., removing orphaned zips)")
+	flag.BoolVar(assumeYes, "y", false, "Shorthand for -assume-yes")
+	verifyExtraction := flag.Bool("verify-extraction", false, "Extract each zip after writing it and fail the skill unless every file matches its source in content and mode")
+	dedupe := flag.Bool("dedupe", false, "Leave an existing zip untouched when its entries and contents would not change")
+	resume := flag.Bool("resume", false, "Skip skills whose zip already exists in the output directory and is valid")
+	fromStdin := flag.Bool("from-stdin", false, "Only process the skills listed on stdin, one plugin/skill selector per line")
+	excludeSkillsFile := flag.String("exclude-skills-file", "", "Skip the skills named in this file, one skill or plugin/skill per line (# starts a comment)")
+	warnDuplicates := flag.Bool("warn-duplicates", false, "Warn and keep the first file instead of failing when a zip entry would be written twice")
+	lockfile := flag.String("lockfile", "", "Fail skills whose source hash does not match this lockfile")
+	updateLock := flag.Bool("update-lock", false, "Rewrite -lockfile with the current source hashes instead of verifying them")
+	listFiles := flag.Bool("list-files", false, "Print the files each skill would include and exit without packaging")
+	format := flag.String("format", "text", "Output format for -list-files and -check: text or json")
+	check := flag.Bool("check", false, "Run every validation without writing anything, report problems by category, and exit non-zero if any are found")
+	reportUnused := flag.Bool("report-unused", false, "List skill directories not referenced by marketplace.json and exit")
+	strict := flag.Bool("strict", false, "With -report-unused, exit non-zero if any unused skills are found; with -audit-perms or -validate-data, fail skills with flagged files; fail on skill entries outside their plugin source, on file names differing only by case, and on output paths differing only by case")
+	validateData := flag.Bool("validate-data", false, "Report .json files in skills that fail to parse, and .yaml and .yml files indented with tabs (YAML is not fully parsed)")
+	buildInfo := flag.Bool("build-info", false, "Add a "+buildInfoName+" file with the source git commit, branch, dirty flag, and build time to each zip")
+	auditPerms := flag.Bool("audit-perms", false, "Warn about world-writable, setuid or setgid files and clear those bits in the zip")
+	outputMode := flag.String("output-mode", "", "Octal permissions for created zip files (e.g., 0644); default leaves them to the umask")
+	includeParent := flag.String("include-parent", "", "Comma-separated directories, relative to each skill, to bundle into its zip (e.g., ../_partials)")
+	compression := flag.String("compression", "default", "Zip compression: store, fast, default, or best (skills may override in frontmatter)")
+	manifest := flag.Bool("manifest", false, "Write a <name>.zip.manifest.json listing each file's size and whether it is binary")
+	gzipStats := flag.Bool("gzip-stats", false, "With -manifest, record how small each SKILL.md compresses with gzip")
+	labels := labelsFlag{}
+	flag.Var(labels, "label", "Add a key=value label to every zip comment and manifest (repeatable)")
+	signKey := flag.String("sign-key", "", "Sign each zip with this PEM-encoded ed25519 private key")
+	zipPassword := flag.String("zip-password", "", "Encrypt each zip with AES-256 using this password (or set "+zipPasswordEnv+")")
+	unzip := flag.Bool("unzip", false, "Check that every zip in the output directory opens with the zip password and exit")
+	verifySig := flag.String("verify-sig", "", "Verify the zips in the output directory against this PEM-encoded ed25519 public key and exit")
+	sanitizeNames := flag.Bool("sanitize-names", false, "Slugify packaged skill names (lowercase, hyphens, safe characters only)")
+	nameCase := flag.String("name-case", "preserve", "Case of packaged skill names: preserve, lower, or kebab")
+	onCollision := flag.String("on-collision", "fail", "When skills share a packaged name: fail, prefix (add the plugin name), or suffix (append -2, -3, ...)")
+	prefixOnCollision := flag.Bool("prefix-on-collision", false, "Shorthand for -on-collision prefix: add the plugin prefix only to skills whose names collide")
+	noRootPrefix := flag.Bool("no-root-prefix", false, "Put skill files at the zip root instead of under a <skill-name>/ directory")
+	stripPrefix := flag.String("strip-prefix", "", "Remove this leading directory from each file's path within the skill (e.g., src)")
+	selftest := flag.Bool("selftest", false, "Package a generated sample skill in a temporary directory to check this machine, then exit")
+	purgeOrphans := flag.Bool("purge-orphans", false, "Remove zips in the output directory that no longer match a skill")
+	fix := flag.Bool("fix", false, "With -dry-run, rewrite SKILL.md files to correct fixable issues")
+	force := flag.Bool("force", false, "Let -fix overwrite SKILL.md files that have uncommitted changes, and -convert replace existing SKILL.md files")
+	repackage := flag.String("repackage", "", "Rebuild every zip in this directory into -output with the current compression, signing, manifest and label options, then exit")
+	scaffold := flag.String("scaffold", "", "Create a new skill at <plugin>/<skill> with a stub SKILL.md and the -require-dirs layout, then exit")
+	scaffoldDescription := flag.String("scaffold-description", "", "Description for the -scaffold SKILL.md (default: a TODO placeholder)")
+	scaffoldVersion := flag.String("scaffold-version", "0.1.0", "Version for the -scaffold SKILL.md")
+	register := flag.Bool("register", false, "With -scaffold, also add the new skill to its plugin's skills in marketplace.json")
+	convert := flag.Bool("convert", false, "Generate SKILL.md from each skill's legacy meta.yaml, then exit")
+	verboseErrors := flag.Bool("verbose-errors", false, "Print the full chain of wrapped errors beneath each failure")
+	reportLargest := flag.Int("report-largest", 0, "List the N largest files packaged across the run in the summary; 0 disables the report")
+	compressThreshold := flag.Int64("compress-threshold", 0, "Store files smaller than this many bytes uncompressed, since deflate can grow tiny files; 0 compresses every file")
+	warnFileCount := flag.Int("warn-file-count", 0, "Warn about skills packaged with more than N files, without failing them; 0 disables the warning")
+	printConfig := flag.Bool("print-config", false, "Print the effective settings, after the policy file and environment are applied, as JSON and exit")
+	flag.Parse()
+
+	jsonFormat := JSONFormat{Canonical: *canonicalJSON, Compact: *compactJSON}
+
+	// -log-file replaces the console streams with pipes, so check for a
+	// terminal first
+	stdoutTerminal, stderrTerminal := isTerminal(os.Stdout), isTerminal(os.Stderr)
+
+	if *logFile != "" {
+		if *logMaxSize < 0 {
+			fatal("-log-max-size must not be negative")
+		}
+		var err error
+		if logOut, err = startLogOutput(*logFile, *logMaxSize); err != nil {
+			fatal("Failed to open -log-file: %v", err)
+		}
+		defer logOut.Close()
+		stdout = os.Stdout
+	}
+
+	if *watchConfigFlag {
+		// Every re-run would need the work list again
+		if *fromStdin {
+			fatal("-from-stdin cannot be combined with -watch-config")
+		}
+		watchConfig(*marketplaceFile)
+		return
+	}
+
+	if *quiet {
+		stdout = io.Discard
+	} else if *summaryOnly {
+		stdout = os.Stderr
+	}
+
+	expandedOutputDir, err := expandOutputDir(*outputDir, time.Now())
+	if err != nil {
+		fatal("Invalid -output template: %v", err)
+	}
+
+	// Convert to absolute path
+	absOutputDir, err := filepath.Abs(expandedOutputDir)
+	if err != nil {
+		fatal("Failed to resolve output path: %v", err)
+	}
+
+	// A full dry run goes through the real write path, just somewhere
+	// disposable
+	if *dryRunFull {
+		if *dryRun {
+			fatal("-dry-run-full cannot be combined with -dry-run")
+		}
+		if *purgeOrphans {
+			fatal("-dry-run-full cannot be combined with -purge-orphans")
+		}
+		absOutputDir, err = os.MkdirTemp("", "package-skills-")
+		if err != nil {
+			fatal("Failed to create temporary directory: %v", err)
+		}
+		tempOutputDir = absOutputDir
+	}
+	if *fix && !*dryRun {
+		fatal("-fix requires -dry-run")
+	}
+
+	// Skills left out of the work list would look like orphans
+	var selectors []string
+	if *fromStdin {
+		if *purgeOrphans {
+			fatal("-from-stdin cannot be combined with -purge-orphans")
+		}
+		if selectors, err = readSkillSelectors(os.Stdin); err != nil {
+			fatal("Failed to read skill selectors from stdin: %v", err)
+		}
+	}
+
+	opts := &PackageOptions{
+		OutputDir:          absOutputDir,
+		JSONFormat:         jsonFormat,
+		Verbose:            *verbose,
+		DryRun:             *dryRun,
+		UsePrefix:          *usePrefix,
+		GitRef:             *gitRef,
+		SkipBuild:          *skipBuild,
+		SkipScripts:        *skipScripts,
+		AssumeYes:          *assumeYes,
+		Resume:             *resume,
+		Dedupe:             *dedupe,
+		VerifyExtraction:   *verifyExtraction,
+		WarnDuplicates:     *warnDuplicates,
+		UpdateLock:         *updateLock,
+		Fix:                *fix,
+		AuditPerms:         *auditPerms,
+		RequireChangelog:   *requireChangelog,
+		Strict:             *strict,
+		Force:              *force,
+		VerboseErrors:      *verboseErrors,
+		ReportLargest:      *reportLargest,
+		WarnFileCount:      *warnFileCount,
+		ValidateData:       *validateData,
+		BuildInfo:          *buildInfo,
+		BuildTime:          time.Now(),
+		NoRootPrefix:       *noRootPrefix,
+		Manifest:           *manifest,
+		GzipStats:          *gzipStats,
+		Labels:             labels,
+		Compression:        *compression,
+		CompressThreshold:  *compressThreshold,
+		IncludeParents:     splitPathList(*includeParent),
+		SanitizeNames:      *sanitizeNames,
+		NameCase:           *nameCase,
+		OnCollision:        *onCollision,
+		RequiredDirs:       splitPathList(*requireDirs),
+		RequiredFiles:      splitPathList(*requireFiles),
+		Exclude:            splitPathList(*exclude),
+		HonorGitattributes: *honorGitattributes,
+		MaxFileSize:        *maxFileSize,
+		SplitSize:          *splitSize,
+	}
+
+	// The changed files are only known once marketplace.json says which
+	// repositories the skills live in
+	opts.SinceGit = *sinceGit
+
+	if *excludeSkillsFile != "" {
+		if opts.ExcludeSkills, err = readExcludeSkillsFile(*excludeSkillsFile); err != nil {
+			fatal("Failed to read -exclude-skills-file: %v", err)
+		}
+	}
+	if *frontmatterSchema != "" {
+		if opts.FrontmatterSchema, err = readFrontmatterSchema(*frontmatterSchema); err != nil {
+			fatal("Invalid -frontmatter-schema: %v", err)
+		}
+	}
+
+	if opts.OutputMode, err = parseOutputMode(*outputMode); err != nil {
+		fatal("Invalid -output-mode: %v", err)
+	}
+	if opts.ReportLargest < 0 {
+		fatal("-report-largest must not be negative")
+	}
+	if opts.WarnFileCount < 0 {
+		fatal("-warn-file-count must not be negative")
+	}
+	if opts.CompressThreshold < 0 {
+		fatal("-compress-threshold must not be negative")
+	}
+	if opts.SplitSize < 0 {
+		fatal("-split-size must not be negative")
+	}
+	env, unknownEnv := loadEnv()
+	if opts.Dedupe && (*zipPassword != "" || env[zipPasswordEnv] != "") {
+		fatal("-dedupe cannot compare encrypted zips; drop -zip-password")
+	}
+	if opts.VerifyExtraction && (*zipPassword != "" || env[zipPasswordEnv] != "") {
+		fatal("-verify-extraction cannot extract encrypted zips; drop -zip-password")
+	}
+	if *stripPrefix != "" {
+		cleaned := path.Clean(filepath.ToSlash(*stripPrefix))
+		if cleaned == "." || cleaned == ".." || path.IsAbs(cleaned) || strings.HasPrefix(cleaned, "../") {
+			fatal("Invalid -strip-prefix %q: must be a directory inside the skill", *stripPrefix)
+		}
+		opts.StripPrefix = cleaned
+	}
+	if opts.NameCase != "preserve" && opts.NameCase != "lower" && opts.NameCase != "kebab" {
+		fatal("Unknown -name-case %q (expected preserve, lower, or kebab)", opts.NameCase)
+	}
+	if opts.OnCollision != "fail" && opts.OnCollision != "prefix" && opts.OnCollision != "suffix" {
+		fatal("Unknown -on-collision %q (expected fail, prefix, or suffix)", opts.OnCollision)
+	}
+	if *prefixOnCollision {
+		if opts.UsePrefix {
+			fatal("-prefix-on-collision cannot be combined with -prefix, which already prefixes every skill")
+		}
+		if opts.OnCollision == "suffix" {
+			fatal("-prefix-on-collision cannot be combined with -on-collision suffix")
+		}
+		opts.OnCollision = "prefix"
+	}
+	if _, ok := compressionLevels[opts.Compression]; !ok {
+		fatal("Unknown -compression %q (expected store, fast, default, or best)", opts.Compression)
+	}
+
+	// Settings from a .skillpolicy file apply unless the matching flag was
+	// given on the command line
+	policy, policyPath, err := loadSkillPolicy(*marketplaceFile)
+	if err != nil {
+		fatal("Failed to read policy file: %v", err)
+	}
+	if policy != nil {
+		setFlags := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+		if !setFlags["require-dirs"] {
+			opts.RequiredDirs = policy.RequireDirs
+		}
+		if !setFlags["require-files"] {
+			opts.RequiredFiles = policy.RequireFiles
+		}
+		if !setFlags["exclude"] {
+			opts.Exclude = policy.Exclude
+		}
+		if !setFlags["max-file-size"] {
+			opts.MaxFileSize = policy.MaxFileSize
+		}
+	}
+	for _, pattern := range opts.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			fatal("Invalid exclude pattern %q: %v", pattern, err)
+		}
+	}
+	if *gzipStats && !*manifest {
+		fatal("-gzip-stats requires -manifest")
+	}
+	if *maxTotalSize < 0 {
+		fatal("-max-total-size must not be negative")
+	}
+	if *maxTotalSize > 0 && *dryRun {
+		fatal("-max-total-size needs zip files; use -dry-run-full instead of -dry-run")
+	}
+	if *baseline != "" && *dryRun {
+		fatal("-baseline needs zip checksums; use -dry-run-full instead of -dry-run")
+	}
+	if *htmlIndex != "" && (*dryRun || *dryRunFull) {
+		fatal("-html-index needs real zip files and cannot be combined with -dry-run or -dry-run-full")
+	}
+	if *groupBy != "tag" && *groupBy != "plugin" && *groupBy != "none" {
+		fatal("Unknown -group-by %q (expected tag, plugin, or none)", *groupBy)
+	}
+	if *groupBy != "none" && *htmlIndex == "" && *jsonOut == "" {
+		fatal("-group-by requires -html-index or -json-out")
+	}
+	if *updateLock && *lockfile == "" {
+		fatal("-update-lock requires -lockfile")
+	}
+	if *lockfile != "" {
+		lock, err := readLockfile(*lockfile, *updateLock)
+		if err != nil {
+			fatal("Failed to read lockfile: %v", err)
+		}
+		opts.Lock = lock
+	}
+	if *events {
+		opts.Events = &EventEmitter{encoder: json.NewEncoder(os.Stderr)}
+	}
+
+	if *dereferenceConfig {
+		marketplace, err := readMarketplace(*marketplaceFile, *lenient)
+		if err != nil {
+			fatal("Failed to read marketplace.json: %v", err)
+		}
+		data, err := marshalJSON(marketplace, jsonFormat)
+		if err != nil {
+			fatal("Failed to encode marketplace.json: %v", err)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	if *selftest {
+		if !runSelfTest(opts) {
+			exit(1)
+		}
+		return
+	}
+
+	opts.ZipPassword = *zipPassword
+	if opts.ZipPassword == "" {
+		opts.ZipPassword = env[zipPasswordEnv]
+	}
+
+	if *printConfig {
+		absMarketplace, err := filepath.Abs(*marketplaceFile)
+		if err != nil {
+			fatal("Failed to resolve marketplace path: %v", err)
+		}
+		opts.MarketplaceName = *marketplaceName
+		config := effectiveConfig{
+			Marketplace:       absMarketplace,
+			PolicyFile:        policyPath,
+			FrontmatterSchema: *frontmatterSchema,
+			Environment:       redactEnv(env),
+			PackageOptions:    opts,
+			DryRunFull:        *dryRunFull,
+			Lenient:           *lenient,
+			MaxTotalSize:      *maxTotalSize,
+			Timeout:           timeout.String(),
+			Lockfile:          *lockfile,
+			SignKey:           *signKey,
+			ZipPassword:       opts.ZipPassword != "",
+			JSONOut:           *jsonOut,
+			JUnitOut:          *junitOut,
+			ResultFile:        *resultFile,
+			HTMLIndex:         *htmlIndex,
+			Baseline:          *baseline,
+		}
+		data, err := marshalJSON(config, jsonFormat)
+		if err != nil {
+			fatal("Failed to encode configuration: %v", err)
+		}
+		fmt.Println(string(data))
+		if *dryRunFull {
+			os.RemoveAll(absOutputDir)
+		}
+		return
+	}
+
+	for _, name := range unknownEnv {
+		fmt.Fprintf(stdout, "%s[WARN]%s Unknown environment variable %s\n", colorYellow, colorReset, name)
+	}
+
+	if *unzip {
+		if opts.ZipPassword == "" {
+			fatal("-unzip requires -zip-password or %s", zipPasswordEnv)
+		}
+		verified, failed, err := verifyZipPasswords(absOutputDir, opts.ZipPassword)
+		if err != nil {
+			fatal("Failed to verify zip files: %v", err)
+		}
+		fmt.Fprintf(stdout, "\n%sZips verified:%s     %d\n", colorBlue, colorReset, verified)
+		if failed > 0 {
+			fatal("%d zip files failed to open with the password", failed)
+		}
+		return
+	}
+
+	if *verifySig != "" {
+		publicKey, err := readPublicKey(*verifySig)
+		if err != nil {
+			fatal("Failed to read public key: %v", err)
+		}
+		verified, failed, err := verifyZipSignatures(absOutputDir, publicKey)
+		if err != nil {
+			fatal("Failed to verify signatures: %v", err)
+		}
+		fmt.Fprintf(stdout, "\n%sSignatures verified:%s %d\n", colorBlue, colorReset, verified)
+		if failed > 0 {
+			fatal("%d zip files failed signature verification", failed)
+		}
+		return
+	}
+
+	if *signKey != "" && !opts.DryRun {
+		privateKey, err := readPrivateKey(*signKey)
+		if err != nil {
+			fatal("Failed to read signing key: %v", err)
+		}
+		opts.SignKey = privateKey
+	}
+
+	if *repackage != "" {
+		absRepackage, err := filepath.Abs(*repackage)
+		if err != nil {
+			fatal("Failed to resolve -repackage path: %v", err)
+		}
+		if absRepackage == absOutputDir {
+			fatal("-repackage needs an -output directory other than the one it reads from")
+		}
+		if opts.DryRun || opts.UsePrefix {
+			fatal("-repackage cannot be combined with -dry-run or -prefix")
+		}
+		if err := os.MkdirAll(absOutputDir, 0755); err != nil {
+			fatal("Failed to create output directory: %v", err)
+		}
+		stats := &PackageStats{}
+		if err := repackageZips(absRepackage, opts, stats); err != nil {
+			fatal("Failed to repackage zip files: %v", err)
+		}
+		printSummary(stats, absOutputDir, false, *dryRunFull)
+		if *dryRunFull {
+			os.RemoveAll(absOutputDir)
+		}
+		return
+	}
+
+	if *register && *scaffold == "" {
+		fatal("-register requires -scaffold")
+	}
+	if *scaffold != "" {
+		marketplace, err := readMarketplace(*marketplaceFile, *lenient)
+		if err != nil {
+			fatal("Failed to read marketplace.json: %v", err)
+		}
+		frontmatter := &Frontmatter{scalars: map[string]string{"description": *scaffoldDescription, "version": *scaffoldVersion}, lists: map[string][]string{}}
+		if frontmatter.scalars["description"] == "" {
+			frontmatter.scalars["description"] = "TODO: describe what this skill does and when to use it"
+		}
+		if err := scaffoldSkill(marketplace, *scaffold, frontmatter, opts); err != nil {
+			fatal("Failed to scaffold skill: %v", err)
+		}
+		if *register {
+			pluginName, skillName, _ := strings.Cut(*scaffold, "/")
+			if err := registerSkill(*marketplaceFile, pluginName, "./skills/"+skillName, opts); err != nil {
+				fatal("Failed to register skill: %v", err)
+			}
+		}
+		return
+	}
+
+	if *convert {
+		marketplace, err := readMarketplace(*marketplaceFile, *lenient)
+		if err != nil {
+			fatal("Failed to read marketplace.json: %v", err)
+		}
+		opts.MergedSkills = marketplace.MergedSkills
+		opts.SkillDefaults = marketplace.SkillDefaults
+		converted, err := convertLegacySkills(marketplace, opts)
+		if err != nil {
+			fatal("Failed to convert skills: %v", err)
+		}
+		fmt.Fprintf(stdout, "\n%sSkills converted:%s  %d\n", colorBlue, colorReset, converted)
+		return
+	}
+
+	if *reportUnused {
+		marketplace, err := readMarketplace(*marketplaceFile, *lenient)
+		if err != nil {
+			fatal("Failed to read marketplace.json: %v", err)
+		}
+		unused, err := findUnusedSkills(marketplace)
+		if err != nil {
+			fatal("Failed to scan skill directories: %v", err)
+		}
+		for _, dir := range unused {
+			fmt.Fprintf(stdout, "%s[UNUSED]%s %s\n", colorYellow, colorReset, dir)
+		}
+		if len(unused) == 0 {
+			fmt.Fprintf(stdout, "%sAll skill directories are referenced by marketplace.json%s\n", colorGreen, colorReset)
+		} else if *strict {
+			fatal("%d skill directories are not referenced by marketplace.json", len(unused))
+		}
+		return
+	}
+
+	if *check {
+		if *format != "text" && *format != "json" {
+			fatal("Unknown -format %q (expected text or json)", *format)
+		}
+		if opts.Fix {
+			fatal("-check never rewrites files; run -fix separately")
+		}
+		// Keep stdout for the JSON report
+		if *format == "json" {
+			stdout = os.Stderr
+		}
+		marketplace, err := readMarketplace(*marketplaceFile, *lenient)
+		if err != nil {
+			fatal("Failed to read marketplace.json: %v", err)
+		}
+		opts.MergedSkills = marketplace.MergedSkills
+		opts.SkillDefaults = marketplace.SkillDefaults
+		report := runChecks(marketplace, opts)
+		if *format == "json" {
+			data, err := marshalJSON(report, jsonFormat)
+			if err != nil {
+				fatal("Failed to encode check report: %v", err)
+			}
+			fmt.Println(string(data))
+		} else {
+			printCheckReport(report)
+		}
+		if !report.Passed {
+			exit(1)
+		}
+		return
+	}
+
+	if *listFiles {
+		if *format != "text" && *format != "json" {
+			fatal("Unknown -format %q (expected text or json)", *format)
+		}
+		marketplace, err := readMarketplace(*marketplaceFile, *lenient)
+		if err != nil {
+			fatal("Failed to read marketplace.json: %v", err)
+		}
+		opts.MergedSkills = marketplace.MergedSkills
+		opts.SkillDefaults = marketplace.SkillDefaults
+		listings := listSkillFiles(marketplace, opts)
+		if *format == "json" {
+			data, err := marshalJSON(struct {
+				Skills []SkillListing `json:"skills"`
+			}{listings}, jsonFormat)
+			if err != nil {
+				fatal("Failed to encode file listing: %v", err)
+			}
+			fmt.Println(string(data))
+		} else {
+			printFileListing(listings)
+		}
+		for _, listing := range listings {
+			if listing.Error != "" {
+				exit(1)
+			}
+		}
+		return
+	}
+
+	// Print configuration
+	printHeader("Package Skills to Zip Files")
+	fmt.Fprintf(stdout, "%sOutput directory:%s %s\n", colorBlue, colorReset, absOutputDir)
+	if opts.GitRef != "" {
+		fmt.Fprintf(stdout, "%sGit ref:%s %s\n", colorBlue, colorReset, opts.GitRef)
+	}
+	if policy != nil {
+		fmt.Fprintf(stdout, "%sPolicy file:%s %s\n", colorBlue, colorReset, policyPath)
+	}
+	if opts.DryRun {
+		fmt.Fprintf(stdout, "%sDry run mode: No files will be created%s\n", colorYellow, colorReset)
+	}
+	if *dryRunFull {
+		fmt.Fprintf(stdout, "%sFull dry run: the output directory is temporary and removed afterwards%s\n", colorYellow, colorReset)
+	}
+	fmt.Fprintln(stdout)
+
+	// Read marketplace.json
+	marketplace, err := readMarketplace(*marketplaceFile, *lenient)
+	if err != nil {
+		fatal("Failed to read marketplace.json: %v", err)
+	}
+	for _, skipErr := range marketplace.Skipped {
+		fmt.Fprintf(stdout, "%s[WARN]%s Skipped %v\n", colorYellow, colorReset, skipErr)
+	}
+	if len(marketplace.Skipped) > 0 {
+		fmt.Fprintf(stdout, "%s[WARN]%s %d plugin entries skipped due to parse errors\n", colorYellow, colorReset, len(marketplace.Skipped))
+	}
+	for _, warning := range marketplace.Warnings {
+		fmt.Fprintf(stdout, "%s[WARN]%s %s\n", colorYellow, colorReset, warning)
+	}
+	if opts.SinceGit != "" {
+		var sources []string
+		for _, plugin := range marketplace.Plugins {
+			sources = append(sources, plugin.Source)
+		}
+		if opts.ChangedFiles, err = gitChangedFiles(opts.SinceGit, sources); err != nil {
+			fmt.Fprintf(stdout, "%s[WARN]%s Cannot diff against %s, packaging all skills: %v\n", colorYellow, colorReset, opts.SinceGit, err)
+			opts.SinceGit = ""
+		}
+	}
+	if outside := skillPathsOutsideSource(marketplace); len(outside) > 0 {
+		for _, problem := range outside {
+			if *strict {
+				fmt.Fprintf(stdout, "%s[ERROR]%s %s\n", colorRed, colorReset, problem)
+			} else {
+				fmt.Fprintf(stdout, "%s[WARN]%s %s\n", colorYellow, colorReset, problem)
+			}
+		}
+		if *strict {
+			fatal("%d skill entries are outside their plugin source", len(outside))
+		}
+	}
+	for _, name := range unknownExcludedSkills(opts.ExcludeSkills, marketplace) {
+		fmt.Fprintf(stdout, "%s[WARN]%s -exclude-skills-file names unknown skill '%s'\n", colorYellow, colorReset, name)
+	}
+
+	// Override the name used in generated output; marketplace.json itself
+	// is never rewritten
+	if *marketplaceName != "" {
+		marketplace.Name = *marketplaceName
+	}
+	opts.MarketplaceName = marketplace.Name
+	opts.MergedSkills = marketplace.MergedSkills
+	opts.SkillDefaults = marketplace.SkillDefaults
+
+	if err := resolveNameCollisions(marketplace, opts); err != nil {
+		fatal("%v", err)
+	}
+
+	if opts.SanitizeNames || opts.NameCase != "preserve" {
+		original := func(pluginName, skillName string) string {
+			unsanitized := *opts
+			unsanitized.SanitizeNames = false
+			unsanitized.NameCase = "preserve"
+			unsanitized.NameOverrides = nil
+			return packagedSkillName(pluginName, skillName, &unsanitized)
+		}
+		sanitized := func(pluginName, skillName string) string {
+			return packagedSkillName(pluginName, skillName, opts)
+		}
+		if err := checkSanitizedNames(marketplace, original, sanitized, opts.Verbose); err != nil {
+			fatal("Failed to rename skills: %v", err)
+		}
+	}
+
+	// Abort before a zip clobbers another skill's
+	zipPath := func(pluginName, skillName string) string {
+		return filepath.Join(opts.OutputDir, packagedSkillName(pluginName, skillName, opts)+".zip")
+	}
+	caseClashes, err := checkOutputPaths(marketplace, zipPath)
+	if err != nil {
+		fatal("Output path collision: %v", err)
+	}
+	for _, clash := range caseClashes {
+		if *strict {
+			fatal("Output path collision: %s", clash)
+		}
+		fmt.Fprintf(stdout, "%s[WARN]%s %s\n", colorYellow, colorReset, clash)
+	}
+
+	// The lockfile keeps entries for every skill in marketplace.json,
+	// not just those in this run's work list
+	knownSkills := make(map[string]bool)
+	for _, plugin := range marketplace.Plugins {
+		for _, skillPath := range plugin.Skills {
+			knownSkills[plugin.Name+"/"+filepath.Base(skillPath)] = true
+		}
+	}
+
+	// Selection comes after name resolution so a skill gets the same
+	// name whether or not it is in the work list
+	if *fromStdin {
+		for _, warning := range selectSkills(marketplace, selectors) {
+			fmt.Fprintf(stdout, "%s[WARN]%s %s\n", colorYellow, colorReset, warning)
+		}
+	}
+
+	// Carriage returns only make sense on a terminal; elsewhere the
+	// line-by-line output is kept as is
+	progressOut, progressTerminal := os.Stdout, stdoutTerminal
+	if *summaryOnly {
+		progressOut, progressTerminal = os.Stderr, stderrTerminal
+	}
+	if *progress && !opts.Verbose && !*quiet && progressTerminal {
+		total := 0
+		for _, plugin := range marketplace.Plugins {
+			total += len(plugin.Skills)
+		}
+		opts.Progress = &ProgressBar{out: progressOut, total: total}
+		stdout = opts.Progress
+	}
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	// Create output directory
+	stats := &PackageStats{SizeBudget: *maxTotalSize}
+	start := time.Now()
+	if !opts.DryRun {
+		if err := os.MkdirAll(absOutputDir, 0755); err != nil {
+			fatal("Failed to create output directory: %v", err)
+		}
+		err := createSkillZips(ctx, marketplace, opts, stats)
+		// Checksums must be taken before a full dry run's zips are removed
+		if err == nil && (*jsonOut != "" || *baseline != "" || *resultFile != "") {
+			err = checksumZips(stats, opts)
+		}
+		if err == nil && *baseline != "" {
+			err = compareBaseline(*baseline, stats)
+		}
+		if err == nil && stats.SizeBudget > 0 {
+			stats.TotalZipSize, err = totalZipSize(stats, opts)
+		}
+		if *dryRunFull {
+			os.RemoveAll(absOutputDir)
+		}
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				fatal("Timed out after %s", *timeout)
+			}
+			fatal("Failed to create zip files: %v", err)
+		}
+	} else {
+		// Dry run - just validate skills
+		for _, plugin := range marketplace.Plugins {
+			if err := validatePlugin(ctx, plugin, opts, stats); err != nil {
+				fatal("Timed out after %s", *timeout)
+			}
+		}
+	}
+
+	if opts.UpdateLock && !opts.DryRun && !*dryRunFull {
+		for _, key := range pruneLock(opts.Lock, knownSkills) {
+			fmt.Fprintf(stdout, "%s[PRUNED]%s Lockfile entry %s: no such skill in marketplace.json\n", colorYellow, colorReset, key)
+		}
+		if err := writeLockfile(*lockfile, opts.Lock, jsonFormat); err != nil {
+			fatal("Failed to write lockfile: %v", err)
+		}
+		fmt.Fprintf(stdout, "%sUpdated lockfile:%s %s\n", colorBlue, colorReset, *lockfile)
+	}
+
+	if *purgeOrphans {
+		if err := purgeOrphanZips(marketplace, opts, stats); err != nil {
+			fatal("Failed to purge orphaned zip files: %v", err)
+		}
+	}
+
+	if opts.Progress != nil {
+		opts.Progress.Finish()
+		stdout = progressOut
+	}
+
+	// Only the summary reaches stdout under -summary-only; when the JSON
+	// report is written there instead, the summary box moves to stderr
+	if *summaryOnly {
+		stdout = os.Stdout
+		if *jsonOut == "-" {
+			stdout = os.Stderr
+		}
+	}
+
+	opts.Events.Emit(Event{
+		Type:           "run_done",
+		DurationMs:     durationMs(time.Since(start)),
+		SkillsPackaged: &stats.SkillsPackaged,
+		SkillsFailed:   &stats.SkillsFailed,
+		FilesAdded:     &stats.FilesAdded,
+	})
+
+	// Print summary and any additional reports
+	reporters := []Reporter{consoleReporter{outputDir: absOutputDir, dryRun: opts.DryRun, tempOutput: *dryRunFull}}
+	if *jsonOut != "" {
+		reporters = append(reporters, jsonReporter{path: *jsonOut, marketplace: marketplace.Name, dryRun: opts.DryRun || *dryRunFull, format: jsonFormat, groupBy: *groupBy, sources: skillSourceDirs(marketplace), opts: opts})
+	}
+	if *junitOut != "" {
+		reporters = append(reporters, junitReporter{path: *junitOut})
+	}
+	if *resultFile != "" {
+		reporters = append(reporters, resultFileReporter{path: *resultFile, format: jsonFormat})
+	}
+	if *htmlIndex != "" {
+		reporters = append(reporters, htmlReporter{path: *htmlIndex, marketplace: marketplace.Name, groupBy: *groupBy, sources: skillSourceDirs(marketplace), opts: opts})
+	}
+	for _, reporter := range reporters {
+		if err := reporter.Report(stats); err != nil {
+			fatal("Failed to write report: %v", err)
+		}
+	}
+	if *profile {
+		printProfile(stats, time.Since(start))
+	}
+	if stats.SizeBudget > 0 && stats.TotalZipSize > stats.SizeBudget {
+		fatal("Total zip size %s exceeds the %s budget by %s", formatBytes(stats.TotalZipSize), formatBytes(stats.SizeBudget), formatBytes(stats.TotalZipSize-stats.SizeBudget))
+	}
+}
+
+// rawMarketplace mirrors MarketplaceConfig but keeps plugin entries
+// undecoded so "$ref" entries can be resolved.
+type rawMarketplace struct {
+	Name    string            `json:"name"`
+	Owner   Owner             `json:"owner"`
+	Plugins []json.RawMessage `json:"plugins"`
+}
+
+// expandOutputDir expands text/template actions in the -output value so
+// each run can write to its own dated directory, e.g. ".dist/{{.Date}}".
+// .Date is YYYY-MM-DD, .Time is HHMMSS, and .Now allows any Go time
+// layout. A value without actions is returned unchanged.
+func expandOutputDir(value string, now time.Time) (string, error) {
+	if !strings.Contains(value, "{{") {
+		return value, nil
+	}
+	tmpl, err := texttemplate.New("output").Parse(value)
+	if err != nil {
+		return "", err
+	}
+	var buf strings.Builder
+	err = tmpl.Execute(&buf, struct {
+		Date string
+		Time string
+		Now  time.Time
+	}{now.Format("2006-01-02"), now.Format("150405"), now})
+	if err != nil {
+		return "", err
+	}
+	if buf.Len() == 0 {
+		return "", errors.New("expands to an empty path")
+	}
+	return buf.String(), nil
+}
+
+// readMarketplace reads and resolves marketplace.json. With lenient set,
+// malformed plugin entries are skipped and recorded in Skipped rather than
+// failing the read.
+func readMarketplace(path string, lenient bool) (*MarketplaceConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw rawMarketplace
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{absPath}
+	resolved, skipped, err := resolvePluginEntries(raw.Plugins, filepath.Dir(absPath), []string{absPath}, lenient, &files)
+	if err != nil {
+		return nil, err
+	}
+
+	var plugins []Plugin
+	var warnings []string
+	for _, plugin := range resolved {
+		err := expandPluginHome(&plugin)
+		if err == nil && plugin.SkillsFile != "" {
+			files = append(files, plugin.SkillsFile)
+		}
+		var warning string
+		if err == nil {
+			warning, err = inferPluginName(&plugin)
+		}
+		if err == nil {
+			err = mergeSkillsFile(&plugin)
+		}
+		cleanPluginPaths(&plugin)
+		if err != nil {
+			if !lenient {
+				return nil, err
+			}
+			skipped = append(skipped, err)
+			continue
+		}
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+		plugins = append(plugins, plugin)
+	}
+
+	merged, err := mergedSkillDirs(plugins)
+	if err != nil {
+		return nil, err
+	}
+	defaults, err := skillDefaultDirs(plugins)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MarketplaceConfig{Name: raw.Name, Owner: raw.Owner, Plugins: plugins, Skipped: skipped, Files: files, Warnings: warnings, MergedSkills: merged, SkillDefaults: defaults}, nil
+}
+
+// skillDefaultDirs maps the absolute directory of every skill in a plugin
+// with skillDefaults to those defaults.
+func skillDefaultDirs(plugins []Plugin) (map[string]*Frontmatter, error) {
+	defaults := make(map[string]*Frontmatter)
+	for _, plugin := range plugins {
+		if plugin.Defaults == nil {
+			continue
+		}
+		for _, skillPath := range plugin.Skills {
+			skillDir, err := filepath.Abs(filepath.Join(plugin.Source, "skills", filepath.Base(skillPath)))
+			if err != nil {
+				return nil, err
+			}
+			defaults[skillDir] = plugin.Defaults
+		}
+	}
+	return defaults, nil
+}
+
+// mergedSkillDirs resolves every plugin's merged skills to absolute
+// directories. A source may not itself be a merged skill, which keeps
+// merging a single level deep.
+func mergedSkillDirs(plugins []Plugin) (map[string][]string, error) {
+	merged := make(map[string][]string)
+	for _, plugin := range plugins {
+		for name, sources := range plugin.Merged {
+			skillDir, err := filepath.Abs(filepath.Join(plugin.Source, "skills", name))
+			if err != nil {
+				return nil, err
+			}
+			var dirs []string
+			for _, source := range sources {
+				dir, err := filepath.Abs(filepath.Join(plugin.Source, source))
+				if err != nil {
+					return nil, err
+				}
+				dirs = append(dirs, dir)
+			}
+			merged[skillDir] = dirs
+		}
+	}
+	for skillDir, dirs := range merged {
+		for _, dir := range dirs {
+			if _, ok := merged[dir]; ok {
+				return nil, fmt.Errorf("merged skill %s uses merged skill %s as a source", skillDir, dir)
+			}
+		}
+	}
+	return merged, nil
+}
+
+// skillPathsOutsideSource describes each skills entry that resolves outside
+// its plugin's source directory. Entries are relative to the source, so an
+// absolute path or one climbing out with ".." is almost always a mistake.
+func skillPathsOutsideSource(marketplace *MarketplaceConfig) []string {
+	var problems []string
+	for _, plugin := range marketplace.Plugins {
+		for _, skillPath := range plugin.Skills {
+			resolved := skillPath
+			if !filepath.IsAbs(resolved) {
+				resolved = filepath.Join(plugin.Source, skillPath)
+			}
+			if !pathWithin(filepath.Clean(plugin.Source), filepath.Clean(resolved)) {
+				problems = append(problems, fmt.Sprintf("Plugin '%s' skill entry %q is outside its source %s", plugin.Name, skillPath, plugin.Source))
+			}
+		}
+	}
+	return problems
+}
+
+// pathWithin reports whether target is dir itself or lies beneath it. The
+// comparison is lexical; symlinks are not resolved.
+func pathWithin(dir, target string) bool {
+	rel, err := filepath.Rel(dir, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// expandHome replaces a leading "~" in path with the user's home directory,
+// as a shell would. Only "~" and "~/..." are expanded; "~user" forms are
+// left as they are.
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot expand %s: %w", path, err)
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// expandPluginHome expands a leading "~" in the plugin's source, skillsFile
+// and skill paths. It runs before anything else looks at those paths, so
+// the rest of the script only ever sees expanded ones.
+func expandPluginHome(plugin *Plugin) error {
+	var err error
+	if plugin.Source, err = expandHome(plugin.Source); err != nil {
+		return fmt.Errorf("plugin %s: %w", plugin.Name, err)
+	}
+	if plugin.SkillsFile, err = expandHome(plugin.SkillsFile); err != nil {
+		return fmt.Errorf("plugin %s: %w", plugin.Name, err)
+	}
+	for i, skillPath := range plugin.Skills {
+		if plugin.Skills[i], err = expandHome(skillPath); err != nil {
+			return fmt.Errorf("plugin %s: %w", plugin.Name, err)
+		}
+	}
+	return nil
+}
+
+// cleanPluginPaths normalizes the plugin's source and skill paths with
+// filepath.Clean, so "./plugins/core/" and "plugins//core" resolve the
+// same way everywhere. An empty source is left empty for inferPluginName
+// to report.
+func cleanPluginPaths(plugin *Plugin) {
+	if plugin.Source != "" {
+		plugin.Source = filepath.Clean(plugin.Source)
+	}
+	for i, skillPath := range plugin.Skills {
+		plugin.Skills[i] = filepath.Clean(skillPath)
+	}
+}
+
+// inferPluginName fills in a missing plugin name from the last element of
+// its source path, returning a warning describing the inference. The name
+// ends up in zip and directory names, so it must be filesystem-safe.
+func inferPluginName(plugin *Plugin) (string, error) {
+	if plugin.Name != "" {
+		return "", nil
+	}
+	name := filepath.Base(filepath.Clean(plugin.Source))
+	if plugin.Source == "" || name == "." || name == ".." || name == string(filepath.Separator) ||
+		strings.ContainsAny(name, `/\:*?"<>|`) || strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return "", fmt.Errorf("plugin with source %q has no name and none can be inferred from its source", plugin.Source)
+	}
+	plugin.Name = name
+	return fmt.Sprintf("Plugin with source %s has no name; using %q", plugin.Source, name), nil
+}
+
+// watchConfig runs the script in a child process, then polls
+// marketplace.json, the files it references via $ref, and any skillsFile
+// lists, re-running whenever one of them changes. Polling keeps the script
+// dependency-free; changes are debounced so an editor's save sequence only
+// triggers one run. It returns on SIGINT.
+func watchConfig(marketplaceFile string) {
+	exe, err := os.Executable()
+	if err != nil {
+		fatal("Failed to locate executable: %v", err)
+	}
+	args := watchChildArgs()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	run := func() {
+		cmd := exec.Command(exe, args...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Run()
+	}
+
+	run()
+	files := watchedConfigFiles(marketplaceFile, nil)
+	state := statConfigFiles(files)
+	fmt.Printf("\n%sWatching %d config files for changes (Ctrl+C to stop)%s\n", colorBlue, len(files), colorReset)
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-interrupt:
+			return
+		case <-ticker.C:
+		}
+
+		current := statConfigFiles(files)
+		changed := changedConfigFiles(state, current)
+		if len(changed) == 0 {
+			continue
+		}
+
+		// Wait for the files to settle before re-running
+		for {
+			time.Sleep(300 * time.Millisecond)
+			settled := statConfigFiles(files)
+			if len(changedConfigFiles(current, settled)) == 0 {
+				break
+			}
+			current = settled
+		}
+
+		fmt.Printf("\n%s[RELOAD]%s %s changed\n", colorBlue, colorReset, strings.Join(changed, ", "))
+		run()
+		files = watchedConfigFiles(marketplaceFile, files)
+		state = statConfigFiles(files)
+	}
+}
+
+// watchedConfigFiles lists the config files to watch. If marketplace.json
+// cannot be read (for example mid-edit), the previous list is kept.
+func watchedConfigFiles(marketplaceFile string, previous []string) []string {
+	marketplace, err := readMarketplace(marketplaceFile, true)
+	if err != nil {
+		if previous != nil {
+			return previous
+		}
+		return []string{marketplaceFile}
+	}
+	return marketplace.Files
+}
+
+// configFileState records enough about a file to notice it changing.
+type configFileState struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+func statConfigFiles(files []string) map[string]configFileState {
+	state := make(map[string]configFileState, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			state[file] = configFileState{modTime: info.ModTime(), size: info.Size(), exists: true}
+		} else {
+			state[file] = configFileState{}
+		}
+	}
+	return state
+}
+
+func changedConfigFiles(before, after map[string]configFileState) []string {
+	var changed []string
+	for file, state := range after {
+		if prev := before[file]; !prev.modTime.Equal(state.modTime) || prev.size != state.size || prev.exists != state.exists {
+			changed = append(changed, file)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// mergeSkillsFile appends the skills listed in a plugin's SkillsFile to its
+// inline Skills, skipping duplicates. The file holds one skill path per
+// line; blank lines and lines starting with # are ignored. Like Source, the
+// file path is relative to the working directory.
+func mergeSkillsFile(plugin *Plugin) error {
+	if plugin.SkillsFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(plugin.SkillsFile)
+	if err != nil {
+		return fmt.Errorf("plugin %s: failed to read skillsFile: %w", plugin.Name, err)
+	}
+
+	seen := make(map[string]bool)
+	for _, skill := range plugin.Skills {
+		seen[skill] = true
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || seen[line] {
+			continue
+		}
+		seen[line] = true
+		skillPath, err := expandHome(line)
+		if err != nil {
+			return fmt.Errorf("plugin %s: %w", plugin.Name, err)
+		}
+		plugin.Skills = append(plugin.Skills, skillPath)
+	}
+	return nil
+}
+
+// readSkillSelectors reads the -from-stdin work list: one plugin/skill
+// selector per line, with blank lines and # comments ignored.
+func readSkillSelectors(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var selectors []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		selectors = append(selectors, line)
+	}
+	return selectors, nil
+}
+
+// selectSkills narrows the marketplace to the skills named by selectors
+// and returns a warning for each selector that is malformed or matches no
+// skill. Plugins left without skills are dropped.
+func selectSkills(marketplace *MarketplaceConfig, selectors []string) []string {
+	var warnings []string
+	wanted := make(map[string]bool)
+	for _, selector := range selectors {
+		pluginName, skillName, ok := strings.Cut(selector, "/")
+		if !ok || pluginName == "" || skillName == "" || strings.Contains(skillName, "/") {
+			warnings = append(warnings, fmt.Sprintf("Skipped invalid selector %q (expected plugin/skill)", selector))
+			continue
+		}
+		wanted[selector] = true
+	}
+
+	found := make(map[string]bool)
+	var plugins []Plugin
+	for _, plugin := range marketplace.Plugins {
+		var skills []string
+		for _, skillPath := range plugin.Skills {
+			key := plugin.Name + "/" + filepath.Base(skillPath)
+			if wanted[key] {
+				skills = append(skills, skillPath)
+				found[key] = true
+			}
+		}
+		if len(skills) > 0 {
+			plugin.Skills = skills
+			plugins = append(plugins, plugin)
+		}
+	}
+	marketplace.Plugins = plugins
+
+	for _, selector := range selectors {
+		if wanted[selector] && !found[selector] {
+			warnings = append(warnings, fmt.Sprintf("Skipped selector %q: no such skill in marketplace.json", selector))
+			found[selector] = true
+		}
+	}
+	return warnings
+}
+
+// readExcludeSkillsFile reads an -exclude-skills-file: one skill name, or
+// plugin/skill key, per line, with blank lines and # comments ignored.
+func readExcludeSkillsFile(path string) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	excluded := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		excluded[line] = true
+	}
+	return excluded, nil
+}
+
+// skillExcluded reports whether -exclude-skills-file names the skill,
+// either on its own or as plugin/skill.
+func skillExcluded(pluginName, skillName string, opts *PackageOptions) bool {
+	return opts.ExcludeSkills[skillName] || opts.ExcludeSkills[pluginName+"/"+skillName]
+}
+
+// unknownExcludedSkills returns the entries of an exclusion list that match
+// no skill in the marketplace, sorted.
+func unknownExcludedSkills(excluded map[string]bool, marketplace *MarketplaceConfig) []string {
+	known := make(map[string]bool)
+	for _, plugin := range marketplace.Plugins {
+		for _, skillPath := range plugin.Skills {
+			skillName := filepath.Base(skillPath)
+			known[skillName] = true
+			known[plugin.Name+"/"+skillName] = true
+		}
+	}
+	var unknown []string
+	for name := range excluded {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// resolvePluginEntries decodes plugin entries, replacing any
+// {"$ref": "<file>"} entry with the plugin (or array of plugins) defined in
+// that file. Refs are resolved relative to the file containing them and may
+// be nested; chain holds the files currently being resolved so cycles are
+// reported instead of recursing forever. When lenient is set, entries that
+// fail to decode are dropped and their errors returned instead of failing
+// the whole read. Every $ref file read is appended to files.
+func resolvePluginEntries(entries []json.RawMessage, baseDir string, chain []string, lenient bool, files *[]string) ([]Plugin, []error, error) {
+	var plugins []Plugin
+	var skipped []error
+	for i, entry := range entries {
+		resolved, resolvedSkipped, err := resolvePluginEntry(entry, baseDir, chain, lenient, files)
+		if err != nil {
+			err = fmt.Errorf("plugin entry %d: %w", i, err)
+			if !lenient {
+				return nil, nil, err
+			}
+			skipped = append(skipped, err)
+			continue
+		}
+		plugins = append(plugins, resolved...)
+		skipped = append(skipped, resolvedSkipped...)
+	}
+
+	return plugins, skipped, nil
+}
+
+// resolvePluginEntry decodes a single plugin entry, following it if it is
+// a $ref.
+func resolvePluginEntry(entry json.RawMessage, baseDir string, chain []string, lenient bool, files *[]string) ([]Plugin, []error, error) {
+	var ref struct {
+		Ref string `json:"$ref"`
+	}
+	if err := json.Unmarshal(entry, &ref); err != nil {
+		return nil, nil, err
+	}
+
+	if ref.Ref == "" {
+		var plugin Plugin
+		if err := json.Unmarshal(entry, &plugin); err != nil {
+			return nil, nil, err
+		}
+		return []Plugin{plugin}, nil, nil
+	}
+
+	refPath := ref.Ref
+	if !filepath.IsAbs(refPath) {
+		refPath = filepath.Join(baseDir, refPath)
+	}
+	for _, visiting := range chain {
+		if visiting == refPath {
+			return nil, nil, fmt.Errorf("cyclic $ref: %s -> %s", strings.Join(chain, " -> "), refPath)
+		}
+	}
+
+	*files = append(*files, refPath)
+	data, err := os.ReadFile(refPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read $ref %s: %w", ref.Ref, err)
+	}
+
+	// A referenced file holds either a single plugin or an array of them
+	var children []json.RawMessage
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &children); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse $ref %s: %w", ref.Ref, err)
+		}
+	} else {
+		children = []json.RawMessage{trimmed}
+	}
+
+	resolved, skipped, err := resolvePluginEntries(children, filepath.Dir(refPath), append(chain[:len(chain):len(chain)], refPath), lenient, files)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", ref.Ref, err)
+	}
+	for i, skipErr := range skipped {
+		skipped[i] = fmt.Errorf("%s: %w", ref.Ref, skipErr)
+	}
+	return resolved, skipped, nil
+}
+
+func createSkillZips(ctx context.Context, marketplace *MarketplaceConfig, opts *PackageOptions, stats *PackageStats) error {
+	// Process each plugin
+	for _, plugin := range marketplace.Plugins {
+		if err := packagePluginSkills(ctx, plugin, opts, stats); err != nil {
+			fmt.Fprintf(stdout, "%s[ERROR]%s Failed to package plugin '%s': %v\n", colorRed, colorReset, plugin.Name, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validatePlugin checks each of a plugin's skills without packaging them.
+// It only returns an error when the context is cancelled.
+func validatePlugin(ctx context.Context, plugin Plugin, opts *PackageOptions, stats *PackageStats) error {
+	if len(plugin.Skills) == 0 {
+		if opts.Verbose {
+			fmt.Fprintf(stdout, "%s[SKIP]%s Plugin '%s' has no skills\n", colorYellow, colorReset, plugin.Name)
+		}
+		return nil
+	}
+
+	fmt.Fprintf(stdout, "\n%s=== Validating plugin: %s ===%s\n", colorBlue, plugin.Name, colorReset)
+
+	if plugin.Build != "" && !opts.SkipBuild {
+		fmt.Fprintf(stdout, "%s[DRY RUN]%s Would run build: %s\n", colorYellow, colorReset, plugin.Build)
+	}
+
+	for _, skillPath := range plugin.Skills {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		skillName := filepath.Base(skillPath)
+		if skillExcluded(plugin.Name, skillName, opts) {
+			fmt.Fprintf(stdout, "%s[SKIP]%s %s excluded\n", colorYellow, colorReset, skillName)
+			recordSkippedSkill(plugin.Name, skillName, "excluded", opts, stats)
+			stats.SkillsExcluded++
+			continue
+		}
+		if skillUnchanged(filepath.Join(plugin.Source, "skills", skillName), opts) {
+			recordSkippedSkill(plugin.Name, skillName, "unchanged since "+opts.SinceGit, opts, stats)
+			stats.SkillsUnchanged++
+			continue
+		}
+		opts.Events.Emit(Event{Type: "skill_start", Plugin: plugin.Name, Skill: skillName})
+
+		start := time.Now()
+		err := validateSkill(plugin, skillPath, opts)
+		recordSkillResult(plugin.Name, skillName, 0, time.Since(start), err, opts, stats)
+		if err != nil {
+			fmt.Fprintf(stdout, "%s[ERROR]%s %v\n", colorRed, colorReset, err)
+			printErrorChain(err, opts)
+		}
+	}
+
+	return nil
+}
+
+func validateSkill(plugin Plugin, skillPath string, opts *PackageOptions) error {
+	// Extract skill name from the path (e.g., "./skills/commit-messages" -> "commit-messages")
+	skillName := filepath.Base(skillPath)
+
+	// Construct the actual path by combining plugin source with skills directory
+	actualSkillPath := filepath.Join(plugin.Source, "skills", skillName)
+
+	packagedName := packagedSkillName(plugin.Name, skillName, opts)
+
+	srcDir, err := filepath.Abs(actualSkillPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", actualSkillPath, err)
+	}
+
+	source, err := openSkillSource(srcDir, opts)
+	if err != nil {
+		return err
+	}
+
+	// A real run packages these files as they are, so a dry run only warns
+	if err := checkSkillMarkdown(source, skillName, false, opts); err != nil {
+		return err
+	}
+
+	if !opts.SkipScripts {
+		frontmatter, err := readFrontmatter(source)
+		if err != nil {
+			return err
+		}
+		if command := frontmatter.String("prepackage"); command != "" {
+			fmt.Fprintf(stdout, "%s[DRY RUN]%s Would run prepackage for %s: %s\n", colorYellow, colorReset, skillName, command)
+		}
+	}
+
+	if err := checkChangelog(source, opts); err != nil {
+		return err
+	}
+
+	if err := checkFrontmatterSchema(source, opts); err != nil {
+		return err
+	}
+
+	filter, err := skillFileFilter(source)
+	if err != nil {
+		return err
+	}
+
+	if err := checkLock(plugin.Name, skillName, source, filter, opts); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "%s[DRY RUN]%s Would package: %s\n", colorYellow, colorReset, packagedName)
+	return nil
+}
+
+// Categories of problems reported by -check
+const (
+	checkConfig      = "config"
+	checkPaths       = "paths"
+	checkNames       = "names"
+	checkUnused      = "unused"
+	checkStructure   = "structure"
+	checkFrontmatter = "frontmatter"
+	checkChangelogs  = "changelog"
+	checkLockfile    = "lock"
+	checkData        = "data"
+	checkPermissions = "permissions"
+	checkSize        = "size"
+)
+
+// CheckReport aggregates every problem found by -check.
+type CheckReport struct {
+	Passed bool `json:"passed"`
+	Skills int  `json:"skills"`
+	// Counts holds the number of problems in each category that ran,
+	// including those with none.
+	Counts   map[string]int `json:"counts"`
+	Problems []CheckProblem `json:"problems"`
+}
+
+// CheckProblem is a single -check finding.
+type CheckProblem struct {
+	Category string `json:"category"`
+	Plugin   string `json:"plugin,omitempty"`
+	Skill    string `json:"skill,omitempty"`
+	Message  string `json:"message"`
+}
+
+func (r *CheckReport) add(category, plugin, skill, message string) {
+	r.Counts[category]++
+	r.Problems = append(r.Problems, CheckProblem{Category: category, Plugin: plugin, Skill: skill, Message: message})
+}
+
+// runChecks runs every validator against the marketplace without writing
+// anything: the config and naming checks, unused skill directories, and
+// for each skill its structure, SKILL.md, changelog and lockfile checks
+// plus -validate-data, -audit-perms and -max-file-size on every file that
+// would be packaged. Plugin build commands are not run.
+func runChecks(marketplace *MarketplaceConfig, opts *PackageOptions) *CheckReport {
+	report := &CheckReport{Counts: make(map[string]int), Problems: []CheckProblem{}}
+	for _, category := range []string{checkConfig, checkPaths, checkNames, checkUnused, checkStructure, checkFrontmatter, checkData, checkPermissions, checkSize} {
+		report.Counts[category] = 0
+	}
+	if opts.RequireChangelog {
+		report.Counts[checkChangelogs] = 0
+	}
+	if opts.Lock != nil {
+		report.Counts[checkLockfile] = 0
+	}
+
+	for _, skipErr := range marketplace.Skipped {
+		report.add(checkConfig, "", "", skipErr.Error())
+	}
+	for _, problem := range skillPathsOutsideSource(marketplace) {
+		report.add(checkPaths, "", "", problem)
+	}
+
+	names := *opts
+	if err := resolveNameCollisions(marketplace, &names); err != nil {
+		report.add(checkNames, "", "", err.Error())
+	}
+	sanitized := func(pluginName, skillName string) string {
+		return packagedSkillName(pluginName, skillName, &names)
+	}
+	if err := checkSanitizedNames(marketplace, sanitized, sanitized, false); err != nil {
+		report.add(checkNames, "", "", err.Error())
+	}
+	zipPath := func(pluginName, skillName string) string {
+		return filepath.Join(opts.OutputDir, sanitized(pluginName, skillName)+".zip")
+	}
+	caseClashes, err := checkOutputPaths(marketplace, zipPath)
+	if err != nil {
+		report.add(checkNames, "", "", err.Error())
+	}
+	if opts.Strict {
+		for _, clash := range caseClashes {
+			report.add(checkNames, "", "", clash)
+		}
+	}
+
+	unused, err := findUnusedSkills(marketplace)
+	if err != nil {
+		report.add(checkUnused, "", "", err.Error())
+	}
+	for _, dir := range unused {
+		report.add(checkUnused, "", "", dir+" is not referenced by marketplace.json")
+	}
+
+	for _, plugin := range marketplace.Plugins {
+		for _, skillPath := range plugin.Skills {
+			report.Skills++
+			checkSkill(plugin, filepath.Base(skillPath), opts, report)
+		}
+	}
+
+	report.Passed = len(report.Problems) == 0
+	return report
+}
+
+// checkSkill adds the problems of a single skill to report.
+func checkSkill(plugin Plugin, skillName string, opts *PackageOptions, report *CheckReport) {
+	add := func(category string, err error) {
+		report.add(category, plugin.Name, skillName, err.Error())
+	}
+
+	srcDir, err := filepath.Abs(filepath.Join(plugin.Source, "skills", skillName))
+	if err != nil {
+		add(checkStructure, err)
+		return
+	}
+	source, err := openSkillSource(srcDir, opts)
+	if err != nil {
+		add(checkStructure, err)
+		return
+	}
+	if err := checkSkillMarkdown(source, skillName, true, opts); err != nil {
+		add(checkFrontmatter, err)
+	}
+	if err := checkChangelog(source, opts); err != nil {
+		add(checkChangelogs, err)
+	}
+	if err := checkFrontmatterSchema(source, opts); err != nil {
+		add(checkFrontmatter, err)
+	}
+	filter, err := skillFileFilter(source)
+	if err != nil {
+		add(checkFrontmatter, err)
+		return
+	}
+	if err := checkLock(plugin.Name, skillName, source, filter, opts); err != nil {
+		add(checkLockfile, err)
+	}
+
+	err = source.Walk(func(file SourceFile) error {
+		if !filter.Includes(file.RelPath) || isExcluded(file.RelPath, opts) {
+			return nil
+		}
+		if err := checkDataFile(file); err != nil {
+			add(checkData, fmt.Errorf("invalid %s: %w", file.RelPath, err))
+		}
+		if problems := suspiciousModeBits(file.Mode); len(problems) > 0 {
+			add(checkPermissions, fmt.Errorf("%s is %s", file.RelPath, strings.Join(problems, " and ")))
+		}
+		if opts.MaxFileSize > 0 && file.Size > opts.MaxFileSize {
+			add(checkSize, fmt.Errorf("%s is %d bytes, over the %d byte limit", file.RelPath, file.Size, opts.MaxFileSize))
+		}
+		return nil
+	})
+	if err != nil {
+		add(checkStructure, err)
+	}
+}
+
+// printCheckReport prints each -check problem, then the count for every
+// category and the overall result.
+func printCheckReport(report *CheckReport) {
+	for _, problem := range report.Problems {
+		where := ""
+		if problem.Skill != "" {
+			where = problem.Plugin + "/" + problem.Skill + ": "
+		}
+		fmt.Fprintf(stdout, "%s[ERROR]%s %s: %s%s\n", colorRed, colorReset, problem.Category, where, problem.Message)
+	}
+
+	categories := make([]string, 0, len(report.Counts))
+	for category := range report.Counts {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	fmt.Fprintf(stdout, "\n%s%-12s  %8s%s\n", colorBlue, "Check", "Problems", colorReset)
+	for _, category := range categories {
+		row := fmt.Sprintf("%-12s  %8d", category, report.Counts[category])
+		if report.Counts[category] > 0 {
+			row = colorRed + row + colorReset
+		}
+		fmt.Fprintln(stdout, row)
+	}
+
+	if report.Passed {
+		fmt.Fprintf(stdout, "\n%s✓ All checks passed for %d skills%s\n", colorGreen, report.Skills, colorReset)
+	} else {
+		fmt.Fprintf(stdout, "\n%s✗ %d problems found in %d skills%s\n", colorRed, len(report.Problems), report.Skills, colorReset)
+	}
+}
+
+// checkSkillMarkdown looks for SKILL.md problems that can be corrected
+// mechanically. Without -fix they are returned as an error when fail is
+// set, as for -check, and otherwise printed as warnings; a missing final
+// newline is always only a warning. With -fix the file is rewritten in
+// place and each correction is reported.
+func checkSkillMarkdown(source SkillSource, skillName string, fail bool, opts *PackageOptions) error {
+	data, err := source.ReadFile("SKILL.md")
+	if err != nil {
+		return fmt.Errorf("failed to read SKILL.md in %s: %w", source.Location(), err)
+	}
+
+	fixed, fixes := fixSkillMarkdown(data, skillName)
+	if len(fixes) == 0 {
+		return nil
+	}
+
+	path := filepath.Join(source.Location(), "SKILL.md")
+	if !opts.Fix {
+		var problems []string
+		for _, fix := range fixes {
+			if fix.Warning || !fail {
+				fmt.Fprintf(stdout, "%s[WARN]%s %s: %s (run with -fix to correct)\n", colorYellow, colorReset, path, fix.Problem)
+				continue
+			}
+			problems = append(problems, fix.Problem)
+		}
+		if len(problems) == 0 {
+			return nil
+		}
+		return fmt.Errorf("%s: %s (run with -fix to correct)", path, strings.Join(problems, "; "))
+	}
+	if _, ok := source.(dirSource); !ok {
+		return fmt.Errorf("cannot fix SKILL.md in %s: only working tree files can be rewritten", source.Location())
+	}
+
+	if !opts.Force {
+		status, err := runGit(source.Location(), "status", "--porcelain", "--", "SKILL.md")
+		if err != nil {
+			return fmt.Errorf("refusing to fix %s: cannot check for uncommitted changes (use -force to overwrite): %w", path, err)
+		}
+		if len(bytes.TrimSpace(status)) > 0 {
+			return fmt.Errorf("refusing to fix %s: it has uncommitted changes (use -force to overwrite)", path)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if opts.Verbose {
+		printLineDiff(path, data, fixed)
+	}
+	if err := os.WriteFile(path, fixed, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	delete(frontmatterCache, source.Location())
+
+	for _, fix := range fixes {
+		fmt.Fprintf(stdout, "%s[FIXED]%s %s: %s\n", colorGreen, colorReset, path, fix.Change)
+	}
+	return nil
+}
+
+// checkChangelog enforces -require-changelog: the skill must have a
+// CHANGELOG.md and, when its frontmatter declares a version, the first
+// "## " heading of the changelog must name that version.
+func checkChangelog(source SkillSource, opts *PackageOptions) error {
+	if !opts.RequireChangelog {
+		return nil
+	}
+
+	found, err := source.Exists("CHANGELOG.md")
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("CHANGELOG.md missing in %s", source.Location())
+	}
+
+	frontmatter, err := readFrontmatter(source)
+	if err != nil {
+		return err
+	}
+	version := strings.TrimPrefix(frontmatter.String("version"), "v")
+	if version == "" {
+		return nil
+	}
+
+	data, err := source.ReadFile("CHANGELOG.md")
+	if err != nil {
+		return fmt.Errorf("failed to read CHANGELOG.md: %w", err)
+	}
+	latest := latestChangelogVersion(data)
+	if latest == "" {
+		return fmt.Errorf("CHANGELOG.md in %s has no entries, expected one for version %s", source.Location(), version)
+	}
+	if latest != version {
+		return fmt.Errorf("CHANGELOG.md in %s starts at version %s, but SKILL.md declares %s", source.Location(), latest, version)
+	}
+	return nil
+}
+
+// FrontmatterSchema is the subset of JSON Schema supported by
+// -frontmatter-schema. It describes the frontmatter of SKILL.md as an
+// object whose properties are scalars or lists of scalars.
+type FrontmatterSchema struct {
+	SchemaURI   string `json:"$schema,omitempty"`
+	ID          string `json:"$id,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Type may only be "object".
+	Type       string                     `json:"type,omitempty"`
+	Required   []string                   `json:"required,omitempty"`
+	Properties map[string]*SchemaProperty `json:"properties,omitempty"`
+	// AdditionalProperties set to false rejects keys not in Properties.
+	AdditionalProperties *bool `json:"additionalProperties,omitempty"`
+}
+
+// SchemaProperty constrains one frontmatter key, or with Items each
+// element of a list.
+type SchemaProperty struct {
+	Description string `json:"description,omitempty"`
+	// Type is one of string, array, boolean, integer or number; empty
+	// accepts any value.
+	Type      string          `json:"type,omitempty"`
+	Enum      []string        `json:"enum,omitempty"`
+	Pattern   string          `json:"pattern,omitempty"`
+	MinLength *int            `json:"minLength,omitempty"`
+	MaxLength *int            `json:"maxLength,omitempty"`
+	MinItems  *int            `json:"minItems,omitempty"`
+	MaxItems  *int            `json:"maxItems,omitempty"`
+	Items     *SchemaProperty `json:"items,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// readFrontmatterSchema loads a -frontmatter-schema file. Keywords outside
+// the supported subset are rejected rather than ignored, so a schema never
+// appears to enforce a rule it does not.
+func readFrontmatterSchema(path string) (*FrontmatterSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	schema := &FrontmatterSchema{}
+	if err := decoder.Decode(schema); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if schema.Type != "" && schema.Type != "object" {
+		return nil, fmt.Errorf("%s: type must be \"object\", got %q", path, schema.Type)
+	}
+	for key, property := range schema.Properties {
+		if err := property.compile(false); err != nil {
+			return nil, fmt.Errorf("%s: property %q: %w", path, key, err)
+		}
+	}
+	return schema, nil
+}
+
+func (p *SchemaProperty) compile(item bool) error {
+	switch p.Type {
+	case "", "string", "boolean", "integer", "number":
+	case "array":
+		if item {
+			return fmt.Errorf("items cannot be arrays")
+		}
+	default:
+		return fmt.Errorf("unsupported type %q", p.Type)
+	}
+	if p.Items != nil {
+		if item || (p.Type != "" && p.Type != "array") {
+			return fmt.Errorf("items only applies to arrays")
+		}
+		if err := p.Items.compile(true); err != nil {
+			return fmt.Errorf("items: %w", err)
+		}
+	}
+	if p.Pattern != "" {
+		pattern, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		p.pattern = pattern
+	}
+	return nil
+}
+
+// checkFrontmatterSchema validates a skill's frontmatter against
+// -frontmatter-schema, reporting every violated constraint.
+func checkFrontmatterSchema(source SkillSource, opts *PackageOptions) error {
+	schema := opts.FrontmatterSchema
+	if schema == nil {
+		return nil
+	}
+	frontmatter, err := readFrontmatter(source)
+	if err != nil {
+		return err
+	}
+
+	var violations []string
+	for _, key := range schema.Required {
+		if !frontmatter.Has(key) {
+			violations = append(violations, key+": is required")
+		}
+	}
+
+	var keys []string
+	for key := range frontmatter.scalars {
+		keys = append(keys, key)
+	}
+	for key := range frontmatter.lists {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		property, ok := schema.Properties[key]
+		if !ok {
+			if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+				violations = append(violations, key+": is not allowed by the schema")
+			}
+			continue
+		}
+		list, isList := frontmatter.lists[key]
+		for _, problem := range property.validate(frontmatter.scalars[key], list, isList) {
+			violations = append(violations, key+": "+problem)
+		}
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("%s/SKILL.md does not match the frontmatter schema: %s", source.Location(), strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+// validate checks a frontmatter value, either the scalar value or, when
+// isList is set, the list, returning a description of each violation.
+func (p *SchemaProperty) validate(value string, list []string, isList bool) []string {
+	if p.Type == "array" || (p.Type == "" && isList) {
+		if !isList {
+			return []string{"must be a list"}
+		}
+		var problems []string
+		if p.MinItems != nil && len(list) < *p.MinItems {
+			problems = append(problems, fmt.Sprintf("must have at least %d items", *p.MinItems))
+		}
+		if p.MaxItems != nil && len(list) > *p.MaxItems {
+			problems = append(problems, fmt.Sprintf("must have at most %d items", *p.MaxItems))
+		}
+		if p.Items != nil {
+			for i, item := range list {
+				for _, problem := range p.Items.validate(item, nil, false) {
+					problems = append(problems, fmt.Sprintf("item %d %s", i+1, problem))
+				}
+			}
+		}
+		return problems
+	}
+	if isList {
+		return []string{"must be a single value, not a list"}
+	}
+
+	switch p.Type {
+	case "boolean":
+		if value != "true" && value != "false" {
+			return []string{fmt.Sprintf("%q is not a boolean", value)}
+		}
+	case "integer":
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return []string{fmt.Sprintf("%q is not an integer", value)}
+		}
+	case "number":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return []string{fmt.Sprintf("%q is not a number", value)}
+		}
+	}
+
+	var problems []string
+	length := utf8.RuneCountInString(value)
+	if p.MinLength != nil && length < *p.MinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters", *p.MinLength))
+	}
+	if p.MaxLength != nil && length > *p.MaxLength {
+		problems = append(problems, fmt.Sprintf("must be at most %d characters", *p.MaxLength))
+	}
+	if p.pattern != nil && !p.pattern.MatchString(value) {
+		problems = append(problems, fmt.Sprintf("%q does not match pattern %q", value, p.Pattern))
+	}
+	if len(p.Enum) > 0 {
+		allowed := false
+		for _, option := range p.Enum {
+			allowed = allowed || value == option
+		}
+		if !allowed {
+			problems = append(problems, fmt.Sprintf("%q is not one of %s", value, strings.Join(p.Enum, ", ")))
+		}
+	}
+	return problems
+}
+
+// latestChangelogVersion returns the version named by the first "## "
+// heading of a changelog, accepting "## 1.2.0", "## v1.2.0", and
+// "## [1.2.0] - 2024-01-01". A "## [Unreleased]" section, as kept by
+// Keep a Changelog, is passed over.
+func latestChangelogVersion(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		heading, ok := strings.CutPrefix(strings.TrimSpace(line), "## ")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(heading)
+		if len(fields) == 0 {
+			continue
+		}
+		version := strings.Trim(fields[0], "[]")
+		if strings.EqualFold(version, "unreleased") {
+			continue
+		}
+		return strings.TrimPrefix(version, "v")
+	}
+	return ""
+}
+
+// markdownFix describes one SKILL.md issue found by fixSkillMarkdown: the
+// problem as reported without -fix and the change made with it.
+type markdownFix struct {
+	Problem string
+	Change  string
+	Warning bool
+}
+
+// fixSkillMarkdown returns data with its fixable issues corrected, along
+// with a description of each one. Only the frontmatter indentation, the
+// frontmatter name, and the final newline are ever touched.
+func fixSkillMarkdown(data []byte, skillName string) ([]byte, []markdownFix) {
+	var fixes []markdownFix
+	lines := strings.Split(string(data), "\n")
+
+	end := -1
+	if strings.TrimSpace(lines[0]) == "---" {
+		for i := 1; i < len(lines); i++ {
+			if strings.TrimSpace(lines[i]) == "---" {
+				end = i
+				break
+			}
+		}
+	}
+
+	tabs := 0
+	for i := 1; i < end; i++ {
+		line := lines[i]
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		if strings.Contains(line[:indent], "\t") {
+			lines[i] = strings.ReplaceAll(line[:indent], "\t", "  ") + line[indent:]
+			tabs++
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || key != "name" {
+			continue
+		}
+		crlf := strings.HasSuffix(value, "\r")
+		if name := unquoteYAML(strings.TrimSpace(value)); name != skillName {
+			lines[i] = "name: " + skillName
+			if crlf {
+				lines[i] += "\r"
+			}
+			fixes = append(fixes, markdownFix{
+				Problem: fmt.Sprintf("frontmatter name %q does not match directory %q", name, skillName),
+				Change:  fmt.Sprintf("renamed frontmatter name %q to %q", name, skillName),
+			})
+		}
+	}
+	if tabs > 0 {
+		fixes = append(fixes, markdownFix{
+			Problem: fmt.Sprintf("tab indentation on %d frontmatter line(s)", tabs),
+			Change:  fmt.Sprintf("replaced tab indentation on %d frontmatter line(s)", tabs),
+		})
+	}
+
+	fixed := strings.Join(lines, "\n")
+	if fixed != "" && !strings.HasSuffix(fixed, "\n") {
+		fixed += "\n"
+		fixes = append(fixes, markdownFix{
+			Problem: "missing trailing newline",
+			Change:  "added missing trailing newline",
+			Warning: true,
+		})
+	}
+
+	return []byte(fixed), fixes
+}
+
+// printLineDiff prints the lines that differ between before and after.
+// The fixes never add or remove lines, so a line-by-line comparison is
+// enough.
+func printLineDiff(path string, before, after []byte) {
+	fmt.Fprintf(stdout, "--- %s\n+++ %s (fixed)\n", path, path)
+	oldLines := strings.Split(string(before), "\n")
+	newLines := strings.Split(string(after), "\n")
+	for i := range oldLines {
+		if i < len(newLines) && oldLines[i] == newLines[i] {
+			continue
+		}
+		fmt.Fprintf(stdout, "@@ line %d @@\n", i+1)
+		fmt.Fprintf(stdout, "%s-%s%s\n", colorRed, oldLines[i], colorReset)
+		fmt.Fprintf(stdout, "%s+%s%s\n", colorGreen, newLines[i], colorReset)
+	}
+	if len(newLines) > len(oldLines) {
+		fmt.Fprintf(stdout, "%s+\\ newline at end of file%s\n", colorGreen, colorReset)
+	}
+}
+
+func packagePluginSkills(ctx context.Context, plugin Plugin, opts *PackageOptions, stats *PackageStats) error {
+	if len(plugin.Skills) == 0 {
+		if opts.Verbose {
+			fmt.Fprintf(stdout, "%s[SKIP]%s Plugin '%s' has no skills\n", colorYellow, colorReset, plugin.Name)
+		}
+		return nil
+	}
+
+	fmt.Fprintf(stdout, "\n%s=== Packaging plugin: %s ===%s\n", colorBlue, plugin.Name, colorReset)
+
+	// Run the plugin's build step; if it fails none of its skills can be
+	// trusted, so they are all recorded as failed
+	if plugin.Build != "" && !opts.SkipBuild {
+		fmt.Fprintf(stdout, "%s[BUILD]%s %s\n", colorBlue, colorReset, plugin.Build)
+		if err := runPluginBuild(ctx, plugin, opts.Verbose); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			fmt.Fprintf(stdout, "%s[ERROR]%s Plugin '%s' %v\n", colorRed, colorReset, plugin.Name, err)
+			printErrorChain(err, opts)
+			for _, skillPath := range plugin.Skills {
+				recordSkillResult(plugin.Name, filepath.Base(skillPath), 0, 0, err, opts, stats)
+			}
+			return nil
+		}
+	}
+
+	for _, skillPath := range plugin.Skills {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Extract skill name from the path (e.g., "./skills/commit-messages" -> "commit-messages")
+		skillName := filepath.Base(skillPath)
+
+		// Construct the actual path by combining plugin source with skills directory
+		actualSkillPath := filepath.Join(plugin.Source, "skills", skillName)
+
+		if skillExcluded(plugin.Name, skillName, opts) {
+			fmt.Fprintf(stdout, "%s[SKIP]%s %s excluded\n", colorYellow, colorReset, skillName)
+			recordSkippedSkill(plugin.Name, skillName, "excluded", opts, stats)
+			stats.SkillsExcluded++
+			continue
+		}
+
+		if skillUnchanged(actualSkillPath, opts) {
+			if opts.Verbose {
+				fmt.Fprintf(stdout, "%s[SKIP]%s %s (unchanged since %s)\n", colorYellow, colorReset, skillName, opts.SinceGit)
+			}
+			recordSkippedSkill(plugin.Name, skillName, "unchanged since "+opts.SinceGit, opts, stats)
+			stats.SkillsUnchanged++
+			continue
+		}
+
+		if opts.Resume {
+			packagedName := packagedSkillName(plugin.Name, skillName, opts)
+			zipPath := filepath.Join(opts.OutputDir, packagedName+".zip")
+			if fileCount, ok := existingZipIsValid(zipPath, zipEntryRoot(packagedName, opts)); ok {
+				fmt.Fprintf(stdout, "%s[RESUMED]%s %s (%d files, already packaged)\n", colorGreen, colorReset, filepath.Base(zipPath), fileCount)
+				recordSkippedSkill(plugin.Name, skillName, "already packaged", opts, stats)
+				stats.SkillsResumed++
+				continue
+			}
+		}
+
+		opts.Events.Emit(Event{Type: "skill_start", Plugin: plugin.Name, Skill: skillName})
+
+		start := time.Now()
+		fileCount, err := packageSkillToZip(ctx, plugin.Name, actualSkillPath, opts, stats)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		recordSkillResult(plugin.Name, skillName, fileCount, time.Since(start), err, opts, stats)
+		if err != nil {
+			fmt.Fprintf(stdout, "%s[ERROR]%s Failed to package %s: %v\n", colorRed, colorReset, skillPath, err)
+			printErrorChain(err, opts)
+		}
+	}
+
+	return nil
+}
+
+// errorChain describes each error in err's Unwrap chain on its own line:
+// its concrete type and the part of its message not repeated by the error
+// it wraps.
+func errorChain(err error) []string {
+	var lines []string
+	for err != nil {
+		next := errors.Unwrap(err)
+		msg := err.Error()
+		if next != nil {
+			msg = strings.TrimSuffix(strings.TrimSuffix(msg, next.Error()), ": ")
+		}
+		lines = append(lines, fmt.Sprintf("%T: %s", err, msg))
+		err = next
+	}
+	return lines
+}
+
+// printErrorChain prints err's chain beneath its [ERROR] line under
+// -verbose-errors, indenting each wrapped error one step further.
+func printErrorChain(err error, opts *PackageOptions) {
+	if !opts.VerboseErrors {
+		return
+	}
+	for i, line := range errorChain(err) {
+		fmt.Fprintf(stdout, "    %s%s\n", strings.Repeat("  ", i), line)
+	}
+}
+
+// recordSkillResult updates the run statistics and emits the matching
+// completion event for a processed skill.
+func recordSkillResult(pluginName, skillName string, fileCount int, duration time.Duration, err error, opts *PackageOptions, stats *PackageStats) {
+	result := SkillResult{
+		Plugin:     pluginName,
+		Skill:      skillName,
+		Status:     statusSuccess,
+		Files:      fileCount,
+		Duration:   duration,
+		DurationMs: durationMs(duration),
+	}
+	if err != nil {
+		result.Status = statusFailed
+		result.Error = err.Error()
+	}
+	stats.Results = append(stats.Results, result)
+	opts.Progress.Step(duration)
+	pluginStats := stats.plugin(pluginName)
+
+	if err != nil {
+		stats.SkillsFailed++
+		pluginStats.SkillsFailed++
+		opts.Events.Emit(Event{
+			Type:       "skill_failed",
+			Plugin:     pluginName,
+			Skill:      skillName,
+			DurationMs: durationMs(duration),
+			Error:      err.Error(),
+		})
+		return
+	}
+
+	stats.SkillsPackaged++
+	stats.FilesAdded += fileCount
+	pluginStats.SkillsPackaged++
+	pluginStats.FilesAdded += fileCount
+	if opts.WarnFileCount > 0 && fileCount > opts.WarnFileCount {
+		fmt.Fprintf(stdout, "%s[WARN]%s %s has %d files, more than -warn-file-count %d\n", colorYellow, colorReset, skillName, fileCount, opts.WarnFileCount)
+		stats.TooManyFiles = append(stats.TooManyFiles, result)
+	}
+	opts.Events.Emit(Event{
+		Type:       "skill_done",
+		Plugin:     pluginName,
+		Skill:      skillName,
+		Files:      fileCount,
+		DurationMs: durationMs(duration),
+	})
+}
+
+// runPluginBuild runs a plugin's build command in its Source directory.
+// Output is streamed under -verbose and otherwise included in the error
+// when the build fails.
+func runPluginBuild(ctx context.Context, plugin Plugin, verbose bool) error {
+	cmd := shellCommand(ctx, plugin.Build)
+	cmd.Dir = plugin.Source
+
+	var output bytes.Buffer
+	if verbose {
+		cmd.Stdout = stdout
+		cmd.Stderr = os.Stderr
+	} else {
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+	}
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(output.String()); msg != "" {
+			return fmt.Errorf("build %q failed: %w\n%s", plugin.Build, err, msg)
+		}
+		return fmt.Errorf("build %q failed: %w", plugin.Build, err)
+	}
+	return nil
+}
+
+// runPrepackage runs the "prepackage" command from a skill's frontmatter
+// in its source directory, with SKILL_DIR and SKILL_NAME set, before any
+// of its files are read. Output is streamed under -verbose and otherwise
+// included in the error when the command fails.
+func runPrepackage(ctx context.Context, source SkillSource, skillName string, opts *PackageOptions) error {
+	if opts.SkipScripts {
+		return nil
+	}
+	frontmatter, err := readFrontmatter(source)
+	if err != nil {
+		return err
+	}
+	command := frontmatter.String("prepackage")
+	if command == "" {
+		return nil
+	}
+	// Scripts change the working tree, which a git ref or zip source
+	// would not see
+	if _, ok := source.(dirSource); !ok {
+		return fmt.Errorf("cannot run the prepackage command of %s: only working tree skills can run scripts (use -skip-scripts)", source.Location())
+	}
+
+	fmt.Fprintf(stdout, "%s[PREPACKAGE]%s %s: %s\n", colorBlue, colorReset, skillName, command)
+	cmd := shellCommand(ctx, command)
+	cmd.Dir = source.Location()
+	cmd.Env = append(os.Environ(), "SKILL_DIR="+source.Location(), "SKILL_NAME="+skillName)
+
+	var output bytes.Buffer
+	if opts.Verbose {
+		cmd.Stdout = stdout
+		cmd.Stderr = os.Stderr
+	} else {
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+	}
+
+	err = cmd.Run()
+	// The command may have rewritten SKILL.md
+	delete(frontmatterCache, source.Location())
+	if err != nil {
+		if msg := strings.TrimSpace(output.String()); msg != "" {
+			return fmt.Errorf("prepackage %q failed: %w\n%s", command, err, msg)
+		}
+		return fmt.Errorf("prepackage %q failed: %w", command, err)
+	}
+	return nil
+}
+
+// shellCommand runs command through the platform shell.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// recordSkippedSkill records a skill that was intentionally not processed.
+func recordSkippedSkill(pluginName, skillName, reason string, opts *PackageOptions, stats *PackageStats) {
+	stats.Results = append(stats.Results, SkillResult{
+		Plugin: pluginName,
+		Skill:  skillName,
+		Status: statusSkipped,
+		Error:  reason,
+	})
+	stats.plugin(pluginName).SkillsSkipped++
+	opts.Progress.Step(0)
+	opts.Events.Emit(Event{
+		Type:   "skill_skipped",
+		Plugin: pluginName,
+		Skill:  skillName,
+		Error:  reason,
+	})
+}
+
+// gitChangedFiles returns the absolute paths of files that differ from ref,
+// including untracked files that are not ignored, in every repository
+// holding one of dirs. Plugins may live in repositories other than the
+// one containing the working directory.
+func gitChangedFiles(ref string, dirs []string) (map[string]bool, error) {
+	changed := make(map[string]bool)
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		out, err := runGit(existingAncestor(dir), "rev-parse", "--show-toplevel")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dir, err)
+		}
+		repoRoot := strings.TrimSpace(string(out))
+		if seen[repoRoot] {
+			continue
+		}
+		seen[repoRoot] = true
+
+		diff, err := runGit(repoRoot, "diff", "--name-only", "-z", ref, "--")
+		if err != nil {
+			return nil, err
+		}
+		untracked, err := runGit(repoRoot, "ls-files", "--others", "--exclude-standard", "-z")
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range strings.Split(string(diff)+string(untracked), "\x00") {
+			if name != "" {
+				changed[filepath.Join(repoRoot, filepath.FromSlash(name))] = true
+			}
+		}
+	}
+	return changed, nil
+}
+
+// skillUnchanged reports whether -since-git is active and no changed file
+// lies inside the skill's directory (or, for zip sources, is the archive).
+func skillUnchanged(skillPath string, opts *PackageOptions) bool {
+	if opts.ChangedFiles == nil {
+		return false
+	}
+	srcDir, err := filepath.Abs(skillPath)
+	if err != nil {
+		return false
+	}
+	if dirs, ok := opts.MergedSkills[srcDir]; ok {
+		for _, dir := range dirs {
+			if !skillUnchanged(dir, opts) {
+				return false
+			}
+		}
+		return true
+	}
+	if archive, _, ok := splitZipPath(srcDir); ok {
+		return !opts.ChangedFiles[archive]
+	}
+	// Resolve symlinks so the path lines up with git's view of the repo
+	if resolved, err := filepath.EvalSymlinks(srcDir); err == nil {
+		srcDir = resolved
+	}
+	prefix := srcDir + string(filepath.Separator)
+	for changed := range opts.ChangedFiles {
+		if strings.HasPrefix(changed, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// existingZipIsValid reports whether zipPath is a readable archive that
+// contains the skill's SKILL.md under root, returning its file count.
+func existingZipIsValid(zipPath, root string) (int, bool) {
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return 0, false
+	}
+	defer reader.Close()
+
+	for _, file := range reader.File {
+		if file.Name == path.Join(root, "SKILL.md") {
+			return len(reader.File), true
+		}
+	}
+	return 0, false
+}
+
+// repackageZips rebuilds each zip in dir into opts.OutputDir through
+// packageSkillToZip, reading the old archive as the skill source, so the
+// current options apply without the original sources. The plugin name is
+// taken from the zip's manifest sidecar when there is one. Zips without a
+// <name>/SKILL.md, such as those built with -no-root-prefix, fail.
+func repackageZips(dir string, opts *PackageOptions, stats *PackageStats) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.EqualFold(filepath.Ext(entry.Name()), ".zip") {
+			continue
+		}
+		archive := filepath.Join(dir, entry.Name())
+		skillName := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+
+		var pluginName string
+		if data, err := os.ReadFile(archive + ".manifest.json"); err == nil {
+			var manifest SkillManifest
+			if json.Unmarshal(data, &manifest) == nil {
+				pluginName = manifest.Plugin
+			}
+		}
+
+		start := time.Now()
+		var fileCount int
+		if _, ok := existingZipIsValid(archive, skillName); !ok {
+			err = fmt.Errorf("no %s/SKILL.md in %s", skillName, entry.Name())
+		} else {
+			fileCount, err = packageSkillToZip(context.Background(), pluginName, filepath.Join(archive, skillName), opts, stats)
+		}
+		recordSkillResult(pluginName, skillName, fileCount, time.Since(start), err, opts, stats)
+		if err != nil {
+			fmt.Fprintf(stdout, "%s[ERROR]%s Failed to repackage %s: %v\n", colorRed, colorReset, entry.Name(), err)
+			printErrorChain(err, opts)
+		}
+	}
+	return nil
+}
+
+// packageSkillToZip writes a single skill to its zip file and returns the
+// number of files added.
+func packageSkillToZip(ctx context.Context, pluginName, skillPath string, opts *PackageOptions, stats *PackageStats) (int, error) {
+	// Extract skill name from path
+	skillName := filepath.Base(skillPath)
+
+	// Create packaged skill name (with optional plugin prefix)
+	packagedName := packagedSkillName(pluginName, skillName, opts)
+
+	// Source path
+	srcDir, err := filepath.Abs(skillPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve source path %s: %w", skillPath, err)
+	}
+
+	// Open the skill source, checking that it exists and has a SKILL.md
+	source, err := openSkillSource(srcDir, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := runPrepackage(ctx, source, skillName, opts); err != nil {
+		return 0, err
+	}
+
+	if err := checkChangelog(source, opts); err != nil {
+		return 0, err
+	}
+
+	if err := checkFrontmatterSchema(source, opts); err != nil {
+		return 0, err
+	}
+
+	// Restrict the walk to the frontmatter files list, if the skill has one
+	filter, err := skillFileFilter(source)
+	if err != nil {
+		return 0, err
+	}
+
+	// Refuse to package source that has drifted from the lockfile
+	if err := checkLock(pluginName, skillName, source, filter, opts); err != nil {
+		return 0, err
+	}
+
+	compression, err := skillCompression(source, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	includes, err := openIncludeSources(srcDir, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	// Create individual zip file for this skill. With -dedupe it is
+	// written beside the old zip until the two have been compared.
+	zipPath := longPath(filepath.Join(opts.OutputDir, fmt.Sprintf("%s.zip", packagedName)))
+	writePath := zipPath
+	if opts.Dedupe {
+		writePath = zipPath + ".new"
+	}
+	zipFile, err := os.Create(writePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create zip file: %w", err)
+	}
+
+	zipWriter := zip.NewWriter(zipFile)
+	level := compressionLevels[compression]
+	method := uint16(zip.Store)
+	if level != flate.NoCompression {
+		method = zip.Deflate
+		zipWriter.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		})
+	}
+
+	if opts.Verbose {
+		fmt.Fprintf(stdout, "  Creating %s.zip (compression: %s)...\n", packagedName, compression)
+	}
+
+	// Add all files from skill source to zip
+	fileCount := 0
+	storedSmall := 0
+	var bytesSaved int64
+	var manifestFiles []ManifestFile
+	written := make(map[string]string)      // zip entry path -> origin
+	folded := make(map[string]string)       // lowercased zip entry path -> zip entry path
+	packaged := make(map[string]SourceFile) // zip entry path -> source, for -verify-extraction
+	addFile := func(file SourceFile, relPath string) error {
+		// Stop between files once the run's deadline has passed
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if isExcluded(relPath, opts) {
+			if opts.Verbose {
+				fmt.Fprintf(stdout, "    %s-%s Excluded: %s\n", colorYellow, colorReset, relPath)
+			}
+			return nil
+		}
+		if opts.MaxFileSize > 0 && file.Size > opts.MaxFileSize {
+			return fmt.Errorf("%s is %d bytes, over the %d byte limit", relPath, file.Size, opts.MaxFileSize)
+		}
+		if opts.AuditPerms {
+			if problems := suspiciousModeBits(file.Mode); len(problems) > 0 {
+				stats.FilesFlagged++
+				if opts.Strict {
+					return fmt.Errorf("%s is %s", relPath, strings.Join(problems, " and "))
+				}
+				fmt.Fprintf(stdout, "%s[WARN]%s %s is %s; clearing in the zip\n", colorYellow, colorReset, relPath, strings.Join(problems, " and "))
+				file.Mode &^= os.ModeSetuid | os.ModeSetgid | 0002
+			}
+		}
+		if opts.ValidateData {
+			if err := checkDataFile(file); err != nil {
+				stats.FilesInvalid++
+				if opts.Strict {
+					return fmt.Errorf("invalid %s: %w", relPath, err)
+				}
+				fmt.Fprintf(stdout, "%s[ERROR]%s Invalid %s in %s: %v\n", colorRed, colorReset, relPath, packagedName, err)
+			}
+		}
+
+		relPath, err := stripEntryPrefix(relPath, opts)
+		if err != nil {
+			return err
+		}
+
+		// Create path in zip with skill name as root, unless disabled
+		zipEntryPath := path.Join(zipEntryRoot(packagedName, opts), relPath)
+
+		// Duplicate entries extract unpredictably, so never write one
+		if first, ok := written[zipEntryPath]; ok {
+			if !opts.WarnDuplicates {
+				return fmt.Errorf("duplicate zip entry %s from %s and %s", zipEntryPath, first, file.Origin)
+			}
+			fmt.Fprintf(stdout, "%s[WARN]%s Duplicate zip entry %s: keeping %s, skipping %s\n", colorYellow, colorReset, zipEntryPath, first, file.Origin)
+			return nil
+		}
+
+		// Case-insensitive filesystems keep only one of the two on extraction
+		if other, ok := folded[strings.ToLower(zipEntryPath)]; ok {
+			stats.CaseCollisions++
+			if opts.Strict {
+				return fmt.Errorf("%s and %s differ only by case", other, zipEntryPath)
+			}
+			fmt.Fprintf(stdout, "%s[WARN]%s %s and %s differ only by case; one is lost when extracted on macOS or Windows\n", colorYellow, colorReset, other, zipEntryPath)
+		} else {
+			folded[strings.ToLower(zipEntryPath)] = zipEntryPath
+		}
+		written[zipEntryPath] = file.Origin
+
+		// Deflate overhead can outweigh the gain on tiny files
+		entryMethod, entryLevel := method, level
+		if file.Size < opts.CompressThreshold && level != flate.NoCompression {
+			entryMethod, entryLevel = zip.Store, flate.NoCompression
+			storedSmall++
+			if opts.Verbose {
+				saved, err := deflateSavings(file, level)
+				if err != nil {
+					return fmt.Errorf("failed to add %s: %w", relPath, err)
+				}
+				bytesSaved += saved
+			}
+		}
+
+		// Add file to zip
+		var binary bool
+		if opts.ZipPassword != "" {
+			binary, err = addEncryptedFileToZip(zipWriter, file, zipEntryPath, entryLevel, opts.ZipPassword)
+		} else {
+			binary, err = addFileToZip(zipWriter, file, zipEntryPath, entryMethod)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to add %s: %w", relPath, err)
+		}
+		if opts.Manifest {
+			manifestFiles = append(manifestFiles, ManifestFile{Path: zipEntryPath, Size: file.Size, Binary: binary})
+		}
+		if opts.VerifyExtraction {
+			packaged[zipEntryPath] = file
+		}
+		stats.recordLargest(LargeFile{Skill: packagedName, Path: relPath, Size: file.Size}, opts.ReportLargest)
+
+		fileCount++
+		if opts.Verbose {
+			fmt.Fprintf(stdout, "    %s✓%s Added: %s\n", colorGreen, colorReset, zipEntryPath)
+		}
+
+		return nil
+	}
+	err = source.Walk(func(file SourceFile) error {
+		if !filter.Includes(file.RelPath) {
+			if opts.Verbose && filter.ExportIgnored(file.RelPath) {
+				fmt.Fprintf(stdout, "    %s-%s Export-ignored: %s\n", colorYellow, colorReset, file.RelPath)
+			} else if opts.Verbose {
+				fmt.Fprintf(stdout, "    %s-%s Not in files list: %s\n", colorYellow, colorReset, file.RelPath)
+			}
+			return nil
+		}
+		return addFile(file, file.RelPath)
+	})
+
+	// Bundle each included directory under its own name
+	for _, include := range includes {
+		if err != nil {
+			break
+		}
+		before := fileCount
+		err = include.source.Walk(func(file SourceFile) error {
+			return addFile(file, path.Join(include.name, file.RelPath))
+		})
+		if err == nil {
+			fmt.Fprintf(stdout, "%s[INCLUDED]%s %s → %s/ (%d files)\n", colorBlue, colorReset, include.relPath, path.Join(zipEntryRoot(packagedName, opts), include.name), fileCount-before)
+		}
+	}
+
+	// Record the source state in a generated file of its own
+	if err == nil && opts.BuildInfo {
+		var file SourceFile
+		if file, err = buildInfoFile(srcDir, packagedName, opts); err == nil {
+			err = addFile(file, buildInfoName)
+		}
+	}
+
+	if err == nil && opts.Verbose && storedSmall > 0 {
+		fmt.Fprintf(stdout, "    Stored %d files under -compress-threshold uncompressed, saving %d bytes over deflate\n", storedSmall, bytesSaved)
+	}
+
+	if err == nil && len(opts.Labels) > 0 {
+		err = zipWriter.SetComment(formatLabels(opts.Labels, "\n"))
+	}
+	if err == nil {
+		if err = zipWriter.Close(); err != nil {
+			err = fmt.Errorf("failed to finish %s: %w", zipPath, err)
+		}
+	}
+	if closeErr := zipFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && opts.VerifyExtraction {
+		if err = verifyExtraction(writePath, packaged); err == nil && opts.Verbose {
+			fmt.Fprintf(stdout, "    %s✓%s Extraction verified: %d files\n", colorGreen, colorReset, len(packaged))
+		}
+	}
+	// An identical zip is kept as it is, but still goes through the steps
+	// below so its parts, mode, manifest and signature stay current. Only
+	// a zip written by this run is removed if one of them fails.
+	ownsZip := !opts.Dedupe
+	identical := false
+	if err == nil && opts.Dedupe {
+		if identical, err = replaceIfChanged(writePath, zipPath); err == nil {
+			ownsZip = !identical
+		}
+	}
+
+	// What gets distributed: the zip itself, or its parts once split
+	outputs := []string{zipPath}
+	if err == nil {
+		err = removeSplitParts(zipPath)
+	}
+	if err == nil && opts.SplitSize > 0 {
+		var parts []string
+		if parts, err = splitZip(zipPath, opts.SplitSize, opts.JSONFormat); parts != nil {
+			outputs = parts
+		}
+	}
+	for _, output := range outputs {
+		if err == nil && opts.OutputMode != 0 {
+			err = os.Chmod(output, opts.OutputMode)
+		}
+	}
+	if err == nil && opts.Manifest {
+		manifest := SkillManifest{Marketplace: opts.MarketplaceName, Plugin: pluginName, Skill: packagedName, Labels: opts.Labels, Files: manifestFiles}
+		if opts.GzipStats {
+			manifest.SkillMarkdown, err = skillMarkdownSize(source)
+		}
+		if err == nil {
+			err = writeSkillManifest(zipPath, manifest, opts.JSONFormat)
+		}
+	}
+	for _, output := range outputs {
+		if err == nil && opts.SignKey != nil {
+			err = signZip(output, opts.SignKey)
+		}
+	}
+	if err != nil {
+		// Never leave a partial archive behind
+		if writePath != zipPath {
+			os.Remove(writePath)
+		}
+		if ownsZip {
+			os.Remove(zipPath)
+			removeSplitParts(zipPath)
+		}
+		return 0, err
+	}
+
+	if identical {
+		stats.ZipsIdentical++
+		fmt.Fprintf(stdout, "%s[IDENTICAL]%s %s.zip (%d files, not rewritten)\n", colorGreen, colorReset, packagedName, fileCount)
+	} else {
+		fmt.Fprintf(stdout, "%s[PACKAGED]%s %s.zip (%d files added)\n", colorGreen, colorReset, packagedName, fileCount)
+	}
+	if len(outputs) > 1 {
+		names := make([]string, len(outputs))
+		for i, output := range outputs {
+			names[i] = filepath.Base(output)
+		}
+		fmt.Fprintf(stdout, "%s[SPLIT]%s %s.zip → %s (%d parts)\n", colorBlue, colorReset, packagedName, strings.Join(names, ", "), len(outputs))
+	}
+	// Files are never divided, so one over the limit leaves its zip or
+	// part over it too
+	for _, output := range outputs {
+		if info, err := os.Stat(output); err == nil && opts.SplitSize > 0 && info.Size() > opts.SplitSize {
+			fmt.Fprintf(stdout, "%s[WARN]%s %s is %d bytes, over -split-size %d: it holds a file too large to split\n", colorYellow, colorReset, filepath.Base(output), info.Size(), opts.SplitSize)
+		}
+	}
+
+	return fileCount, nil
+}
+
+// verifyExtraction extracts zipPath into a temporary directory and checks
+// that it holds exactly the packaged files, each with the content and
+// permission bits of its source. Permissions are not compared on Windows,
+// where the file system does not keep them.
+func verifyExtraction(zipPath string, packaged map[string]SourceFile) error {
+	tempDir, err := os.MkdirTemp("", "package-skills-verify-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return fmt.Errorf("extraction check: %w", err)
+	}
+	defer reader.Close()
+
+	var problems []string
+	extracted := make(map[string]bool)
+	for _, entry := range reader.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		extracted[entry.Name] = true
+		file, ok := packaged[entry.Name]
+		if !ok {
+			problems = append(problems, entry.Name+": not a packaged file")
+			continue
+		}
+		dst := filepath.Join(tempDir, filepath.FromSlash(entry.Name))
+		if !pathWithin(tempDir, dst) {
+			problems = append(problems, entry.Name+": extracts outside the archive root")
+			continue
+		}
+		if err := extractEntry(entry, dst); err != nil {
+			return fmt.Errorf("extraction check: failed to extract %s: %w", entry.Name, err)
+		}
+		if problem, err := compareExtracted(dst, file); err != nil {
+			return fmt.Errorf("extraction check: %w", err)
+		} else if problem != "" {
+			problems = append(problems, entry.Name+": "+problem)
+		}
+	}
+	for name := range packaged {
+		if !extracted[name] {
+			problems = append(problems, name+": missing from the zip")
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("extraction check failed for %s: %s", filepath.Base(zipPath), strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// extractEntry writes a zip entry to dst with the permission bits it was
+// archived with; Chmod is used because the umask would mask them.
+func extractEntry(entry *zip.File, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	src, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, entry.Mode().Perm())
+}
+
+// compareExtracted describes how the extracted file at dst differs from
+// its source, or returns "" when they match.
+func compareExtracted(dst string, file SourceFile) (string, error) {
+	info, err := os.Stat(dst)
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != file.Mode.Perm() {
+		return fmt.Sprintf("mode %04o, source %04o", info.Mode().Perm(), file.Mode.Perm()), nil
+	}
+
+	out, err := os.Open(dst)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	extracted, err := readerHash(out)
+	if err != nil {
+		return "", err
+	}
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	original, err := readerHash(src)
+	if err != nil {
+		return "", err
+	}
+	if extracted != original {
+		return "content differs from " + file.Origin, nil
+	}
+	return "", nil
+}
+
+// readerHash returns the hex SHA-256 of everything read from r.
+func readerHash(r io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// contentHashSuffix names the sidecar holding a zip's content hash for
+// -dedupe.
+const contentHashSuffix = ".content-hash"
+
+// replaceIfChanged compares the freshly written newPath with the zip at
+// zipPath by content hash. When they match and the old zip is still there,
+// newPath is discarded and true is returned. Otherwise newPath replaces
+// the zip and the hash is recorded beside it.
+func replaceIfChanged(newPath, zipPath string) (bool, error) {
+	hash, err := zipContentHash(newPath)
+	if err != nil {
+		return false, err
+	}
+	recorded, err := os.ReadFile(zipPath + contentHashSuffix)
+	if err == nil && strings.TrimSpace(string(recorded)) == hash {
+		if _, err := os.Stat(zipPath); err == nil {
+			return true, os.Remove(newPath)
+		}
+	}
+
+	if err := os.Rename(newPath, zipPath); err != nil {
+		return false, err
+	}
+	return false, os.WriteFile(zipPath+contentHashSuffix, []byte(hash+"\n"), 0644)
+}
+
+// zipContentHash hashes a zip's comment and its entries sorted by name,
+// covering each entry's name, mode and contents, so archives holding the
+// same files hash alike whatever their timestamps or entry order.
+func zipContentHash(zipPath string) (string, error) {
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	files := append([]*zip.File(nil), reader.File...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "comment %q\n", reader.Comment)
+	for _, file := range files {
+		fmt.Fprintf(hash, "%q %o %d\n", file.Name, file.Mode(), file.UncompressedSize64)
+		rc, err := file.Open()
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", file.Name, err)
+		}
+		_, err = io.Copy(hash, rc)
+		rc.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", file.Name, err)
+		}
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// splitPartsName is the sidecar listing a split zip's parts in order.
+const splitPartsName = ".parts.json"
+
+// SplitParts describes how a split zip was divided. Every part is a
+// complete zip; extracting them all into one directory rebuilds the skill.
+type SplitParts struct {
+	Zip   string      `json:"zip"`
+	Parts []SplitPart `json:"parts"`
+}
+
+// SplitPart is one part zip in a SplitParts sidecar.
+type SplitPart struct {
+	File  string   `json:"file"`
+	Size  int64    `json:"size"`
+	Files []string `json:"files"`
+}
+
+// readSplitParts reads the parts sidecar of a split zip. A zip that was
+// never split has no parts.
+func readSplitParts(zipPath string) (SplitParts, error) {
+	var parts SplitParts
+	data, err := os.ReadFile(strings.TrimSuffix(zipPath, ".zip") + splitPartsName)
+	if os.IsNotExist(err) {
+		return parts, nil
+	}
+	if err != nil {
+		return parts, err
+	}
+	return parts, json.Unmarshal(data, &parts)
+}
+
+// splitZip replaces zipPath with <name>.part-N.zip files when it is larger
+// than limit, copying entries without recompressing them. Entries are never
+// divided, so a single entry bigger than limit gets a part of its own that
+// is over it. It writes a <name>.parts.json sidecar and returns the part
+// paths, or nil when the zip is kept whole.
+func splitZip(zipPath string, limit int64, format JSONFormat) ([]string, error) {
+	info, err := os.Stat(zipPath)
+	if err != nil || info.Size() <= limit {
+		return nil, err
+	}
+
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	// Group entries by their compressed size plus local and central
+	// directory header overhead
+	var groups [][]*zip.File
+	var groupSize int64
+	for _, file := range reader.File {
+		size := int64(file.CompressedSize64) + int64(2*len(file.Name)+len(file.Extra)) + 76
+		if len(groups) == 0 || groupSize+size > limit {
+			groups = append(groups, nil)
+			groupSize = 0
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], file)
+		groupSize += size
+	}
+	if len(groups) < 2 {
+		return nil, nil
+	}
+
+	base := strings.TrimSuffix(zipPath, ".zip")
+	sidecar := SplitParts{Zip: filepath.Base(zipPath)}
+	var parts []string
+	for i, group := range groups {
+		partPath := fmt.Sprintf("%s.part-%d.zip", base, i+1)
+		part, err := writeSplitPart(partPath, group, reader.Comment)
+		if err != nil {
+			return parts, err
+		}
+		parts = append(parts, partPath)
+		sidecar.Parts = append(sidecar.Parts, part)
+	}
+
+	data, err := marshalJSON(sidecar, format)
+	if err != nil {
+		return parts, err
+	}
+	if err := os.WriteFile(base+splitPartsName, append(data, '\n'), 0644); err != nil {
+		return parts, err
+	}
+	return parts, os.Remove(zipPath)
+}
+
+// writeSplitPart copies files into a new zip at partPath.
+func writeSplitPart(partPath string, files []*zip.File, comment string) (SplitPart, error) {
+	part := SplitPart{File: filepath.Base(partPath)}
+	out, err := os.Create(partPath)
+	if err != nil {
+		return part, err
+	}
+	writer := zip.NewWriter(out)
+	for _, file := range files {
+		if err = writer.Copy(file); err != nil {
+			break
+		}
+		part.Files = append(part.Files, file.Name)
+	}
+	if err == nil && comment != "" {
+		err = writer.SetComment(comment)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return part, fmt.Errorf("failed to write %s: %w", part.File, err)
+	}
+	info, err := os.Stat(partPath)
+	if err != nil {
+		return part, err
+	}
+	part.Size = info.Size()
+	return part, nil
+}
+
+// removeSplitParts deletes the parts and sidecar left by an earlier split
+// of zipPath, so a rebuilt skill never mixes old and new parts.
+func removeSplitParts(zipPath string) error {
+	base := strings.TrimSuffix(zipPath, ".zip")
+	parts, err := filepath.Glob(globEscape(base) + ".part-*.zip")
+	if err != nil {
+		return err
+	}
+	for _, part := range append(parts, base+splitPartsName) {
+		siblings, _ := filepath.Glob(globEscape(part) + ".*")
+		for _, target := range append(siblings, part) {
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// globEscape escapes the filepath.Match metacharacters in a literal path.
+func globEscape(p string) string {
+	var b strings.Builder
+	for _, c := range p {
+		if strings.ContainsRune(`*?[\`, c) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// splitPartOf returns the zip name a <name>.part-N.zip belongs to.
+func splitPartOf(name string) (string, bool) {
+	trimmed := strings.TrimSuffix(name, ".zip")
+	i := strings.LastIndex(trimmed, ".part-")
+	if i < 0 || trimmed == name {
+		return "", false
+	}
+	if _, err := strconv.Atoi(trimmed[i+len(".part-"):]); err != nil {
+		return "", false
+	}
+	return trimmed[:i] + ".zip", true
+}
+
+// includeSource is a directory bundled into a skill's zip by -include-parent.
+type includeSource struct {
+	relPath string // as given on the command line
+	name    string // directory name used inside the zip
+	source  SkillSource
+}
+
+// openIncludeSources resolves each -include-parent path against the skill's
+// source directory. Paths must stay inside the plugin (the directory above
+// skills/) and lie outside the skill itself.
+func openIncludeSources(srcDir string, opts *PackageOptions) ([]includeSource, error) {
+	pluginDir := filepath.Dir(filepath.Dir(srcDir))
+	var includes []includeSource
+	for _, relPath := range opts.IncludeParents {
+		if filepath.IsAbs(relPath) {
+			return nil, fmt.Errorf("include path %s must be relative", relPath)
+		}
+		dir := filepath.Join(srcDir, filepath.FromSlash(relPath))
+		if dir == pluginDir || !pathWithin(pluginDir, dir) {
+			return nil, fmt.Errorf("include path %s escapes the plugin directory", relPath)
+		}
+		if pathWithin(srcDir, dir) {
+			return nil, fmt.Errorf("include path %s is inside the skill", relPath)
+		}
+
+		source, err := openSource(dir, opts)
+		if err != nil {
+			return nil, fmt.Errorf("include path %s: %w", relPath, err)
+		}
+		includes = append(includes, includeSource{relPath: relPath, name: filepath.Base(dir), source: source})
+	}
+	return includes, nil
+}
+
+// legacyMetaName is the metadata file of the skill layout that predates
+// SKILL.md frontmatter.
+const legacyMetaName = "meta.yaml"
+
+// convertLegacySkills writes a SKILL.md for every skill directory holding
+// a legacy meta.yaml, returning how many were converted. An existing
+// SKILL.md is only replaced with Force, and its body is kept. Under DryRun
+// nothing is written.
+func convertLegacySkills(marketplace *MarketplaceConfig, opts *PackageOptions) (int, error) {
+	converted := 0
+	for _, plugin := range marketplace.Plugins {
+		for _, skillPath := range plugin.Skills {
+			skillName := filepath.Base(skillPath)
+			skillDir := filepath.Join(plugin.Source, "skills", skillName)
+			absDir, err := filepath.Abs(skillDir)
+			if err != nil {
+				return converted, err
+			}
+			// Only plain directories can be written to
+			if _, ok := opts.MergedSkills[absDir]; ok {
+				continue
+			}
+			if _, _, ok := splitZipPath(absDir); ok {
+				continue
+			}
+
+			metaPath := filepath.Join(skillDir, legacyMetaName)
+			meta, err := os.ReadFile(metaPath)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return converted, err
+			}
+
+			skillFile := filepath.Join(skillDir, "SKILL.md")
+			existing, err := os.ReadFile(skillFile)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return converted, err
+			}
+			if err == nil && !opts.Force {
+				fmt.Fprintf(stdout, "%s[SKIP]%s %s: SKILL.md already exists (use -force to replace it)\n", colorYellow, colorReset, skillDir)
+				continue
+			}
+
+			data, err := convertLegacyMeta(meta, existing, skillName)
+			if err != nil {
+				return converted, fmt.Errorf("%s: %w", metaPath, err)
+			}
+			if opts.DryRun {
+				fmt.Fprintf(stdout, "%s[DRY RUN]%s Would convert %s → SKILL.md\n", colorYellow, colorReset, metaPath)
+				if opts.Verbose {
+					fmt.Fprint(stdout, string(data))
+				}
+				converted++
+				continue
+			}
+			if err := os.WriteFile(skillFile, data, 0644); err != nil {
+				return converted, err
+			}
+			fmt.Fprintf(stdout, "%s[CONVERTED]%s %s → SKILL.md\n", colorGreen, colorReset, metaPath)
+			converted++
+		}
+	}
+	return converted, nil
+}
+
+// scaffoldSkill creates <plugin source>/skills/<skill> for a "plugin/skill"
+// target, with a SKILL.md holding frontmatter (name is filled in) and every
+// -require-dirs directory, each kept in git by an empty .gitkeep. An
+// existing skill directory is never touched.
+func scaffoldSkill(marketplace *MarketplaceConfig, target string, frontmatter *Frontmatter, opts *PackageOptions) error {
+	pluginName, skillName, ok := strings.Cut(target, "/")
+	if !ok || pluginName == "" || skillName == "" || skillName == "." || skillName == ".." ||
+		strings.ContainsAny(skillName, `/\:*?"<>|`) || strings.IndexFunc(skillName, unicode.IsControl) >= 0 {
+		return fmt.Errorf("invalid target %q (expected <plugin>/<skill>)", target)
+	}
+
+	var plugin *Plugin
+	for i := range marketplace.Plugins {
+		if marketplace.Plugins[i].Name == pluginName {
+			plugin = &marketplace.Plugins[i]
+			break
+		}
+	}
+	if plugin == nil {
+		return fmt.Errorf("unknown plugin %q", pluginName)
+	}
+	if _, _, ok := splitZipPath(plugin.Source); ok {
+		return fmt.Errorf("plugin %q is read from a zip archive", pluginName)
+	}
+
+	skillDir := filepath.Join(plugin.Source, "skills", skillName)
+	if _, err := os.Lstat(skillDir); err == nil {
+		return fmt.Errorf("%s already exists", skillDir)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	frontmatter.scalars["name"] = skillName
+	files := map[string][]byte{
+		filepath.Join(skillDir, "SKILL.md"): append(formatFrontmatter(frontmatter), "\n# "+skillName+"\n"...),
+	}
+	for _, dir := range opts.RequiredDirs {
+		files[filepath.Join(skillDir, filepath.FromSlash(dir), ".gitkeep")] = nil
+	}
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if opts.DryRun {
+			fmt.Fprintf(stdout, "%s[DRY RUN]%s Would create %s\n", colorYellow, colorReset, path)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, files[path], 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s[CREATED]%s %s\n", colorGreen, colorReset, path)
+	}
+	return nil
+}
+
+// registerSkill appends skillPath to the skills list of the plugin entry
+// named pluginName in marketplace.json. Only that list is rewritten; the
+// rest of the file keeps its formatting. Plugins pulled in through $ref are
+// not followed.
+func registerSkill(marketplaceFile, pluginName, skillPath string, opts *PackageOptions) error {
+	info, err := os.Stat(marketplaceFile)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(marketplaceFile)
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(skillPath)
+	if err != nil {
+		return err
+	}
+
+	top, err := parseJSONSpans(data, 0, '{')
+	if err != nil {
+		return err
+	}
+	plugins, ok := top.find("plugins")
+	if !ok {
+		return fmt.Errorf("no plugins in %s", marketplaceFile)
+	}
+	entries, err := parseJSONSpans(data[:plugins.end], plugins.start, '[')
+	if err != nil {
+		return fmt.Errorf("plugins: %w", err)
+	}
+
+	var updated []byte
+	for i, entrySpan := range entries {
+		entry, err := parseJSONSpans(data[:entrySpan.end], entrySpan.start, '{')
+		if err != nil {
+			return fmt.Errorf("plugin entry %d: %w", i, err)
+		}
+		nameSpan, ok := entry.find("name")
+		var name string
+		if !ok || json.Unmarshal(data[nameSpan.start:nameSpan.end], &name) != nil || name != pluginName {
+			continue
+		}
+
+		skillsSpan, ok := entry.find("skills")
+		if !ok {
+			// Add the key after the entry's last value, on a line of its own
+			last := entry[len(entry)-1]
+			insert := fmt.Sprintf(",\n%s\"skills\": [%s]", lineIndent(data, last.keyEnd), encoded)
+			updated = append(append(append([]byte{}, data[:last.end]...), insert...), data[last.end:]...)
+			break
+		}
+		skills, err := parseJSONSpans(data[:skillsSpan.end], skillsSpan.start, '[')
+		if err != nil {
+			return fmt.Errorf("plugin %q skills: %w", pluginName, err)
+		}
+		for _, skill := range skills {
+			var existing string
+			if json.Unmarshal(data[skill.start:skill.end], &existing) == nil && filepath.Clean(existing) == filepath.Clean(skillPath) {
+				return fmt.Errorf("plugin %q already lists %s", pluginName, skillPath)
+			}
+		}
+
+		// Follow the list's layout: inline lists stay on one line, and a
+		// multi-line list gets the new path on a line of its own
+		var insert string
+		at := skillsSpan.start + 1
+		switch {
+		case len(skills) == 0:
+			insert = string(encoded)
+		case bytes.Contains(data[skillsSpan.start:skillsSpan.end], []byte("\n")):
+			at = skills[len(skills)-1].end
+			insert = fmt.Sprintf(",\n%s%s", lineIndent(data, skills[len(skills)-1].start), encoded)
+		default:
+			at = skills[len(skills)-1].end
+			insert = ", " + string(encoded)
+		}
+		updated = append(append(append([]byte{}, data[:at]...), insert...), data[at:]...)
+		break
+	}
+	if updated == nil {
+		return fmt.Errorf("no plugin entry named %q in %s; plugins included with $ref must be edited by hand", pluginName, marketplaceFile)
+	}
+	if !json.Valid(updated) {
+		return fmt.Errorf("could not add %s to %s without breaking it; add it by hand", skillPath, marketplaceFile)
+	}
+
+	if opts.DryRun {
+		fmt.Fprintf(stdout, "%s[DRY RUN]%s Would add %s to plugin '%s' in %s\n", colorYellow, colorReset, skillPath, pluginName, marketplaceFile)
+		return nil
+	}
+	if err := os.WriteFile(marketplaceFile, updated, info.Mode().Perm()); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s[REGISTERED]%s %s in plugin '%s' (%s)\n", colorGreen, colorReset, skillPath, pluginName, marketplaceFile)
+	return nil
+}
+
+// jsonSpan locates one member of a JSON object or array within the file:
+// keyEnd is where an object member's key ends, and start and end bound its
+// value. Array elements have no key, so keyEnd equals start.
+type jsonSpan struct {
+	key    string
+	keyEnd int
+	start  int
+	end    int
+}
+
+type jsonSpans []jsonSpan
+
+func (spans jsonSpans) find(key string) (jsonSpan, bool) {
+	for _, span := range spans {
+		if span.key == key {
+			return span, true
+		}
+	}
+	return jsonSpan{}, false
+}
+
+// parseJSONSpans reads the object or array (as open says) starting at
+// offset in data and returns where each of its members lies, so a single
+// value can be edited in place.
+func parseJSONSpans(data []byte, offset int, open json.Delim) (jsonSpans, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data[offset:]))
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != open {
+		return nil, fmt.Errorf("expected %q", open)
+	}
+
+	var spans jsonSpans
+	for decoder.More() {
+		var span jsonSpan
+		if open == '{' {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			span.key, _ = token.(string)
+			span.keyEnd = offset + int(decoder.InputOffset())
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		span.end = offset + int(decoder.InputOffset())
+		span.start = span.end - len(value)
+		if open == '[' {
+			span.keyEnd = span.start
+		}
+		spans = append(spans, span)
+	}
+	return spans, nil
+}
+
+// lineIndent returns the whitespace that starts the line containing pos.
+func lineIndent(data []byte, pos int) string {
+	lineStart := bytes.LastIndexByte(data[:pos], '\n') + 1
+	end := lineStart
+	for end < len(data) && (data[end] == ' ' || data[end] == '\t') {
+		end++
+	}
+	return string(data[lineStart:end])
+}
+
+// convertLegacyMeta builds a SKILL.md whose frontmatter holds the keys of
+// a legacy meta.yaml. The body of existing, if any, is kept along with any
+// frontmatter keys meta.yaml does not set; otherwise the body is a heading
+// with the skill name. A missing name defaults to skillName.
+func convertLegacyMeta(meta, existing []byte, skillName string) ([]byte, error) {
+	text := strings.ReplaceAll(string(meta), "\r\n", "\n")
+	text = strings.TrimPrefix(text, "---\n")
+	frontmatter, err := parseFrontmatter([]byte("---\n" + text + "\n---\n"))
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		current, err := parseFrontmatter(existing)
+		if err != nil {
+			return nil, fmt.Errorf("existing SKILL.md: %w", err)
+		}
+		for key, value := range current.scalars {
+			if !frontmatter.Has(key) {
+				frontmatter.scalars[key] = value
+			}
+		}
+		for key, list := range current.lists {
+			if !frontmatter.Has(key) {
+				frontmatter.lists[key] = list
+			}
+		}
+	}
+	if !frontmatter.Has("name") {
+		frontmatter.scalars["name"] = skillName
+	}
+
+	body := "# " + frontmatter.String("name") + "\n"
+	if existing != nil {
+		body = string(existing)
+		lines := strings.SplitAfter(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+		if len(lines) > 0 && strings.TrimSpace(lines[0]) == "---" {
+			for i := 1; i < len(lines); i++ {
+				if strings.TrimSpace(lines[i]) == "---" {
+					body = strings.Join(lines[i+1:], "")
+					break
+				}
+			}
+		}
+	}
+	return append(formatFrontmatter(frontmatter), body...), nil
+}
+
+// formatFrontmatter writes frontmatter as a "---" delimited block that
+// parseFrontmatter reads back unchanged: name and description first, then
+// the remaining keys in alphabetical order.
+func formatFrontmatter(frontmatter *Frontmatter) []byte {
+	var keys []string
+	for key := range frontmatter.scalars {
+		keys = append(keys, key)
+	}
+	for key := range frontmatter.lists {
+		keys = append(keys, key)
+	}
+	rank := func(key string) int {
+		switch key {
+		case "name":
+			return 0
+		case "description":
+			return 1
+		}
+		return 2
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if rank(keys[i]) != rank(keys[j]) {
+			return rank(keys[i]) < rank(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+
+	var b strings.Builder
+	b.WriteString("---\n")
+	for _, key := range keys {
+		list, isList := frontmatter.lists[key]
+		switch {
+		case isList && len(list) == 0:
+			fmt.Fprintf(&b, "%s: []\n", key)
+		case isList:
+			fmt.Fprintf(&b, "%s:\n", key)
+			for _, item := range list {
+				fmt.Fprintf(&b, "  - %s\n", quoteYAML(item))
+			}
+		case strings.Contains(frontmatter.scalars[key], "\n"):
+			fmt.Fprintf(&b, "%s: |\n", key)
+			for _, line := range strings.Split(frontmatter.scalars[key], "\n") {
+				fmt.Fprintf(&b, "  %s\n", line)
+			}
+		default:
+			fmt.Fprintf(&b, "%s: %s\n", key, quoteYAML(frontmatter.scalars[key]))
+		}
+	}
+	b.WriteString("---\n")
+	return []byte(b.String())
+}
+
+// quoteYAML quotes a single-line scalar when it would not otherwise read
+// back as a plain string. Double quotes are preferred because unquoteYAML
+// does not undo escapes; a value containing one falls back to single quotes
+// with any single quote doubled, as YAML requires.
+func quoteYAML(value string) string {
+	plain := value != "" && value == strings.TrimSpace(value) &&
+		!strings.ContainsAny(value[:1], "!&*[]{}|>'\"%@`#,?:-") &&
+		!strings.Contains(value, ": ") && !strings.Contains(value, " #") && !strings.HasSuffix(value, ":")
+	switch {
+	case plain:
+		return value
+	case !strings.Contains(value, `"`):
+		return `"` + value + `"`
+	}
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// findUnusedSkills scans each plugin's skills directory for subdirectories
+// containing a SKILL.md that no plugin in the marketplace references.
+// Plugins read from zip archives are not scanned.
+func findUnusedSkills(marketplace *MarketplaceConfig) ([]string, error) {
+	// Several plugins may share a source, so collect references per
+	// skills directory before scanning
+	referenced := make(map[string]map[string]bool)
+	var skillsDirs []string
+	for _, plugin := range marketplace.Plugins {
+		skillsDir := filepath.Join(plugin.Source, "skills")
+		if _, _, ok := splitZipPath(skillsDir); ok {
+			continue
+		}
+		if referenced[skillsDir] == nil {
+			referenced[skillsDir] = make(map[string]bool)
+			skillsDirs = append(skillsDirs, skillsDir)
+		}
+		for _, skillPath := range plugin.Skills {
+			referenced[skillsDir][filepath.Base(skillPath)] = true
+		}
+		// Directories only used as merge sources are referenced too
+		for _, sources := range plugin.Merged {
+			for _, source := range sources {
+				if dir := filepath.Join(plugin.Source, source); filepath.Dir(dir) == skillsDir {
+					referenced[skillsDir][filepath.Base(dir)] = true
+				}
+			}
+		}
+	}
+
+	var unused []string
+	for _, skillsDir := range skillsDirs {
+		entries, err := os.ReadDir(skillsDir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || referenced[skillsDir][entry.Name()] {
+				continue
+			}
+			skillDir := filepath.Join(skillsDir, entry.Name())
+			if _, err := os.Stat(filepath.Join(skillDir, "SKILL.md")); err == nil {
+				unused = append(unused, skillDir)
+			}
+		}
+	}
+	return unused, nil
+}
+
+// SkillListing describes the files a skill would be packaged with.
+type SkillListing struct {
+	Plugin    string       `json:"plugin"`
+	Skill     string       `json:"skill"`
+	Name      string       `json:"name"`
+	Files     []ListedFile `json:"files"`
+	FileCount int          `json:"file_count"`
+	TotalSize int64        `json:"total_size"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// ListedFile is a single file in a SkillListing.
+type ListedFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// listSkillFiles walks every skill the same way packaging does, applying
+// the frontmatter files list, and records what would be added to each zip.
+func listSkillFiles(marketplace *MarketplaceConfig, opts *PackageOptions) []SkillListing {
+	var listings []SkillListing
+	for _, plugin := range marketplace.Plugins {
+		for _, skillPath := range plugin.Skills {
+			skillName := filepath.Base(skillPath)
+			listing := SkillListing{
+				Plugin: plugin.Name,
+				Skill:  skillName,
+				Name:   packagedSkillName(plugin.Name, skillName, opts),
+				Files:  []ListedFile{},
+			}
+			if err := walkSkillListing(filepath.Join(plugin.Source, "skills", skillName), opts, &listing); err != nil {
+				listing.Error = err.Error()
+			}
+			listings = append(listings, listing)
+		}
+	}
+	return listings
+}
+
+func walkSkillListing(skillPath string, opts *PackageOptions, listing *SkillListing) error {
+	srcDir, err := filepath.Abs(skillPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve source path %s: %w", skillPath, err)
+	}
+	source, err := openSkillSource(srcDir, opts)
+	if err != nil {
+		return err
+	}
+	filter, err := skillFileFilter(source)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	err = source.Walk(func(file SourceFile) error {
+		if !filter.Includes(file.RelPath) || isExcluded(file.RelPath, opts) || seen[file.RelPath] {
+			return nil
+		}
+		if opts.MaxFileSize > 0 && file.Size > opts.MaxFileSize {
+			return fmt.Errorf("%s is %d bytes, over the %d byte limit", file.RelPath, file.Size, opts.MaxFileSize)
+		}
+		seen[file.RelPath] = true
+		listing.Files = append(listing.Files, ListedFile{Path: file.RelPath, Size: file.Size})
+		listing.FileCount++
+		listing.TotalSize += file.Size
+		return nil
+	})
+	sort.Slice(listing.Files, func(i, j int) bool {
+		return listing.Files[i].Path < listing.Files[j].Path
+	})
+	return err
+}
+
+func printFileListing(listings []SkillListing) {
+	totalFiles := 0
+	var totalSize int64
+	for _, listing := range listings {
+		fmt.Printf("\n%s=== %s (%s/%s) ===%s\n", colorBlue, listing.Name, listing.Plugin, listing.Skill, colorReset)
+		if listing.Error != "" {
+			fmt.Printf("%s[ERROR]%s %s\n", colorRed, colorReset, listing.Error)
+			continue
+		}
+		for _, file := range listing.Files {
+			fmt.Printf("  %-60s %10s\n", file.Path, formatBytes(file.Size))
+		}
+		fmt.Printf("  %d files, %s\n", listing.FileCount, formatBytes(listing.TotalSize))
+		totalFiles += listing.FileCount
+		totalSize += listing.TotalSize
+	}
+	fmt.Printf("\n%sTotal:%s %d skills, %d files, %s\n", colorBlue, colorReset, len(listings), totalFiles, formatBytes(totalSize))
+}
+
+// labelsFlag collects repeated -label key=value flags.
+type labelsFlag map[string]string
+
+func (l labelsFlag) String() string {
+	return formatLabels(l, ",")
+}
+
+// Set parses one key=value pair. Keys may not repeat, and neither part may
+// contain a line break, since labels are written one per line in zip
+// comments.
+func (l labelsFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("label %q contains a line break", key)
+	}
+	if _, dup := l[key]; dup {
+		return fmt.Errorf("duplicate label %q", key)
+	}
+	l[key] = val
+	return nil
+}
+
+// formatLabels joins labels as key=value pairs sorted by key.
+func formatLabels(labels map[string]string, sep string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + labels[key]
+	}
+	return strings.Join(pairs, sep)
+}
+
+// formatBytes renders a byte count using binary units.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// parseOutputMode parses an octal permission string such as "0644". An
+// empty string returns 0, meaning the default modes are kept, so an
+// explicit mode of 0 is rejected rather than silently ignored.
+func parseOutputMode(value string) (os.FileMode, error) {
+	if value == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid octal mode %q", value)
+	}
+	if mode == 0 {
+		return 0, fmt.Errorf("mode %q grants no permissions at all", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// selfTestFiles is the sample skill packaged by -selftest.
+var selfTestFiles = map[string]string{
+	"SKILL.md":            "---\nname: selftest\ndescription: Sample skill generated by -selftest\n---\n# Self test\n",
+	"references/notes.md": strings.Repeat("Compressible reference text.\n", 64),
+}
+
+// runSelfTest packages a generated sample skill into a temporary directory
+// through the normal packaging path, then reads the zip back to check that
+// every file round-trips. It prints each check and reports overall success.
+func runSelfTest(opts *PackageOptions) bool {
+	printHeader("Package Skills Self Test")
+
+	check := func(name string, err error) bool {
+		if err != nil {
+			fmt.Printf("%s✗%s %s: %v\n", colorRed, colorReset, name, err)
+			return false
+		}
+		fmt.Printf("%s✓%s %s\n", colorGreen, colorReset, name)
+		return true
+	}
+
+	tempDir, err := os.MkdirTemp("", "package-skills-selftest-")
+	if !check("Create temporary directory", err) {
+		return false
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Build a one-skill marketplace on disk
+	pluginDir := filepath.Join(tempDir, "plugins", "selftest")
+	for relPath, content := range selfTestFiles {
+		filePath := filepath.Join(pluginDir, "skills", "selftest", filepath.FromSlash(relPath))
+		if err = os.MkdirAll(filepath.Dir(filePath), 0755); err == nil {
+			err = os.WriteFile(filePath, []byte(content), 0644)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if !check("Write sample skill", err) {
+		return false
+	}
+	marketplace := &MarketplaceConfig{
+		Name:    "selftest",
+		Plugins: []Plugin{{Name: "selftest", Source: pluginDir, Skills: []string{"./skills/selftest"}}},
+	}
+
+	// Package it with the caller's settings, but into the temporary
+	// directory and without side effects such as signing or build hooks
+	testOpts := &PackageOptions{
+		OutputDir:   filepath.Join(tempDir, "out"),
+		Compression: opts.Compression,
+		OutputMode:  opts.OutputMode,
+		SkipBuild:   true,
+		SkipScripts: true,
+	}
+	stats := &PackageStats{}
+	previous := stdout
+	if !opts.Verbose {
+		stdout = io.Discard
+	}
+	err = os.MkdirAll(testOpts.OutputDir, 0755)
+	if err == nil {
+		err = createSkillZips(context.Background(), marketplace, testOpts, stats)
+	}
+	if err == nil && stats.SkillsFailed > 0 {
+		err = errors.New(stats.Results[len(stats.Results)-1].Error)
+	}
+	stdout = previous
+	if !check("Package sample skill", err) {
+		return false
+	}
+
+	zipPath := filepath.Join(testOpts.OutputDir, "selftest.zip")
+	if opts.OutputMode != 0 {
+		info, err := os.Stat(zipPath)
+		if err == nil && info.Mode().Perm() != opts.OutputMode {
+			err = fmt.Errorf("mode is %04o, expected %04o", info.Mode().Perm(), opts.OutputMode)
+		}
+		if !check("Apply output permissions", err) {
+			return false
+		}
+	}
+
+	if !check("Verify zip contents", verifySelfTestZip(zipPath)) {
+		return false
+	}
+
+	fmt.Printf("\n%s✓ Self test passed (compression: %s)%s\n\n", colorGreen, opts.Compression, colorReset)
+	return true
+}
+
+// verifySelfTestZip checks that the zip holds exactly the sample skill's
+// files with their original content.
+func verifySelfTestZip(zipPath string) error {
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if len(reader.File) != len(selfTestFiles) {
+		return fmt.Errorf("zip has %d entries, expected %d", len(reader.File), len(selfTestFiles))
+	}
+	for _, file := range reader.File {
+		expected, ok := selfTestFiles[strings.TrimPrefix(file.Name, "selftest/")]
+		if !ok {
+			return fmt.Errorf("unexpected entry %s", file.Name)
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return err
+		}
+		// Reading to EOF also verifies the entry's CRC-32
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", file.Name, err)
+		}
+		if string(data) != expected {
+			return fmt.Errorf("%s: content does not match", file.Name)
+		}
+	}
+	return nil
+}
+
+// readLockfile loads the lockfile at path. A missing file is only allowed
+// when it is about to be written with -update-lock.
+func readLockfile(path string, allowMissing bool) (*Lockfile, error) {
+	lock := &Lockfile{Skills: make(map[string]string)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if allowMissing && errors.Is(err, os.ErrNotExist) {
+			return lock, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if lock.Skills == nil {
+		lock.Skills = make(map[string]string)
+	}
+	return lock, nil
+}
+
+func writeLockfile(path string, lock *Lockfile, format JSONFormat) error {
+	data, err := marshalJSON(lock, format)
+	if err != nil {
+		return err
+	}
+	return writeReportFile(path, append(data, '\n'))
+}
+
+// pruneLock removes the entries of skills not in known, keyed as
+// "plugin/skill", and returns their keys in sorted order.
+func pruneLock(lock *Lockfile, known map[string]bool) []string {
+	var removed []string
+	for key := range lock.Skills {
+		if !known[key] {
+			delete(lock.Skills, key)
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// checkLock compares the skill's source hash against the lockfile, or
+// records it when the lockfile is being updated.
+func checkLock(pluginName, skillName string, source SkillSource, filter *FileFilter, opts *PackageOptions) error {
+	if opts.Lock == nil {
+		return nil
+	}
+
+	hash, err := sourceHash(source, filter, opts)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", source.Location(), err)
+	}
+
+	key := pluginName + "/" + skillName
+	if opts.UpdateLock {
+		opts.Lock.Skills[key] = hash
+		return nil
+	}
+
+	expected, ok := opts.Lock.Skills[key]
+	if !ok {
+		return fmt.Errorf("%s is not in the lockfile (run with -update-lock)", key)
+	}
+	if expected != hash {
+		return fmt.Errorf("source hash mismatch for %s: lockfile has %s, source is %s", key, expected, hash)
+	}
+	return nil
+}
+
+// sourceHash returns a SHA-256 over the path and contents of every file that
+// would be packaged, independent of walk order and file timestamps.
+func sourceHash(source SkillSource, filter *FileFilter, opts *PackageOptions) (string, error) {
+	fileHashes := make(map[string]string)
+	err := source.Walk(func(file SourceFile) error {
+		if !filter.Includes(file.RelPath) || isExcluded(file.RelPath, opts) {
+			return nil
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		h := sha256.New()
+		if _, err := io.Copy(h, reader); err != nil {
+			return fmt.Errorf("failed to read %s: %w", file.RelPath, err)
+		}
+		fileHashes[file.RelPath] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	paths := make([]string, 0, len(fileHashes))
+	for relPath := range fileHashes {
+		paths = append(paths, relPath)
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, relPath := range paths {
+		fmt.Fprintf(h, "%s  %s\n", fileHashes[relPath], relPath)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checksumZips records the SHA-256 and size of the zip behind each packaged
+// or resumed skill. A split zip gets a checksum per part, and one over the
+// parts in order that -baseline compares. Skills skipped without a zip are
+// left without one.
+func checksumZips(stats *PackageStats, opts *PackageOptions) error {
+	for i := range stats.Results {
+		result := &stats.Results[i]
+		if result.Status == statusFailed {
+			continue
+		}
+		zipPath := filepath.Join(opts.OutputDir, packagedSkillName(result.Plugin, result.Skill, opts)+".zip")
+		outputs, err := zipOutputs(zipPath)
+		if err != nil {
+			return err
+		}
+		if len(outputs) == 0 {
+			continue
+		}
+
+		whole := sha256.New()
+		result.Bytes = 0
+		result.Parts = nil
+		for _, output := range outputs {
+			data, err := os.ReadFile(output)
+			if err != nil {
+				return err
+			}
+			whole.Write(data)
+			result.Bytes += int64(len(data))
+			if len(outputs) > 1 {
+				sum := sha256.Sum256(data)
+				result.Parts = append(result.Parts, PartChecksum{File: filepath.Base(output), Bytes: int64(len(data)), SHA256: "sha256:" + hex.EncodeToString(sum[:])})
+			}
+		}
+		result.SHA256 = "sha256:" + hex.EncodeToString(whole.Sum(nil))
+	}
+	return nil
+}
+
+// zipOutputs returns the files a skill's zip is distributed as: the zip
+// itself, or its parts in order once -split-size has split it. It returns
+// nil when there is neither.
+func zipOutputs(zipPath string) ([]string, error) {
+	_, err := os.Stat(zipPath)
+	if err == nil {
+		return []string{zipPath}, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	parts, err := readSplitParts(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []string
+	for _, part := range parts.Parts {
+		outputs = append(outputs, filepath.Join(filepath.Dir(zipPath), part.File))
+	}
+	return outputs, nil
+}
+
+// totalZipSize sums the sizes of the zips for every skill that did not
+// fail, including zips kept by -resume.
+func totalZipSize(stats *PackageStats, opts *PackageOptions) (int64, error) {
+	var total int64
+	for _, result := range stats.Results {
+		if result.Status == statusFailed {
+			continue
+		}
+		// A split zip is measured by its parts
+		outputs, err := zipOutputs(filepath.Join(opts.OutputDir, packagedSkillName(result.Plugin, result.Skill, opts)+".zip"))
+		if err != nil {
+			return 0, err
+		}
+		for _, output := range outputs {
+			info, err := os.Stat(output)
+			if err != nil {
+				return 0, err
+			}
+			total += info.Size()
+		}
+	}
+	return total, nil
+}
+
+// compareBaseline reads an earlier JSON report and marks each skill as
+// new, changed, or unchanged against it, recording skills the baseline has
+// that this run did not produce as removed.
+func compareBaseline(baselinePath string, stats *PackageStats) error {
+	data, err := os.ReadFile(baselinePath)
+	if err != nil {
+		return err
+	}
+	var report struct {
+		Skills []SkillResult `json:"skills"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", baselinePath, err)
+	}
+
+	previous := make(map[string]string)
+	for _, result := range report.Skills {
+		if result.SHA256 != "" {
+			previous[result.Plugin+"/"+result.Skill] = result.SHA256
+		}
+	}
+
+	fmt.Fprintf(stdout, "\n%s=== Changes since baseline ===%s\n", colorBlue, colorReset)
+	current := make(map[string]bool)
+	counts := make(map[string]int)
+	for i := range stats.Results {
+		result := &stats.Results[i]
+		if result.SHA256 == "" {
+			continue
+		}
+		key := result.Plugin + "/" + result.Skill
+		current[key] = true
+		switch sum, ok := previous[key]; {
+		case !ok:
+			result.Change = "new"
+		case sum != result.SHA256:
+			result.Change = "changed"
+		default:
+			result.Change = "unchanged"
+		}
+		counts[result.Change]++
+		fmt.Fprintf(stdout, "%-9s %s\n", result.Change, key)
+	}
+
+	stats.Removed = []string{}
+	for key := range previous {
+		if !current[key] {
+			stats.Removed = append(stats.Removed, key)
+		}
+	}
+	sort.Strings(stats.Removed)
+	for _, key := range stats.Removed {
+		fmt.Fprintf(stdout, "%-9s %s\n", "removed", key)
+	}
+
+	fmt.Fprintf(stdout, "%s%d new, %d changed, %d unchanged, %d removed%s\n", colorBlue, counts["new"], counts["changed"], counts["unchanged"], len(stats.Removed), colorReset)
+	return nil
+}
+
+// resolveNameCollisions finds skills that would be packaged under the same
+// name and, depending on -on-collision, fails or records a distinct name for
+// each in opts.NameOverrides so zips, manifests, and orphan detection all
+// agree. With "prefix" every colliding skill takes its plugin prefix; with
+// "suffix" the first keeps the name and later ones get -2, -3, and so on.
+func resolveNameCollisions(marketplace *MarketplaceConfig, opts *PackageOptions) error {
+	type skillRef struct{ plugin, skill string }
+	var names []string
+	owners := make(map[string][]skillRef)
+	for _, plugin := range marketplace.Plugins {
+		for _, skillPath := range plugin.Skills {
+			ref := skillRef{plugin.Name, filepath.Base(skillPath)}
+			name := packagedSkillName(ref.plugin, ref.skill, opts)
+			if owners[name] == nil {
+				names = append(names, name)
+			}
+			owners[name] = append(owners[name], ref)
+		}
+	}
+
+	taken := make(map[string]bool)
+	for _, name := range names {
+		taken[name] = true
+	}
+
+	overrides := make(map[string]string)
+	for _, name := range names {
+		refs := owners[name]
+		if len(refs) < 2 {
+			continue
+		}
+
+		var skills []string
+		for _, ref := range refs {
+			skills = append(skills, ref.plugin+"/"+ref.skill)
+		}
+		switch opts.OnCollision {
+		case "fail":
+			return fmt.Errorf("skills %s would all be packaged as %q (use -prefix or -on-collision)", strings.Join(skills, ", "), name)
+
+		case "prefix":
+			prefixed := *opts
+			prefixed.UsePrefix = true
+			for i, ref := range refs {
+				newName := packagedSkillName(ref.plugin, ref.skill, &prefixed)
+				if taken[newName] && newName != name {
+					return fmt.Errorf("cannot resolve collision on %q: %s is already taken", name, newName)
+				}
+				taken[newName] = true
+				overrides[skills[i]] = newName
+				fmt.Fprintf(stdout, "%s[WARN]%s %s collides on %q, packaging as %s\n", colorYellow, colorReset, skills[i], name, newName)
+			}
+
+		case "suffix":
+			next := 2
+			for i, ref := range refs[1:] {
+				newName := fmt.Sprintf("%s-%d", name, next)
+				for taken[newName] {
+					next++
+					newName = fmt.Sprintf("%s-%d", name, next)
+				}
+				next++
+				taken[newName] = true
+				overrides[skills[i+1]] = newName
+				fmt.Fprintf(stdout, "%s[WARN]%s %s collides with %s on %q, packaging as %s\n", colorYellow, colorReset, ref.plugin+"/"+ref.skill, skills[0], name, newName)
+			}
+		}
+	}
+
+	opts.NameOverrides = overrides
+	return nil
+}
+
+// zipEntryRoot returns the directory skill files are placed under inside
+// the zip: the packaged skill name, or the archive root with -no-root-prefix.
+func zipEntryRoot(packagedName string, opts *PackageOptions) string {
+	if opts.NoRootPrefix {
+		return ""
+	}
+	return packagedName
+}
+
+// stripEntryPrefix removes -strip-prefix from the start of relPath. Paths
+// outside the prefix are left as they are. relPath is already clean and
+// relative, so what remains can never escape the archive root.
+func stripEntryPrefix(relPath string, opts *PackageOptions) (string, error) {
+	if opts.StripPrefix == "" {
+		return relPath, nil
+	}
+	if relPath == opts.StripPrefix {
+		return "", fmt.Errorf("stripping %q from %s leaves an empty path", opts.StripPrefix, relPath)
+	}
+	if rest, ok := strings.CutPrefix(relPath, opts.StripPrefix+"/"); ok {
+		return rest, nil
+	}
+	return relPath, nil
+}
+
+// longPath returns an absolute path in its extended-length form on
+// Windows, so paths beyond the 260 character MAX_PATH limit can still be
+// created. Relative paths, paths already in that form, and every path on
+// other platforms are returned unchanged.
+func longPath(p string) string {
+	if runtime.GOOS != "windows" || !filepath.IsAbs(p) || strings.HasPrefix(p, `\\?\`) {
+		return p
+	}
+	// Windows does not normalize extended-length paths, so clean first
+	p = filepath.Clean(p)
+	if strings.HasPrefix(p, `\\`) {
+		return `\\?\UNC\` + p[2:]
+	}
+	return `\\?\` + p
+}
+
+// packagedSkillName returns the name used for a skill's zip file and
+// archive root, with the plugin prefix applied when requested.
+func packagedSkillName(pluginName, skillName string, opts *PackageOptions) string {
+	if name, ok := opts.NameOverrides[pluginName+"/"+skillName]; ok {
+		return name
+	}
+	name := skillName
+	if opts.UsePrefix {
+		name = fmt.Sprintf("%s-%s", pluginName, skillName)
+	}
+	name = applyNameCase(name, opts.NameCase)
+	if opts.SanitizeNames {
+		return slugifyName(name)
+	}
+	return normalizePath(name)
+}
+
+// applyNameCase converts name to a -name-case style: "lower" lowercases
+// it, "kebab" also splits camelCase words and turns underscores and spaces
+// into hyphens, and "preserve" leaves it unchanged.
+func applyNameCase(name, nameCase string) string {
+	switch nameCase {
+	case "lower":
+		return strings.ToLower(name)
+	case "kebab":
+		return kebabCase(name)
+	}
+	return name
+}
+
+// kebabCase lowercases name with hyphens between words, treating case
+// changes, underscores, and spaces as word boundaries. A run of capitals is
+// kept as one word, so "HTTPServer" becomes "http-server".
+func kebabCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	hyphen := true // suppresses leading and repeated hyphens
+	for i, r := range runes {
+		if r == '_' || r == ' ' || r == '-' {
+			if !hyphen {
+				b.WriteRune('-')
+				hyphen = true
+			}
+			continue
+		}
+		if unicode.IsUpper(r) && !hyphen && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteRune('-')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+		hyphen = false
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// slugifyName lowercases name, turns spaces and underscores into hyphens,
+// and drops anything other than ASCII letters, digits, '.', and '-', so the
+// result is safe as a file name on any filesystem.
+func slugifyName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.':
+			b.WriteRune(r)
+		case r == '-' || r == ' ' || r == '_':
+			if s := b.String(); s != "" && !strings.HasSuffix(s, "-") {
+				b.WriteRune('-')
+			}
+		}
+	}
+	return strings.Trim(b.String(), "-.")
+}
+
+// checkSanitizedNames reports each skill whose sanitized or re-cased name
+// differs from its original name (under verbose) and fails if the change
+// leaves a name empty or gives two skills the same name.
+func checkSanitizedNames(marketplace *MarketplaceConfig, original, sanitized func(pluginName, skillName string) string, verbose bool) error {
+	owners := make(map[string]string)
+	for _, plugin := range marketplace.Plugins {
+		for _, skillPath := range plugin.Skills {
+			skillName := filepath.Base(skillPath)
+			skill := plugin.Name + "/" + skillName
+			before, after := original(plugin.Name, skillName), sanitized(plugin.Name, skillName)
+			if after == "" {
+				return fmt.Errorf("%s has no usable characters in its name", skill)
+			}
+			if owner, ok := owners[after]; ok {
+				return fmt.Errorf("%s and %s would both be named %q", owner, skill, after)
+			}
+			owners[after] = skill
+			if verbose && before != after {
+				fmt.Fprintf(stdout, "%s[RENAMED]%s %s -> %s\n", colorBlue, colorReset, before, after)
+			}
+		}
+	}
+	return nil
+}
+
+// checkOutputPaths fails when two skills would be written to the same
+// output path. Paths differing only by case share one path on macOS and
+// Windows but not elsewhere, so those clashes are returned as warnings for
+// the caller to report, or to treat as fatal under -strict.
+func checkOutputPaths(marketplace *MarketplaceConfig, outputPath func(pluginName, skillName string) string) ([]string, error) {
+	type owner struct{ skill, path string }
+	exact := make(map[string]string)
+	folded := make(map[string]owner)
+	var caseClashes []string
+	for _, plugin := range marketplace.Plugins {
+		for _, skillPath := range plugin.Skills {
+			skillName := filepath.Base(skillPath)
+			skill := plugin.Name + "/" + skillName
+			path := outputPath(plugin.Name, skillName)
+			if first, ok := exact[path]; ok {
+				return nil, fmt.Errorf("%s and %s would both be written to %s", first, skill, path)
+			}
+			exact[path] = skill
+			first, ok := folded[strings.ToLower(path)]
+			if !ok {
+				folded[strings.ToLower(path)] = owner{skill, path}
+				continue
+			}
+			caseClashes = append(caseClashes, fmt.Sprintf("%s and %s would be written to %s and %s, the same path on case-insensitive filesystems", first.skill, skill, first.path, path))
+		}
+	}
+	return caseClashes, nil
+}
+
+// purgeOrphanZips removes zip files in the output directory that do not
+// belong to any skill in the marketplace, along with their sidecar files
+// (<name>.zip.<ext>). Only recognised artifacts are considered, so unrelated
+// files in the output directory are never touched.
+func purgeOrphanZips(marketplace *MarketplaceConfig, opts *PackageOptions, stats *PackageStats) error {
+	expected := make(map[string]bool)
+	for _, plugin := range marketplace.Plugins {
+		for _, skillPath := range plugin.Skills {
+			expected[packagedSkillName(plugin.Name, filepath.Base(skillPath), opts)+".zip"] = true
+		}
+	}
+
+	entries, err := os.ReadDir(opts.OutputDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "\n%s=== Purging orphaned zip files ===%s\n", colorBlue, colorReset)
+
+	// A split zip's parts and parts list belong to the zip they were split
+	// from, so each orphaned skill is handled once
+	var orphans []string
+	files := make(map[string][]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || expected[name] {
+			continue
+		}
+		owner := name
+		if whole, ok := splitPartOf(name); ok {
+			owner = whole
+		} else if strings.HasSuffix(name, splitPartsName) {
+			owner = strings.TrimSuffix(name, splitPartsName) + ".zip"
+		} else if filepath.Ext(name) != ".zip" {
+			continue
+		}
+		if expected[owner] {
+			continue
+		}
+		if _, ok := files[owner]; !ok {
+			orphans = append(orphans, owner)
+		}
+		files[owner] = append(files[owner], name)
+	}
+
+	for _, name := range orphans {
+		var targets []string
+		seen := make(map[string]bool)
+		for _, file := range append([]string{name}, files[name]...) {
+			for _, entry := range entries {
+				target := entry.Name()
+				if !entry.IsDir() && !seen[target] && (target == file || strings.HasPrefix(target, file+".")) {
+					seen[target] = true
+					targets = append(targets, target)
+				}
+			}
+		}
+
+		if !opts.DryRun && !confirm(fmt.Sprintf("Remove orphaned %s?", name), opts) {
+			fmt.Fprintf(stdout, "%s[SKIP]%s Kept %s\n", colorYellow, colorReset, name)
+			continue
+		}
+
+		for _, target := range targets {
+			if opts.DryRun {
+				fmt.Fprintf(stdout, "%s[DRY RUN]%s Would remove: %s\n", colorYellow, colorReset, target)
+				continue
+			}
+			if err := os.Remove(filepath.Join(opts.OutputDir, target)); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", target, err)
+			}
+			fmt.Fprintf(stdout, "%s[PURGED]%s %s\n", colorGreen, colorReset, target)
+		}
+		stats.OrphansPurged++
+	}
+
+	if len(orphans) == 0 {
+		fmt.Fprintf(stdout, "No orphaned zip files found\n")
+	}
+
+	return nil
+}
+
+// stdinReader is shared by all prompts so buffered input is not lost
+// between them.
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// confirm asks a yes/no question before a destructive action. -assume-yes
+// answers yes without asking; when stdin is not a terminal nobody can
+// answer, so the safe choice (no) is taken instead of blocking.
+func confirm(question string, opts *PackageOptions) bool {
+	if opts.AssumeYes {
+		return true
+	}
+
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		fmt.Fprintf(stdout, "%s[WARN]%s %s Not confirmed (no terminal; use -assume-yes)\n", colorYellow, colorReset, question)
+		return false
+	}
+
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := stdinReader.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// effectiveConfig is what -print-config shows: the resolved PackageOptions
+// plus the run-level settings main handles itself. Secrets are reported only
+// as being set.
+type effectiveConfig struct {
+	Marketplace string `json:"marketplace"`
+	PolicyFile  string `json:"policy_file,omitempty"`
+	// FrontmatterSchema is the -frontmatter-schema path.
+	FrontmatterSchema string `json:"frontmatter_schema,omitempty"`
+	// Environment holds the variables read by loadEnv, secrets redacted.
+	Environment map[string]string `json:"environment,omitempty"`
+	*PackageOptions
+	DryRunFull   bool   `json:"dry_run_full"`
+	Lenient      bool   `json:"lenient"`
+	MaxTotalSize int64  `json:"max_total_size"`
+	Timeout      string `json:"timeout"`
+	Lockfile     string `json:"lockfile,omitempty"`
+	SignKey      string `json:"sign_key,omitempty"`
+	ZipPassword  bool   `json:"zip_password"`
+	JSONOut      string `json:"json_out,omitempty"`
+	JUnitOut     string `json:"junit_out,omitempty"`
+	ResultFile   string `json:"result_file,omitempty"`
+	HTMLIndex    string `json:"html_index,omitempty"`
+	Baseline     string `json:"baseline,omitempty"`
+}
+
+// SkillManifest lists the contents of a packaged skill zip.
+type SkillManifest struct {
+	Marketplace string            `json:"marketplace"`
+	Plugin      string            `json:"plugin"`
+	Skill       string            `json:"skill"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Files       []ManifestFile    `json:"files"`
+	// SkillMarkdown is set by -gzip-stats.
+	SkillMarkdown *SkillMarkdownSize `json:"skill_md,omitempty"`
+}
+
+// SkillMarkdownSize reports how large a skill's SKILL.md is on its own,
+// for sizing catalogs that serve many skill descriptions. It is separate
+// from the compression used inside the zip.
+type SkillMarkdownSize struct {
+	Size     int64 `json:"size"`
+	GzipSize int64 `json:"gzip_size"`
+}
+
+// skillMarkdownSize measures SKILL.md before and after gzip at the best
+// compression level.
+func skillMarkdownSize(source SkillSource) (*SkillMarkdownSize, error) {
+	data, err := source.ReadFile("SKILL.md")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SKILL.md: %w", err)
+	}
+
+	var compressed bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&compressed, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return &SkillMarkdownSize{Size: int64(len(data)), GzipSize: int64(compressed.Len())}, nil
+}
+
+// ManifestFile describes a single zip entry in a SkillManifest.
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Binary bool   `json:"binary"`
+}
+
+// writeSkillManifest writes the manifest as a sidecar of the zip, so it is
+// cleaned up along with the zip by -purge-orphans.
+func writeSkillManifest(zipPath string, manifest SkillManifest, format JSONFormat) error {
+	if manifest.Files == nil {
+		manifest.Files = []ManifestFile{}
+	}
+	data, err := marshalJSON(manifest, format)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(zipPath+".manifest.json", append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// readPrivateKey loads a PKCS#8 PEM ed25519 private key, as produced by
+// "openssl genpkey -algorithm ed25519". Keys readable by group or others are
+// rejected, like ssh does.
+func readPrivateKey(keyPath string) (ed25519.PrivateKey, error) {
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("%s is accessible by other users (mode %04o); run chmod 600 %s", keyPath, info.Mode().Perm(), keyPath)
+	}
+
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s is not a PEM \"PRIVATE KEY\" file", keyPath)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", keyPath, err)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 key", keyPath)
+	}
+	return privateKey, nil
+}
+
+// readPublicKey loads a PKIX PEM ed25519 public key, as produced by
+// "openssl pkey -pubout".
+func readPublicKey(keyPath string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%s is not a PEM \"PUBLIC KEY\" file", keyPath)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", keyPath, err)
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 key", keyPath)
+	}
+	return publicKey, nil
+}
+
+// signZip writes a detached signature of the zip's bytes to <zip>.sig as a
+// single line of standard base64.
+func signZip(zipPath string, key ed25519.PrivateKey) error {
+	data, err := os.ReadFile(zipPath)
+	if err != nil {
+		return err
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+	if err := os.WriteFile(zipPath+".sig", []byte(signature+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write signature: %w", err)
+	}
+	return nil
+}
+
+// verifyZipSignatures checks every zip in dir against its .sig sidecar,
+// printing a line per zip, and returns how many passed and failed.
+func verifyZipSignatures(dir string, key ed25519.PublicKey) (int, int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	verified, failed := 0, 0
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".zip" {
+			continue
+		}
+		zipPath := filepath.Join(dir, entry.Name())
+		if err := verifyZipSignature(zipPath, key); err != nil {
+			fmt.Fprintf(stdout, "%s[ERROR]%s %s: %v\n", colorRed, colorReset, entry.Name(), err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(stdout, "%s[VERIFIED]%s %s\n", colorGreen, colorReset, entry.Name())
+		verified++
+	}
+	return verified, failed, nil
+}
+
+func verifyZipSignature(zipPath string, key ed25519.PublicKey) error {
+	encoded, err := os.ReadFile(zipPath + ".sig")
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return errors.New("no signature")
+		}
+		return err
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return fmt.Errorf("malformed signature: %w", err)
+	}
+	data, err := os.ReadFile(zipPath)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, data, signature) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+// zipPasswordEnv is read for the zip password when -zip-password is not
+// given, keeping it out of shell history.
+const zipPasswordEnv = "PACKAGE_SKILLS_ZIP_PASSWORD"
+
+// envPrefix starts the name of every environment variable package-skills
+// reads. Secrets come from the environment rather than flags, which show up
+// in process listings.
+const envPrefix = "PACKAGE_SKILLS_"
+
+// envVar documents one environment variable read through loadEnv.
+type envVar struct {
+	Name   string
+	Secret bool
+}
+
+// envVars lists every environment variable package-skills reads.
+var envVars = []envVar{
+	{Name: zipPasswordEnv, Secret: true},
+}
+
+// loadEnv reads the variables in envVars that are set. It also returns any
+// other variable starting with envPrefix, which is most likely a typo.
+func loadEnv() (map[string]string, []string) {
+	known := make(map[string]bool)
+	env := make(map[string]string)
+	for _, v := range envVars {
+		known[v.Name] = true
+		if value, ok := os.LookupEnv(v.Name); ok {
+			env[v.Name] = value
+		}
+	}
+
+	var unknown []string
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		if strings.HasPrefix(name, envPrefix) && !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return env, unknown
+}
+
+// redactEnv returns env with the values of secret variables replaced, for
+// -print-config.
+func redactEnv(env map[string]string) map[string]string {
+	redacted := make(map[string]string, len(env))
+	for name, value := range env {
+		redacted[name] = value
+	}
+	for _, v := range envVars {
+		if _, ok := redacted[v.Name]; ok && v.Secret {
+			redacted[v.Name] = "[redacted]"
+		}
+	}
+	return redacted
+}
+
+// WinZip AES (AE-2) parameters. Entries use compression method 99 and an
+// extra field naming the real method; the data is salt, password
+// verifier, ciphertext, then a truncated HMAC-SHA1 of the ciphertext.
+const (
+	zipMethodAES   = 99
+	aesExtraID     = 0x9901
+	aesStrength256 = 3
+	aesKeySize     = 32
+	aesSaltSize    = 16
+	aesVerifySize  = 2
+	aesAuthSize    = 10
+	aesIterations  = 1000
+)
+
+// addEncryptedFileToZip adds a file encrypted with WinZip AES-256.
+// archive/zip cannot encrypt, so the data is compressed and encrypted here
+// and written as a raw entry.
+func addEncryptedFileToZip(zipWriter *zip.Writer, file SourceFile, zipPath string, level int, password string) (bool, error) {
+	srcFile, err := file.Open()
+	if err != nil {
+		return false, err
+	}
+	defer srcFile.Close()
+
+	data, err := io.ReadAll(srcFile)
+	if err != nil {
+		return false, err
+	}
+	sniffer := &binarySniffer{}
+	sniffer.Write(data)
+
+	method := uint16(zip.Store)
+	payload := data
+	if level != flate.NoCompression {
+		method = zip.Deflate
+		var compressed bytes.Buffer
+		writer, err := flate.NewWriter(&compressed, level)
+		if err != nil {
+			return false, err
+		}
+		if _, err := writer.Write(data); err != nil {
+			return false, err
+		}
+		if err := writer.Close(); err != nil {
+			return false, err
+		}
+		payload = compressed.Bytes()
+	}
+
+	name, err := safeEntryName(zipPath)
+	if err != nil {
+		return false, err
+	}
+
+	encrypted, err := encryptZipEntry(payload, password)
+	if err != nil {
+		return false, err
+	}
+
+	// AE-2 leaves the CRC at zero; the HMAC authenticates the data instead
+	header := &zip.FileHeader{
+		Name:               name,
+		Method:             zipMethodAES,
+		Flags:              0x1, // encrypted
+		CreatorVersion:     51,
+		ReaderVersion:      51,
+		Modified:           file.ModTime,
+		CompressedSize64:   uint64(len(encrypted)),
+		UncompressedSize64: uint64(len(data)),
+		Extra:              aesExtraField(method),
+	}
+	// CreateRaw writes the header as given, so fill in what CreateHeader
+	// would otherwise derive
+	header.ModifiedDate, header.ModifiedTime = msDosTime(file.ModTime)
+	for i := 0; i < len(header.Name); i++ {
+		if header.Name[i] >= utf8.RuneSelf {
+			header.Flags |= 0x800 // UTF-8 name
+			break
+		}
+	}
+	header.SetMode(file.Mode)
+
+	writer, err := zipWriter.CreateRaw(header)
+	if err != nil {
+		return false, err
+	}
+	if _, err := writer.Write(encrypted); err != nil {
+		return false, err
+	}
+	return sniffer.binary, nil
+}
+
+// aesExtraField builds the AE-2 extra field for an entry whose data was
+// compressed with method before encryption.
+func aesExtraField(method uint16) []byte {
+	extra := make([]byte, 11)
+	binary.LittleEndian.PutUint16(extra[0:], aesExtraID)
+	binary.LittleEndian.PutUint16(extra[2:], 7)
+	binary.LittleEndian.PutUint16(extra[4:], 2) // AE-2
+	copy(extra[6:], "AE")
+	extra[8] = aesStrength256
+	binary.LittleEndian.PutUint16(extra[9:], method)
+	return extra
+}
+
+// aesExtraMethod returns the compression method recorded in an entry's
+// AE-2 extra field.
+func aesExtraMethod(extra []byte) (uint16, bool) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:])
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		if id == aesExtraID && size == 7 && extra[8] == aesStrength256 {
+			return binary.LittleEndian.Uint16(extra[9:]), true
+		}
+		extra = extra[4+size:]
+	}
+	return 0, false
+}
+
+// aesKeys derives the encryption key, authentication key, and password
+// verifier for a salt.
+func aesKeys(password string, salt []byte) (encKey, authKey, verifier []byte, err error) {
+	keys, err := pbkdf2.Key(sha1.New, password, salt, aesIterations, 2*aesKeySize+aesVerifySize)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return keys[:aesKeySize], keys[aesKeySize : 2*aesKeySize], keys[2*aesKeySize:], nil
+}
+
+func encryptZipEntry(data []byte, password string) ([]byte, error) {
+	salt := make([]byte, aesSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	encKey, authKey, verifier, err := aesKeys(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext := make([]byte, len(data))
+	winzipCTR(block, ciphertext, data)
+	mac := hmac.New(sha1.New, authKey)
+	mac.Write(ciphertext)
+
+	out := make([]byte, 0, aesSaltSize+aesVerifySize+len(ciphertext)+aesAuthSize)
+	out = append(out, salt...)
+	out = append(out, verifier...)
+	out = append(out, ciphertext...)
+	return append(out, mac.Sum(nil)[:aesAuthSize]...), nil
+}
+
+func decryptZipEntry(data []byte, password string) ([]byte, error) {
+	if len(data) < aesSaltSize+aesVerifySize+aesAuthSize {
+		return nil, errors.New("encrypted data is truncated")
+	}
+	salt := data[:aesSaltSize]
+	verifier := data[aesSaltSize : aesSaltSize+aesVerifySize]
+	ciphertext := data[aesSaltSize+aesVerifySize : len(data)-aesAuthSize]
+	auth := data[len(data)-aesAuthSize:]
+
+	encKey, authKey, expected, err := aesKeys(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(verifier, expected) {
+		return nil, errors.New("wrong password")
+	}
+	mac := hmac.New(sha1.New, authKey)
+	mac.Write(ciphertext)
+	if !hmac.Equal(auth, mac.Sum(nil)[:aesAuthSize]) {
+		return nil, errors.New("authentication code does not match")
+	}
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	winzipCTR(block, plaintext, ciphertext)
+	return plaintext, nil
+}
+
+// winzipCTR applies AES in counter mode as WinZip defines it: the counter
+// starts at 1 and is incremented as a little-endian integer, unlike the
+// big-endian counter of cipher.NewCTR.
+func winzipCTR(block cipher.Block, dst, src []byte) {
+	var counter, stream [aes.BlockSize]byte
+	for i := 0; i < len(src); i += aes.BlockSize {
+		for j := range counter {
+			counter[j]++
+			if counter[j] != 0 {
+				break
+			}
+		}
+		block.Encrypt(stream[:], counter[:])
+		n := min(aes.BlockSize, len(src)-i)
+		subtle.XORBytes(dst[i:i+n], src[i:i+n], stream[:n])
+	}
+}
+
+// msDosTime converts t to the MS-DOS date and time used in zip headers,
+// which cannot represent anything before 1980.
+func msDosTime(t time.Time) (uint16, uint16) {
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, t.Location())
+	}
+	date := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	clock := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, clock
+}
+
+// verifyZipPasswords checks that every zip in dir is encrypted and opens
+// with password, returning how many passed and failed.
+func verifyZipPasswords(dir, password string) (int, int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	verified, failed := 0, 0
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".zip" {
+			continue
+		}
+		if err := verifyZipPassword(filepath.Join(dir, entry.Name()), password); err != nil {
+			fmt.Fprintf(stdout, "%s[ERROR]%s %s: %v\n", colorRed, colorReset, entry.Name(), err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(stdout, "%s[VERIFIED]%s %s\n", colorGreen, colorReset, entry.Name())
+		verified++
+	}
+	return verified, failed, nil
+}
+
+// verifyZipPassword decrypts and decompresses every entry of a zip,
+// checking each against its recorded size.
+func verifyZipPassword(zipPath, password string) error {
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for _, file := range reader.File {
+		if strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+		method, ok := aesExtraMethod(file.Extra)
+		if file.Method != zipMethodAES || !ok {
+			return fmt.Errorf("%s is not AES-256 encrypted", file.Name)
+		}
+
+		raw, err := file.OpenRaw()
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(raw)
+		if err != nil {
+			return err
+		}
+		plaintext, err := decryptZipEntry(data, password)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file.Name, err)
+		}
+
+		switch method {
+		case zip.Store:
+		case zip.Deflate:
+			plaintext, err = io.ReadAll(flate.NewReader(bytes.NewReader(plaintext)))
+			if err != nil {
+				return fmt.Errorf("%s: %w", file.Name, err)
+			}
+		default:
+			return fmt.Errorf("%s uses unsupported compression method %d", file.Name, method)
+		}
+		if uint64(len(plaintext)) != file.UncompressedSize64 {
+			return fmt.Errorf("%s: expected %d bytes, got %d", file.Name, file.UncompressedSize64, len(plaintext))
+		}
+	}
+	return nil
+}
+
+// binarySniffSize is how much of each file is checked for NUL bytes when
+// deciding whether it is binary.
+const binarySniffSize = 8000
+
+// binarySniffer watches the start of a stream for NUL bytes.
+type binarySniffer struct {
+	seen   int
+	binary bool
+}
+
+func (s *binarySniffer) Write(p []byte) (int, error) {
+	if s.seen < binarySniffSize && !s.binary {
+		head := p
+		if len(head) > binarySniffSize-s.seen {
+			head = head[:binarySniffSize-s.seen]
+		}
+		s.binary = bytes.IndexByte(head, 0) >= 0
+		s.seen += len(head)
+	}
+	return len(p), nil
+}
+
+// compressionLevels maps each -compression setting to a flate level;
+// NoCompression means entries are stored.
+var compressionLevels = map[string]int{
+	"store":   flate.NoCompression,
+	"fast":    flate.BestSpeed,
+	"default": flate.DefaultCompression,
+	"best":    flate.BestCompression,
+}
+
+// skillCompression returns the compression setting for a skill: the
+// "compression" frontmatter key when it holds a known value, otherwise the
+// global -compression setting.
+func skillCompression(source SkillSource, opts *PackageOptions) (string, error) {
+	frontmatter, err := readFrontmatter(source)
+	if err != nil {
+		return "", err
+	}
+	compression := frontmatter.String("compression")
+	if compression == "" {
+		return opts.Compression, nil
+	}
+	if _, ok := compressionLevels[compression]; !ok {
+		fmt.Fprintf(stdout, "%s[WARN]%s Unknown compression %q in %s, using %s\n", colorYellow, colorReset, compression, source.Location(), opts.Compression)
+		return opts.Compression, nil
+	}
+	return compression, nil
+}
+
+// safeEntryName returns zipPath as a relative, forward-slash zip entry
+// name. Extractors disagree on how to treat absolute paths, ".." elements,
+// drive letters and backslashes, and some will write outside the target
+// directory, so an entry with any of them is refused rather than guessed
+// at. Redundant "." elements and repeated slashes are cleaned away.
+func safeEntryName(zipPath string) (string, error) {
+	name := filepath.ToSlash(zipPath)
+	var problem string
+	switch {
+	case strings.Contains(name, `\`):
+		problem = "contains a backslash"
+	case strings.HasPrefix(name, "/"):
+		problem = "is absolute"
+	case len(name) >= 2 && name[1] == ':':
+		problem = "starts with a drive letter"
+	default:
+		for _, element := range strings.Split(name, "/") {
+			if element == ".." {
+				problem = "contains a .. element"
+				break
+			}
+		}
+	}
+	if problem != "" {
+		return "", fmt.Errorf("unsafe zip entry name %q %s", zipPath, problem)
+	}
+	if name = path.Clean(name); name == "." {
+		return "", fmt.Errorf("unsafe zip entry name %q is empty", zipPath)
+	}
+	return name, nil
+}
+
+// addFileToZip copies file into the zip at zipPath using the given method
+// and reports whether its content looks binary, sniffed as it is copied.
+func addFileToZip(zipWriter *zip.Writer, file SourceFile, zipPath string, method uint16) (bool, error) {
+	name, err := safeEntryName(zipPath)
+	if err != nil {
+		return false, err
+	}
+
+	// Open source file
+	srcFile, err := file.Open()
+	if err != nil {
+		return false, err
+	}
+	defer srcFile.Close()
+
+	// Create zip file header
+	header := &zip.FileHeader{
+		Name:               name,
+		Method:             method,
+		Modified:           file.ModTime,
+		UncompressedSize64: uint64(file.Size),
+	}
+	header.SetMode(file.Mode)
+
+	// Create writer for this file in zip
+	writer, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return false, err
+	}
+
+	// Copy file contents to zip
+	sniffer := &binarySniffer{}
+	if _, err := io.Copy(writer, io.TeeReader(srcFile, sniffer)); err != nil {
+		return false, err
+	}
+
+	return sniffer.binary, nil
+}
+
+// deflateSavings returns how many bytes storing file saves over deflating
+// it at level. It is negative when deflate would have been smaller.
+func deflateSavings(file SourceFile, level int) (int64, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	var counter byteCounter
+	writer, err := flate.NewWriter(&counter, level)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		return 0, err
+	}
+	if err := writer.Close(); err != nil {
+		return 0, err
+	}
+	return counter.n - file.Size, nil
+}
+
+// byteCounter is an io.Writer that only counts what is written to it.
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// checkDataFile parses a .json, .yaml or .yml file and returns why it is
+// invalid. Files with other extensions are not read.
+func checkDataFile(file SourceFile) error {
+	ext := strings.ToLower(path.Ext(file.RelPath))
+	if ext != ".json" && ext != ".yaml" && ext != ".yml" {
+		return nil
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	if ext == ".json" {
+		return checkJSON(data)
+	}
+	return checkYAML(data)
+}
+
+// checkJSON reports a JSON syntax error with the line it occurred on.
+func checkJSON(data []byte) error {
+	var value any
+	err := json.Unmarshal(data, &value)
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) && syntaxErr.Offset <= int64(len(data)) {
+		return fmt.Errorf("line %d: %w", 1+bytes.Count(data[:syntaxErr.Offset], []byte("\n")), err)
+	}
+	return err
+}
+
+// checkYAML catches the YAML mistake a stdlib-only script can detect
+// reliably: tabs in indentation, which YAML forbids. It is not a full
+// parser, so other errors are not reported.
+func checkYAML(data []byte) error {
+	for i, line := range strings.Split(string(data), "\n") {
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if strings.Contains(indent, "\t") && strings.TrimSpace(line) != "" {
+			return fmt.Errorf("line %d: tab used for indentation", i+1)
+		}
+	}
+	return nil
+}
+
+// suspiciousModeBits describes the permission bits in mode that have no
+// place in a distributed skill.
+func suspiciousModeBits(mode os.FileMode) []string {
+	var problems []string
+	// Windows reports every writable file as 0666, so the bit means nothing
+	if mode&0002 != 0 && runtime.GOOS != "windows" {
+		problems = append(problems, "world-writable")
+	}
+	if mode&os.ModeSetuid != 0 {
+		problems = append(problems, "setuid")
+	}
+	if mode&os.ModeSetgid != 0 {
+		problems = append(problems, "setgid")
+	}
+	return problems
+}
+
+// openSource returns the source for srcDir: a git ref, a zip archive, or
+// the working tree.
+func openSource(srcDir string, opts *PackageOptions) (SkillSource, error) {
+	if dirs, ok := opts.MergedSkills[srcDir]; ok {
+		return newMergedSource(dirs, opts)
+	}
+	if opts.GitRef != "" {
+		return newGitSource(srcDir, opts.GitRef)
+	}
+	if archive, inner, ok := splitZipPath(srcDir); ok {
+		return newZipSource(archive, inner)
+	}
+	root, err := resolveSkillRoot(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	return dirSource{root: root}, nil
+}
+
+// resolveSkillRoot checks that the skill directory srcDir exists and, when
+// it is a symlink, returns the directory it points to. Walks do not follow
+// a symlinked root, so without this a linked skill would look empty. A
+// broken link is reported as such rather than as a missing directory.
+func resolveSkillRoot(srcDir string) (string, error) {
+	info, err := os.Lstat(srcDir)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("source directory does not exist: %s", srcDir)
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return srcDir, nil
+	}
+
+	target, err := filepath.EvalSymlinks(srcDir)
+	if err != nil {
+		link, _ := os.Readlink(srcDir)
+		return "", fmt.Errorf("skill directory %s is a broken symlink to %s", srcDir, link)
+	}
+	if info, err := os.Stat(target); err != nil {
+		return "", err
+	} else if !info.IsDir() {
+		return "", fmt.Errorf("skill directory %s links to %s, which is not a directory", srcDir, target)
+	}
+	return target, nil
+}
+
+// openSkillSource returns the source to read a skill's files from, checking
+// that the skill exists, contains a SKILL.md, and has any required
+// subdirectories.
+func openSkillSource(srcDir string, opts *PackageOptions) (SkillSource, error) {
+	source, err := openSource(srcDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	if defaults, ok := opts.SkillDefaults[srcDir]; ok {
+		frontmatterDefaults[source.Location()] = defaults
+	}
+	if opts.HonorGitattributes {
+		if _, _, ok := splitZipPath(srcDir); !ok {
+			rules, err := loadExportIgnoreRules(srcDir)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read export-ignore rules for %s: %w", srcDir, err)
+			}
+			exportIgnoreRules[source.Location()] = rules
+		}
+	}
+
+	// Check if SKILL.md exists
+	found, err := source.Exists("SKILL.md")
+	if err != nil {
+		return nil, fmt.Errorf("failed to look for SKILL.md in %s: %w", source.Location(), err)
+	}
+	if !found {
+		return nil, fmt.Errorf("SKILL.md not found in %s", source.Location())
+	}
+
+	// Check required subdirectories
+	var missing []string
+	for _, dir := range opts.RequiredDirs {
+		found, err := source.DirExists(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look for %s/ in %s: %w", dir, source.Location(), err)
+		}
+		if !found {
+			missing = append(missing, dir+"/")
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("required directories missing in %s: %s", source.Location(), strings.Join(missing, ", "))
+	}
+
+	// Check required files
+	missing = nil
+	for _, file := range opts.RequiredFiles {
+		found, err := source.Exists(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look for %s in %s: %w", file, source.Location(), err)
+		}
+		if !found {
+			missing = append(missing, file)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("required files missing in %s: %s", source.Location(), strings.Join(missing, ", "))
+	}
+
+	return source, nil
+}
+
+// dirSource reads skill files from a directory in the working tree.
+type dirSource struct {
+	root string
+}
+
+func (s dirSource) Location() string {
+	return s.root
+}
+
+func (s dirSource) Exists(relPath string) (bool, error) {
+	_, err := os.Stat(filepath.Join(s.root, filepath.FromSlash(relPath)))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s dirSource) ReadFile(relPath string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.root, filepath.FromSlash(relPath)))
+}
+
+func (s dirSource) DirExists(relPath string) (bool, error) {
+	info, err := os.Stat(filepath.Join(s.root, filepath.FromSlash(relPath)))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return info.IsDir(), nil
+}
+
+func (s dirSource) Walk(fn func(file SourceFile) error) error {
+	return filepath.Walk(s.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip directories
+		if info.IsDir() {
+			return nil
+		}
+
+		// Follow symlinks so the archive contains the linked content
+		if info.Mode()&os.ModeSymlink != 0 {
+			if info, err = os.Stat(path); err != nil {
+				return err
+			}
+		}
+
+		// Get relative path from source directory
+		relPath, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+
+		return fn(SourceFile{
+			RelPath: normalizePath(filepath.ToSlash(relPath)),
+			Origin:  path,
+			Size:    info.Size(),
+			Mode:    info.Mode(),
+			ModTime: info.ModTime(),
+			Open: func() (io.ReadCloser, error) {
+				return os.Open(path)
+			},
+		})
+	})
+}
+
+// gitSource reads skill files from a git ref using git ls-tree and
+// git cat-file, leaving the working tree untouched.
+type gitSource struct {
+	repoRoot string
+	prefix   string // skill directory relative to repoRoot, slash separated
+	ref      string
+	modTime  time.Time
+	entries  []gitEntry
+}
+
+type gitEntry struct {
+	relPath string
+	mode    string
+	object  string
+	size    int64
+}
+
+func newGitSource(srcDir, ref string) (*gitSource, error) {
+	// The skill may not exist in the working tree at all, so find the
+	// repository from the nearest existing ancestor directory.
+	dir := existingAncestor(srcDir)
+
+	out, err := runGit(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, fmt.Errorf("%s is not inside a git repository: %w", srcDir, err)
+	}
+	repoRoot := strings.TrimSpace(string(out))
+
+	// Resolve symlinks so the prefix is computed consistently with git's view
+	resolvedDir := dir
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		resolvedDir = resolved
+	}
+	resolvedDir = filepath.Join(resolvedDir, strings.TrimPrefix(srcDir, dir))
+
+	prefix, err := filepath.Rel(repoRoot, resolvedDir)
+	if err != nil || prefix == ".." || strings.HasPrefix(prefix, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("%s is outside git repository %s", srcDir, repoRoot)
+	}
+
+	// Use the commit time of the ref as the timestamp for every file so the
+	// archive does not depend on working tree state
+	out, err = runGit(repoRoot, "log", "-1", "--format=%ct", ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve git ref %q: %w", ref, err)
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse commit time for %q: %w", ref, err)
+	}
+
+	source := &gitSource{
+		repoRoot: repoRoot,
+		prefix:   filepath.ToSlash(prefix),
+		ref:      ref,
+		modTime:  time.Unix(seconds, 0),
+	}
+	if err := source.load(); err != nil {
+		return nil, err
+	}
+	if len(source.entries) == 0 {
+		return nil, fmt.Errorf("source directory does not exist at %s: %s", ref, srcDir)
+	}
+
+	return source, nil
+}
+
+// load lists every blob under the skill directory at the configured ref.
+func (s *gitSource) load() error {
+	out, err := runGit(s.repoRoot, "ls-tree", "-r", "-l", "-z", "--full-tree", s.ref, "--", s.prefix+"/")
+	if err != nil {
+		return fmt.Errorf("failed to list %s at %s: %w", s.prefix, s.ref, err)
+	}
+
+	for _, record := range strings.Split(string(out), "\x00") {
+		if record == "" {
+			continue
+		}
+
+		// Format: <mode> SP <type> SP <object> SP+ <size> TAB <path>
+		meta, fullPath, ok := strings.Cut(record, "\t")
+		if !ok {
+			return fmt.Errorf("unexpected git ls-tree output: %q", record)
+		}
+		fields := strings.Fields(meta)
+		if len(fields) != 4 {
+			return fmt.Errorf("unexpected git ls-tree output: %q", record)
+		}
+
+		// Skip submodules and anything else that is not file content
+		if fields[1] != "blob" {
+			continue
+		}
+
+		size, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return fmt.Errorf("unexpected size in git ls-tree output: %q", record)
+		}
+
+		s.entries = append(s.entries, gitEntry{
+			relPath: strings.TrimPrefix(fullPath, s.prefix+"/"),
+			mode:    fields[0],
+			object:  fields[2],
+			size:    size,
+		})
+	}
+
+	return nil
+}
+
+func (s *gitSource) Location() string {
+	return fmt.Sprintf("%s at %s", filepath.Join(s.repoRoot, filepath.FromSlash(s.prefix)), s.ref)
+}
+
+func (s *gitSource) Exists(relPath string) (bool, error) {
+	for _, entry := range s.entries {
+		if entry.relPath == relPath {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DirExists reports whether any file lives under relPath. Git does not
+// track empty directories, so an empty directory is treated as missing.
+func (s *gitSource) DirExists(relPath string) (bool, error) {
+	for _, entry := range s.entries {
+		if strings.HasPrefix(entry.relPath, relPath+"/") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *gitSource) ReadFile(relPath string) ([]byte, error) {
+	for _, entry := range s.entries {
+		if entry.relPath == relPath {
+			return runGit(s.repoRoot, "cat-file", "blob", entry.object)
+		}
+	}
+	return nil, fmt.Errorf("%s not found in %s", relPath, s.Location())
+}
+
+func (s *gitSource) Walk(fn func(file SourceFile) error) error {
+	entries := append([]gitEntry(nil), s.entries...)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].relPath < entries[j].relPath
+	})
+
+	for _, entry := range entries {
+		var mode os.FileMode
+		switch entry.mode {
+		case "100644":
+			mode = 0644
+		case "100755":
+			mode = 0755
+		case "120000":
+			return fmt.Errorf("symlinks are not supported with -git-ref: %s", entry.relPath)
+		default:
+			return fmt.Errorf("unsupported git file mode %s: %s", entry.mode, entry.relPath)
+		}
+
+		object := entry.object
+		err := fn(SourceFile{
+			RelPath: normalizePath(entry.relPath),
+			Origin:  fmt.Sprintf("%s:%s", s.ref, path.Join(s.prefix, entry.relPath)),
+			Size:    entry.size,
+			Mode:    mode,
+			ModTime: s.modTime,
+			Open: func() (io.ReadCloser, error) {
+				data, err := runGit(s.repoRoot, "cat-file", "blob", object)
+				if err != nil {
+					return nil, err
+				}
+				return io.NopCloser(bytes.NewReader(data)), nil
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// zipSource reads skill files from a directory inside a zip archive, for
+// plugins whose Source is a .zip file rather than a directory.
+type zipSource struct {
+	archive string
+	prefix  string // skill directory inside the archive, slash separated
+	files   []*zip.File
+}
+
+// splitZipPath splits a path that runs through a .zip file into the archive
+// path and the slash-separated path inside it. ok is false when no parent
+// of p is a zip file.
+func splitZipPath(p string) (archive, inner string, ok bool) {
+	parts := strings.Split(filepath.ToSlash(p), "/")
+	for i := len(parts) - 1; i > 0; i-- {
+		if !strings.EqualFold(path.Ext(parts[i-1]), ".zip") {
+			continue
+		}
+		candidate := filepath.FromSlash(strings.Join(parts[:i], "/"))
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+			return candidate, strings.Join(parts[i:], "/"), true
+		}
+	}
+	return "", "", false
+}
+
+func newZipSource(archive, prefix string) (*zipSource, error) {
+	// Skill archives are small, so read the whole archive into memory rather
+	// than holding a file handle open for the lifetime of the source
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", archive, err)
+	}
+
+	source := &zipSource{archive: archive, prefix: strings.Trim(prefix, "/")}
+	for _, file := range reader.File {
+		if strings.HasSuffix(file.Name, "/") || !strings.HasPrefix(file.Name, source.prefix+"/") {
+			continue
+		}
+		source.files = append(source.files, file)
+	}
+	sort.Slice(source.files, func(i, j int) bool {
+		return source.files[i].Name < source.files[j].Name
+	})
+
+	if len(source.files) == 0 {
+		return nil, fmt.Errorf("source directory does not exist: %s", source.Location())
+	}
+
+	return source, nil
+}
+
+func (s *zipSource) Location() string {
+	return s.archive + "!/" + s.prefix
+}
+
+func (s *zipSource) find(relPath string) *zip.File {
+	for _, file := range s.files {
+		if file.Name == s.prefix+"/"+relPath {
+			return file
+		}
+	}
+	return nil
+}
+
+func (s *zipSource) Exists(relPath string) (bool, error) {
+	return s.find(relPath) != nil, nil
+}
+
+func (s *zipSource) DirExists(relPath string) (bool, error) {
+	for _, file := range s.files {
+		if strings.HasPrefix(file.Name, s.prefix+"/"+relPath+"/") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *zipSource) ReadFile(relPath string) ([]byte, error) {
+	file := s.find(relPath)
+	if file == nil {
+		return nil, fmt.Errorf("%s not found in %s", relPath, s.Location())
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func (s *zipSource) Walk(fn func(file SourceFile) error) error {
+	for _, file := range s.files {
+		file := file
+		err := fn(SourceFile{
+			RelPath: normalizePath(strings.TrimPrefix(file.Name, s.prefix+"/")),
+			Origin:  s.archive + "!/" + file.Name,
+			Size:    int64(file.UncompressedSize64),
+			Mode:    file.Mode(),
+			ModTime: file.Modified,
+			Open: func() (io.ReadCloser, error) {
+				return file.Open()
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergedSource layers the sources of a merged skill. A file in a later
+// layer replaces the file at the same path in earlier ones.
+type mergedSource struct {
+	layers []SkillSource
+}
+
+func newMergedSource(dirs []string, opts *PackageOptions) (*mergedSource, error) {
+	merged := &mergedSource{}
+	for _, dir := range dirs {
+		layer, err := openSource(dir, opts)
+		if err != nil {
+			return nil, err
+		}
+		merged.layers = append(merged.layers, layer)
+	}
+	return merged, nil
+}
+
+func (s *mergedSource) Location() string {
+	locations := make([]string, len(s.layers))
+	for i, layer := range s.layers {
+		locations[i] = layer.Location()
+	}
+	return strings.Join(locations, " + ")
+}
+
+func (s *mergedSource) Exists(relPath string) (bool, error) {
+	for _, layer := range s.layers {
+		if found, err := layer.Exists(relPath); err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+func (s *mergedSource) DirExists(relPath string) (bool, error) {
+	for _, layer := range s.layers {
+		if found, err := layer.DirExists(relPath); err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+func (s *mergedSource) ReadFile(relPath string) ([]byte, error) {
+	for i := len(s.layers) - 1; i >= 0; i-- {
+		found, err := s.layers[i].Exists(relPath)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return s.layers[i].ReadFile(relPath)
+		}
+	}
+	return nil, fmt.Errorf("%s not found in %s", relPath, s.Location())
+}
+
+func (s *mergedSource) Walk(fn func(file SourceFile) error) error {
+	files := make(map[string]SourceFile)
+	for _, layer := range s.layers {
+		err := layer.Walk(func(file SourceFile) error {
+			files[file.RelPath] = file
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	relPaths := make([]string, 0, len(files))
+	for relPath := range files {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Strings(relPaths)
+	for _, relPath := range relPaths {
+		if err := fn(files[relPath]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildInfoName is the file -build-info adds to each skill.
+const buildInfoName = ".build-info.json"
+
+// BuildInfo records the source state a skill was built from. The git
+// fields are omitted when the source is not in a git repository.
+type BuildInfo struct {
+	Commit    string    `json:"commit,omitempty"`
+	Branch    string    `json:"branch,omitempty"`
+	Dirty     *bool     `json:"dirty,omitempty"`
+	BuildTime time.Time `json:"build_time"`
+}
+
+// readBuildInfo describes the git state of ref in the repository containing
+// dir; an empty ref means the working tree. Dirty only covers dir and is
+// only checked for the working tree. On error the git fields are left
+// empty and BuildTime is still set.
+func readBuildInfo(dir, ref string, buildTime time.Time) (BuildInfo, error) {
+	info := BuildInfo{BuildTime: buildTime.UTC()}
+	if ref == "" {
+		ref = "HEAD"
+	}
+	commit, err := runGit(dir, "rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return info, err
+	}
+	dirty := false
+	if ref == "HEAD" {
+		status, err := runGit(dir, "status", "--porcelain", "--", ".")
+		if err != nil {
+			return info, err
+		}
+		dirty = len(bytes.TrimSpace(status)) > 0
+	}
+	info.Commit = strings.TrimSpace(string(commit))
+	info.Dirty = &dirty
+	// A detached HEAD has no branch to report
+	if branch, err := runGit(dir, "rev-parse", "--abbrev-ref", ref); err == nil && strings.TrimSpace(string(branch)) != "HEAD" {
+		info.Branch = strings.TrimSpace(string(branch))
+	}
+	return info, nil
+}
+
+// buildInfoFile generates the buildInfoName file for the skill at srcDir.
+// Missing git metadata is reported as a warning rather than failing the
+// skill.
+func buildInfoFile(srcDir, packagedName string, opts *PackageOptions) (SourceFile, error) {
+	// Run git somewhere that exists: the directory holding a zip source,
+	// a merged skill's last source, or for -git-ref the skill's nearest
+	// directory still in the working tree
+	dir := srcDir
+	if dirs, ok := opts.MergedSkills[srcDir]; ok {
+		dir = dirs[len(dirs)-1]
+	}
+	if archive, _, ok := splitZipPath(dir); ok {
+		dir = filepath.Dir(archive)
+	}
+	if opts.GitRef != "" {
+		dir = existingAncestor(dir)
+	}
+
+	info, err := readBuildInfo(dir, opts.GitRef, opts.BuildTime)
+	if err != nil {
+		fmt.Fprintf(stdout, "%s[WARN]%s %s: %s has no git metadata: %v\n", colorYellow, colorReset, packagedName, buildInfoName, err)
+	}
+	data, err := marshalJSON(info, opts.JSONFormat)
+	if err != nil {
+		return SourceFile{}, err
+	}
+	data = append(data, '\n')
+	return SourceFile{
+		RelPath: buildInfoName,
+		Origin:  "generated " + buildInfoName,
+		Size:    int64(len(data)),
+		Mode:    0644,
+		ModTime: opts.BuildTime,
+		Open: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		},
+	}, nil
+}
+
+// existingAncestor returns dir, or its nearest ancestor that exists. A
+// skill read from a git ref may be missing from the working tree, but git
+// can still be run from the directory above it.
+func existingAncestor(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// runGit runs git in dir and returns its stdout, including stderr in the
+// error when the command fails.
+func runGit(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
+
+// Frontmatter holds the YAML frontmatter of a SKILL.md file. Only the
+// subset of YAML that skills use is supported: plain or quoted scalars,
+// folded (>) and literal (|) block scalars, and flow ([a, b]) or block
+// (- a) lists of scalars. Nested maps, such as metadata, are skipped.
+type Frontmatter struct {
+	scalars map[string]string
+	lists   map[string][]string
+}
+
+// String returns the scalar value of key, or "" when it is not set.
+func (f *Frontmatter) String(key string) string {
+	return f.scalars[key]
+}
+
+// List returns the list value of key. A scalar value is returned as a
+// single-element list.
+func (f *Frontmatter) List(key string) []string {
+	if list, ok := f.lists[key]; ok {
+		return list
+	}
+	if value, ok := f.scalars[key]; ok && value != "" {
+		return []string{value}
+	}
+	return nil
+}
+
+// Has reports whether key is present.
+func (f *Frontmatter) Has(key string) bool {
+	_, isScalar := f.scalars[key]
+	_, isList := f.lists[key]
+	return isScalar || isList
+}
+
+// applyDefaults copies every key of defaults that f does not set.
+func (f *Frontmatter) applyDefaults(defaults *Frontmatter) {
+	for key, value := range defaults.scalars {
+		if !f.Has(key) {
+			f.scalars[key] = value
+		}
+	}
+	for key, list := range defaults.lists {
+		if !f.Has(key) {
+			f.lists[key] = append([]string(nil), list...)
+		}
+	}
+}
+
+// frontmatterCache holds the result of every readFrontmatter call in this
+// run, keyed by source location, so SKILL.md is read and parsed once per
+// skill and every consumer sees the same values.
+var frontmatterCache = map[string]frontmatterResult{}
+
+// frontmatterDefaults holds plugin skillDefaults keyed by the location of
+// each skill source they apply to. openSkillSource registers them and
+// readFrontmatter fills them in wherever the skill leaves a key unset.
+var frontmatterDefaults = map[string]*Frontmatter{}
+
+type frontmatterResult struct {
+	frontmatter *Frontmatter
+	err         error
+}
+
+// readFrontmatter reads and parses the frontmatter of a skill's SKILL.md,
+// reusing an earlier parse of the same source.
+func readFrontmatter(source SkillSource) (*Frontmatter, error) {
+	if cached, ok := frontmatterCache[source.Location()]; ok {
+		return cached.frontmatter, cached.err
+	}
+
+	var result frontmatterResult
+	data, err := source.ReadFile("SKILL.md")
+	if err != nil {
+		result.err = fmt.Errorf("failed to read SKILL.md: %w", err)
+	} else if result.frontmatter, err = parseFrontmatter(data); err != nil {
+		result.err = fmt.Errorf("invalid frontmatter in %s/SKILL.md: %w", source.Location(), err)
+	} else if defaults, ok := frontmatterDefaults[source.Location()]; ok {
+		result.frontmatter.applyDefaults(defaults)
+	}
+	frontmatterCache[source.Location()] = result
+	return result.frontmatter, result.err
+}
+
+// parseFrontmatter extracts the frontmatter block delimited by "---" lines
+// at the top of a markdown file. A file without frontmatter yields an empty
+// result.
+func parseFrontmatter(data []byte) (*Frontmatter, error) {
+	frontmatter := &Frontmatter{scalars: map[string]string{}, lists: map[string][]string{}}
+
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != "---" {
+		return frontmatter, nil
+	}
+
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "---" {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return nil, fmt.Errorf("missing closing --- delimiter")
+	}
+
+	body := lines[1:end]
+	for i := 0; i < len(body); i++ {
+		line := body[i]
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("line %d: unexpected indentation", i+2)
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", i+2)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		// Collect the indented lines that belong to this key
+		var block []string
+		for i+1 < len(body) && (strings.TrimSpace(body[i+1]) == "" || body[i+1][0] == ' ' || body[i+1][0] == '\t') {
+			i++
+			block = append(block, body[i])
+		}
+
+		switch {
+		case value == ">" || value == ">-" || value == "|" || value == "|-":
+			var parts []string
+			for _, l := range block {
+				parts = append(parts, strings.TrimSpace(l))
+			}
+			separator := " "
+			if value[0] == '|' {
+				separator = "\n"
+			}
+			frontmatter.scalars[key] = strings.TrimSpace(strings.Join(parts, separator))
+		case strings.HasPrefix(value, "["):
+			if !strings.HasSuffix(value, "]") {
+				return nil, fmt.Errorf("line %d: unterminated list for %q", i+2, key)
+			}
+			list := []string{}
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				if item = unquoteYAML(strings.TrimSpace(item)); item != "" {
+					list = append(list, item)
+				}
+			}
+			frontmatter.lists[key] = list
+		case value == "" && isNestedMap(block):
+			// Nested maps are valid frontmatter but nothing reads them
+			continue
+		case value == "" && len(block) > 0:
+			list := []string{}
+			for _, l := range block {
+				item := strings.TrimSpace(l)
+				if item == "" {
+					continue
+				}
+				if !strings.HasPrefix(item, "-") {
+					return nil, fmt.Errorf("expected list item under %q, got %q", key, item)
+				}
+				list = append(list, unquoteYAML(strings.TrimSpace(item[1:])))
+			}
+			frontmatter.lists[key] = list
+		default:
+			// Plain scalars may continue on indented lines
+			parts := []string{unquoteYAML(value)}
+			for _, l := range block {
+				if l = strings.TrimSpace(l); l != "" {
+					parts = append(parts, l)
+				}
+			}
+			frontmatter.scalars[key] = strings.Join(parts, " ")
+		}
+	}
+
+	return frontmatter, nil
+}
+
+// isNestedMap reports whether the indented block under a key holds
+// "key: value" lines rather than list items.
+func isNestedMap(block []string) bool {
+	for _, line := range block {
+		if line = strings.TrimSpace(line); line != "" {
+			return !strings.HasPrefix(line, "-") && strings.Contains(line, ":")
+		}
+	}
+	return false
+}
+
+// unquoteYAML strips matching single or double quotes from a scalar.
+func unquoteYAML(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// FileFilter restricts which files of a skill are packaged. A nil filter
+// includes every file.
+type FileFilter struct {
+	// patterns come from the frontmatter files list; nil includes every
+	// file.
+	patterns []string
+	// exportIgnore holds the .gitattributes rules read under
+	// -honor-gitattributes.
+	exportIgnore []exportIgnoreRule
+}
+
+// skillFileFilter builds the filter declared by the "files" frontmatter key.
+// It returns nil when the key is absent, and an error when the list does not
+// include SKILL.md.
+func skillFileFilter(source SkillSource) (*FileFilter, error) {
+	frontmatter, err := readFrontmatter(source)
+	if err != nil {
+		return nil, err
+	}
+	rules := exportIgnoreRules[source.Location()]
+	if !frontmatter.Has("files") {
+		if len(rules) > 0 {
+			return &FileFilter{exportIgnore: rules}, nil
+		}
+		return nil, nil
+	}
+
+	filter := &FileFilter{exportIgnore: rules}
+	for _, pattern := range frontmatter.List("files") {
+		pattern = strings.Trim(path.Clean(pattern), "/")
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in frontmatter files list: %w", pattern, err)
+		}
+		filter.patterns = append(filter.patterns, pattern)
+	}
+
+	if !filter.Includes("SKILL.md") {
+		return nil, fmt.Errorf("frontmatter files list in %s must include SKILL.md", source.Location())
+	}
+
+	return filter, nil
+}
+
+// Includes reports whether relPath, or any directory containing it, matches
+// one of the filter's patterns, and relPath is not export-ignored.
+func (f *FileFilter) Includes(relPath string) bool {
+	if f == nil {
+		return true
+	}
+	if f.ExportIgnored(relPath) {
+		return false
+	}
+	return f.patterns == nil || matchesPathPattern(f.patterns, relPath)
+}
+
+// ExportIgnored reports whether relPath is marked export-ignore. As in
+// git, the last matching rule wins, and rules in deeper .gitattributes
+// files come later.
+func (f *FileFilter) ExportIgnored(relPath string) bool {
+	if f == nil {
+		return false
+	}
+	ignored := false
+	for _, rule := range f.exportIgnore {
+		if rule.matches(relPath) {
+			ignored = rule.set
+		}
+	}
+	return ignored
+}
+
+// exportIgnoreRules holds the -honor-gitattributes rules for each skill
+// source, keyed by location. openSkillSource registers them and
+// skillFileFilter adds them to the skill's filter.
+var exportIgnoreRules = map[string][]exportIgnoreRule{}
+
+// exportIgnoreRule is one export-ignore line of a .gitattributes file,
+// positioned relative to the skill directory.
+type exportIgnoreRule struct {
+	pattern string
+	// set is false for -export-ignore and !export-ignore, which undo an
+	// earlier match.
+	set bool
+	// prefix is the path from the .gitattributes directory down to the
+	// skill, for files above the skill; within is the skill subdirectory
+	// holding it, for files inside. At most one is set.
+	prefix string
+	within string
+}
+
+// matches reports whether the rule applies to relPath, a slash-separated
+// path within the skill, or to any directory containing it.
+func (r exportIgnoreRule) matches(relPath string) bool {
+	if r.within != "" {
+		rest, ok := strings.CutPrefix(relPath, r.within+"/")
+		if !ok {
+			return false
+		}
+		relPath = rest
+	}
+	relPath = path.Join(r.prefix, relPath)
+
+	// A pattern without a slash matches a name at any depth; any other
+	// is anchored to the directory of its .gitattributes file
+	anchored := strings.Contains(r.pattern, "/")
+	pattern := strings.Split(strings.TrimPrefix(r.pattern, "/"), "/")
+	for candidate := relPath; candidate != "." && candidate != "/"; candidate = path.Dir(candidate) {
+		if !anchored {
+			if matched, _ := path.Match(r.pattern, path.Base(candidate)); matched {
+				return true
+			}
+		} else if matchPatternSegments(pattern, strings.Split(candidate, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPatternSegments matches a path against a pattern one segment at a
+// time with path.Match, where a "**" segment matches any number of
+// segments.
+func matchPatternSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchPatternSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	if matched, _ := path.Match(pattern[0], name[0]); !matched {
+		return false
+	}
+	return matchPatternSegments(pattern[1:], name[1:])
+}
+
+// loadExportIgnoreRules reads the export-ignore rules that apply to the
+// skill in srcDir: from every .gitattributes between the repository root
+// and the skill, shallowest first, then from those inside the skill. The
+// root is the git work tree containing the skill, or else the current
+// directory.
+func loadExportIgnoreRules(srcDir string) ([]exportIgnoreRule, error) {
+	root, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if out, err := runGit(srcDir, "rev-parse", "--show-toplevel"); err == nil {
+		root = strings.TrimSpace(string(out))
+	}
+	// git reports the root with symlinks resolved
+	skillDir := srcDir
+	if resolved, err := filepath.EvalSymlinks(srcDir); err == nil {
+		skillDir = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+
+	var dirs []string
+	if pathWithin(root, skillDir) {
+		for dir := filepath.Dir(skillDir); pathWithin(root, dir); dir = filepath.Dir(dir) {
+			dirs = append([]string{dir}, dirs...)
+			if dir == root {
+				break
+			}
+		}
+	}
+
+	var rules []exportIgnoreRule
+	for _, dir := range dirs {
+		prefix, err := filepath.Rel(dir, skillDir)
+		if err != nil {
+			return nil, err
+		}
+		fileRules, err := readExportIgnoreRules(filepath.Join(dir, ".gitattributes"))
+		if err != nil {
+			return nil, err
+		}
+		for _, rule := range fileRules {
+			rule.prefix = filepath.ToSlash(prefix)
+			rules = append(rules, rule)
+		}
+	}
+
+	err = filepath.WalkDir(skillDir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || entry.Name() != ".gitattributes" {
+			return nil
+		}
+		within, err := filepath.Rel(skillDir, filepath.Dir(file))
+		if err != nil {
+			return err
+		}
+		fileRules, err := readExportIgnoreRules(file)
+		if err != nil {
+			return err
+		}
+		for _, rule := range fileRules {
+			if within != "." {
+				rule.within = filepath.ToSlash(within)
+			}
+			rules = append(rules, rule)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// readExportIgnoreRules reads the export-ignore lines of a .gitattributes
+// file. Other attributes, macros and comments are skipped. A missing file
+// has no rules.
+func readExportIgnoreRules(file string) ([]exportIgnoreRule, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []exportIgnoreRule
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "[attr]") {
+			continue
+		}
+		for _, attr := range fields[1:] {
+			switch attr {
+			case "export-ignore":
+				rules = append(rules, exportIgnoreRule{pattern: fields[0], set: true})
+			case "-export-ignore", "!export-ignore":
+				rules = append(rules, exportIgnoreRule{pattern: fields[0]})
+			}
+		}
+	}
+	return rules, nil
+}
+
+// isExcluded reports whether relPath is left out by the exclude patterns.
+func isExcluded(relPath string, opts *PackageOptions) bool {
+	return matchesPathPattern(opts.Exclude, relPath)
+}
+
+// matchesPathPattern reports whether relPath, or any directory containing
+// it, matches one of patterns.
+func matchesPathPattern(patterns []string, relPath string) bool {
+	for candidate := relPath; candidate != "." && candidate != "/"; candidate = path.Dir(candidate) {
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, candidate); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SkillPolicy holds packaging defaults shared by a team, loaded from a
+// .skillpolicy file next to the marketplace. Command-line flags override it.
+type SkillPolicy struct {
+	MaxFileSize  int64    `json:"maxFileSize"`
+	Exclude      []string `json:"exclude"`
+	RequireDirs  []string `json:"requireDirs"`
+	RequireFiles []string `json:"requireFiles"`
+}
+
+// loadSkillPolicy reads the .skillpolicy file at the marketplace root: the
+// directory containing .claude-plugin/, or marketplace.json's own directory
+// when it lives elsewhere. It returns nil when there is no policy file.
+func loadSkillPolicy(marketplaceFile string) (*SkillPolicy, string, error) {
+	absPath, err := filepath.Abs(marketplaceFile)
+	if err != nil {
+		return nil, "", err
+	}
+	root := filepath.Dir(absPath)
+	if filepath.Base(root) == ".claude-plugin" {
+		root = filepath.Dir(root)
+	}
+	policyPath := filepath.Join(root, ".skillpolicy")
+
+	data, err := os.ReadFile(policyPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+
+	policy := &SkillPolicy{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(policy); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", policyPath, err)
+	}
+	if policy.MaxFileSize < 0 {
+		return nil, "", fmt.Errorf("%s: maxFileSize must not be negative", policyPath)
+	}
+	policy.RequireDirs = cleanPathList(policy.RequireDirs)
+	policy.RequireFiles = cleanPathList(policy.RequireFiles)
+	policy.Exclude = cleanPathList(policy.Exclude)
+	return policy, policyPath, nil
+}
+
+// splitPathList splits a comma-separated flag value into cleaned paths.
+func splitPathList(value string) []string {
+	return cleanPathList(strings.Split(value, ","))
+}
+
+// cleanPathList trims whitespace and surrounding slashes from each path,
+// dropping empty entries.
+func cleanPathList(paths []string) []string {
+	var cleaned []string
+	for _, p := range paths {
+		if p = strings.Trim(strings.TrimSpace(p), "/"); p != "" {
+			cleaned = append(cleaned, p)
+		}
+	}
+	return cleaned
+}
+
+// latinCompositions lists, for each combining mark, pairs of a base
+// character followed by the precomposed character it forms with that mark.
+// It covers the Latin blocks (U+00C0–U+024F, U+1E00–U+1EFF), which is where
+// macOS's decomposed file names differ from everything else in practice.
+var latinCompositions = map[rune]string{
+	// grave accent
+	0x0300: "AÀEÈIÌOÒUÙaàeèiìoòuùÜǛüǜNǸnǹĒḔēḕŌṐōṑWẀwẁÂẦâầĂẰăằÊỀêềÔỒôồƠỜơờƯỪưừYỲyỳ",
+	// acute accent
+	0x0301: "AÁEÉIÍOÓUÚYÝaáeéiíoóuúyýCĆcćLĹlĺNŃnńRŔrŕSŚsśZŹzźÜǗüǘGǴgǵÅǺåǻÆǼæǽØǾøǿÇḈçḉĒḖēḗÏḮïḯKḰkḱMḾmḿÕṌõṍŌṒōṓPṔpṕŨṸũṹWẂwẃÂẤâấĂẮăắÊẾêếÔỐôốƠỚơớƯỨưứ",
+	// circumflex accent
+	0x0302: "AÂEÊIÎOÔUÛaâeêiîoôuûCĈcĉGĜgĝHĤhĥJĴjĵSŜsŝWŴwŵYŶyŷZẐzẑẠẬạậẸỆẹệỌỘọộ",
+	// tilde
+	0x0303: "AÃNÑOÕaãnñoõIĨiĩUŨuũVṼvṽÂẪâẫĂẴăẵEẼeẽÊỄêễÔỖôỗƠỠơỡƯỮưữYỸyỹ",
+	// macron
+	0x0304: "AĀaāEĒeēIĪiīOŌoōUŪuūÜǕüǖÄǞäǟȦǠȧǡÆǢæǣǪǬǫǭÖȪöȫÕȬõȭȮȰȯȱYȲyȳGḠgḡḶḸḷḹṚṜṛṝ",
+	// breve
+	0x0306: "AĂaăEĔeĕGĞgğIĬiĭOŎoŏUŬuŭȨḜȩḝẠẶạặ",
+	// dot above
+	0x0307: "CĊcċEĖeėGĠgġIİZŻzżAȦaȧOȮoȯBḂbḃDḊdḋFḞfḟHḢhḣMṀmṁNṄnṅPṖpṗRṘrṙSṠsṡŚṤśṥŠṦšṧṢṨṣṩTṪtṫWẆwẇXẊxẋYẎyẏſẛ",
+	// diaeresis
+	0x0308: "AÄEËIÏOÖUÜaäeëiïoöuüyÿYŸHḦhḧÕṎõṏŪṺūṻWẄwẅXẌxẍtẗ",
+	// hook above
+	0x0309: "AẢaảÂẨâẩĂẲăẳEẺeẻÊỂêểIỈiỉOỎoỏÔỔôổƠỞơởUỦuủƯỬưửYỶyỷ",
+	// ring above
+	0x030A: "AÅaåUŮuůwẘyẙ",
+	// double acute accent
+	0x030B: "OŐoőUŰuű",
+	// caron
+	0x030C: "CČcčDĎdďEĚeěLĽlľNŇnňRŘrřSŠsšTŤtťZŽzžAǍaǎIǏiǐOǑoǒUǓuǔÜǙüǚGǦgǧKǨkǩƷǮʒǯjǰHȞhȟ",
+	// double grave accent
+	0x030F: "AȀaȁEȄeȅIȈiȉOȌoȍRȐrȑUȔuȕ",
+	// inverted breve
+	0x0311: "AȂaȃEȆeȇIȊiȋOȎoȏRȒrȓUȖuȗ",
+	// horn
+	0x031B: "OƠoơUƯuư",
+	// dot below
+	0x0323: "BḄbḅDḌdḍHḤhḥKḲkḳLḶlḷMṂmṃNṆnṇRṚrṛSṢsṣTṬtṭVṾvṿWẈwẉZẒzẓAẠaạEẸeẹIỊiịOỌoọƠỢơợUỤuụƯỰưựYỴyỵ",
+	// diaeresis below
+	0x0324: "UṲuṳ",
+	// ring below
+	0x0325: "AḀaḁ",
+	// comma below
+	0x0326: "SȘsșTȚtț",
+	// cedilla
+	0x0327: "CÇcçGĢgģKĶkķLĻlļNŅnņRŖrŗSŞsşTŢtţEȨeȩDḐdḑHḨhḩ",
+	// ogonek
+	0x0328: "AĄaąEĘeęIĮiįUŲuųOǪoǫ",
+	// circumflex accent below
+	0x032D: "DḒdḓEḘeḙLḼlḽNṊnṋTṰtṱUṶuṷ",
+	// breve below
+	0x032E: "HḪhḫ",
+	// tilde below
+	0x0330: "EḚeḛIḬiḭUṴuṵ",
+	// macron below
+	0x0331: "BḆbḇDḎdḏKḴkḵLḺlḻNṈnṉRṞrṟTṮtṯZẔzẕhẖ",
+}
+
+// compositions maps a base character and combining mark to their
+// precomposed form; built from latinCompositions.
+var compositions = func() map[[2]rune]rune {
+	table := make(map[[2]rune]rune)
+	for mark, pairs := range latinCompositions {
+		runes := []rune(pairs)
+		for i := 0; i+1 < len(runes); i += 2 {
+			table[[2]rune{runes[i], mark}] = runes[i+1]
+		}
+	}
+	return table
+}()
+
+// normalizePath composes decomposed Latin characters in a path, so that zip
+// entries and source hashes are identical whether a skill was checked out
+// on macOS (NFD file names) or elsewhere (NFC). The scripts are stdlib-only,
+// so this is a table of Latin compositions rather than full normalization
+// via golang.org/x/text: other scripts pass through unchanged.
+func normalizePath(p string) string {
+	ascii := true
+	for i := 0; i < len(p); i++ {
+		if p[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return p
+	}
+
+	out := make([]rune, 0, len(p))
+	for _, r := range p {
+		if n := len(out); n > 0 {
+			if composed, ok := compositions[[2]rune{out[n-1], r}]; ok {
+				out[n-1] = composed
+				continue
+			}
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+func printHeader(title string) {
+	fmt.Fprintln(stdout)
+	fmt.Fprintf(stdout, "%s╔═══════════════════════════════════════════════════════╗%s\n", colorBlue, colorReset)
+	fmt.Fprintf(stdout, "%s║%s  %-50s %s║%s\n", colorBlue, colorReset, title, colorBlue, colorReset)
+	fmt.Fprintf(stdout, "%s╚═══════════════════════════════════════════════════════╝%s\n", colorBlue, colorReset)
+	fmt.Fprintln(stdout)
+}
+
+func printSummary(stats *PackageStats, outputDir string, dryRun, tempOutput bool) {
+	printPluginSummary(stats)
+	printLargestFiles(stats)
+	printTooManyFiles(stats)
+
+	fmt.Fprintln(stdout)
+	fmt.Fprintf(stdout, "%s╔═══════════════════════════════════════════════════════╗%s\n", colorGreen, colorReset)
+	fmt.Fprintf(stdout, "%s║%s  %-50s %s║%s\n", colorGreen, colorReset, "Summary", colorGreen, colorReset)
+	fmt.Fprintf(stdout, "%s╚═══════════════════════════════════════════════════════╝%s\n", colorGreen, colorReset)
+
+	if dryRun {
+		fmt.Fprintf(stdout, "\n%sDry run completed - no files were created%s\n", colorYellow, colorReset)
+	}
+	if tempOutput {
+		fmt.Fprintf(stdout, "\n%sFull dry run completed - temporary zip files were removed%s\n", colorYellow, colorReset)
+	}
+
+	fmt.Fprintf(stdout, "\n%sSkills packaged:%s   %d\n", colorBlue, colorReset, stats.SkillsPackaged)
+	if stats.SkillsResumed > 0 {
+		fmt.Fprintf(stdout, "%sSkills resumed:%s    %d\n", colorBlue, colorReset, stats.SkillsResumed)
+	}
+	if stats.SkillsUnchanged > 0 {
+		fmt.Fprintf(stdout, "%sSkills unchanged:%s  %d\n", colorBlue, colorReset, stats.SkillsUnchanged)
+	}
+	if stats.SkillsExcluded > 0 {
+		fmt.Fprintf(stdout, "%sSkills excluded:%s   %d\n", colorBlue, colorReset, stats.SkillsExcluded)
+	}
+	if stats.ZipsIdentical > 0 {
+		fmt.Fprintf(stdout, "%sZips identical:%s    %d\n", colorBlue, colorReset, stats.ZipsIdentical)
+	}
+	if stats.SkillsFailed > 0 {
+		fmt.Fprintf(stdout, "%sSkills failed:%s     %d\n", colorRed, colorReset, stats.SkillsFailed)
+	}
+	if stats.FilesFlagged > 0 {
+		fmt.Fprintf(stdout, "%sFiles flagged:%s     %d\n", colorYellow, colorReset, stats.FilesFlagged)
+	}
+	if stats.FilesInvalid > 0 {
+		fmt.Fprintf(stdout, "%sFiles invalid:%s     %d\n", colorRed, colorReset, stats.FilesInvalid)
+	}
+	if stats.CaseCollisions > 0 {
+		fmt.Fprintf(stdout, "%sCase collisions:%s   %d\n", colorYellow, colorReset, stats.CaseCollisions)
+	}
+	if !dryRun {
+		fmt.Fprintf(stdout, "%sFiles added:%s       %d\n", colorBlue, colorReset, stats.FilesAdded)
+		fmt.Fprintf(stdout, "%sZip files created:%s %d\n", colorBlue, colorReset, stats.SkillsPackaged)
+	}
+	if stats.SizeBudget > 0 {
+		color := colorBlue
+		if stats.TotalZipSize > stats.SizeBudget {
+			color = colorRed
+		}
+		fmt.Fprintf(stdout, "%sTotal zip size:%s    %s of %s budget\n", color, colorReset, formatBytes(stats.TotalZipSize), formatBytes(stats.SizeBudget))
+	}
+	if stats.OrphansPurged > 0 {
+		if dryRun {
+			fmt.Fprintf(stdout, "%sOrphans found:%s     %d\n", colorBlue, colorReset, stats.OrphansPurged)
+		} else {
+			fmt.Fprintf(stdout, "%sOrphans purged:%s    %d\n", colorBlue, colorReset, stats.OrphansPurged)
+		}
+	}
+	fmt.Fprintln(stdout)
+
+	if stats.SkillsPackaged > 0 && !dryRun && !tempOutput {
+		fmt.Fprintf(stdout, "%s✓ Successfully created %d zip files!%s\n", colorGreen, stats.SkillsPackaged, colorReset)
+		fmt.Fprintf(stdout, "  Location: %s\n\n", outputDir)
+	}
 }
 
-func main() {
-	// Parse command-line flags
-	outputDir := flag.String("output", ".dist", "Output directory for skill zip files")
-	marketplaceFile := flag.String("marketplace", "./.claude-plugin/marketplace.json", "Path to marketplace.json")
-	verbose := flag.Bool("verbose", false, "Enable verbose logging")
-	dryRun := flag.Bool("dry-run", false, "Perform a dry run without creating zip files")
-	usePrefix := flag.Bool("prefix", false, "Prefix skill names with plugin name (e.g., core-commit-messages)")
-	flag.Parse()
+// printPluginSummary prints a table of skill totals for each plugin, so a
+// problematic plugin stands out in a large run.
+func printPluginSummary(stats *PackageStats) {
+	if len(stats.Plugins) == 0 {
+		return
+	}
 
-	// Convert to absolute path
-	absOutputDir, err := filepath.Abs(*outputDir)
-	if err != nil {
-		fatal("Failed to resolve output path: %v", err)
+	names := make([]string, 0, len(stats.Plugins))
+	width := len("Plugin")
+	for name := range stats.Plugins {
+		names = append(names, name)
+		if len(name) > width {
+			width = len(name)
+		}
 	}
+	sort.Strings(names)
 
-	// Print configuration
-	printHeader("Package Skills to Zip Files")
-	fmt.Printf("%sOutput directory:%s %s\n", colorBlue, colorReset, absOutputDir)
-	if *dryRun {
-		fmt.Printf("%sDry run mode: No files will be created%s\n", colorYellow, colorReset)
+	fmt.Fprintf(stdout, "\n%s%-*s  %8s  %6s  %7s  %5s%s\n", colorBlue, width, "Plugin", "Packaged", "Failed", "Skipped", "Files", colorReset)
+	for _, name := range names {
+		p := stats.Plugins[name]
+		row := fmt.Sprintf("%-*s  %8d  %6d  %7d  %5d", width, name, p.SkillsPackaged, p.SkillsFailed, p.SkillsSkipped, p.FilesAdded)
+		if p.SkillsFailed > 0 {
+			row = colorRed + row + colorReset
+		}
+		fmt.Fprintln(stdout, row)
 	}
-	fmt.Println()
+}
 
-	// Read marketplace.json
-	marketplace, err := readMarketplace(*marketplaceFile)
-	if err != nil {
-		fatal("Failed to read marketplace.json: %v", err)
+// printLargestFiles lists the files recorded by -report-largest, so the
+// file bloating a distribution is easy to find.
+func printLargestFiles(stats *PackageStats) {
+	if len(stats.Largest) == 0 {
+		return
+	}
+	fmt.Fprintf(stdout, "\n%sLargest files:%s\n", colorBlue, colorReset)
+	for _, file := range stats.Largest {
+		fmt.Fprintf(stdout, "  %10s  %s (%s)\n", formatBytes(file.Size), file.Path, file.Skill)
 	}
+}
 
-	// Create output directory
-	stats := &PackageStats{}
-	if !*dryRun {
-		if err := os.MkdirAll(absOutputDir, 0755); err != nil {
-			fatal("Failed to create output directory: %v", err)
-		}
-		if err := createSkillZips(absOutputDir, marketplace, *verbose, *usePrefix, stats); err != nil {
-			fatal("Failed to create zip files: %v", err)
+// printTooManyFiles lists the skills that tripped -warn-file-count.
+func printTooManyFiles(stats *PackageStats) {
+	if len(stats.TooManyFiles) == 0 {
+		return
+	}
+	fmt.Fprintf(stdout, "\n%sSkills over the file-count warning:%s\n", colorYellow, colorReset)
+	for _, result := range stats.TooManyFiles {
+		fmt.Fprintf(stdout, "  %6d files  %s/%s\n", result.Files, result.Plugin, result.Skill)
+	}
+}
+
+// consoleReporter prints the human-readable summary box.
+type consoleReporter struct {
+	outputDir string
+	dryRun    bool
+	// tempOutput is set for -dry-run-full, whose output directory has
+	// already been removed.
+	tempOutput bool
+}
+
+func (r consoleReporter) Report(stats *PackageStats) error {
+	printSummary(stats, r.outputDir, r.dryRun, r.tempOutput)
+	return nil
+}
+
+// jsonReporter writes the run totals and per-skill results as JSON.
+type jsonReporter struct {
+	path        string
+	marketplace string
+	dryRun      bool
+	format      JSONFormat
+	// groupBy, sources and opts are as for htmlReporter, and add a
+	// groups list to the report unless groupBy is "none".
+	groupBy string
+	sources map[string]string
+	opts    *PackageOptions
+}
+
+// resultGroup is a section of the JSON report under -group-by.
+type resultGroup struct {
+	Name   string        `json:"name"`
+	Skills []SkillResult `json:"skills"`
+}
+
+func (r jsonReporter) Report(stats *PackageStats) error {
+	report := struct {
+		Marketplace    string                  `json:"marketplace"`
+		DryRun         bool                    `json:"dry_run"`
+		SkillsPackaged int                     `json:"skills_packaged"`
+		SkillsFailed   int                     `json:"skills_failed"`
+		FilesAdded     int                     `json:"files_added"`
+		Plugins        map[string]*PluginStats `json:"plugins"`
+		Skills         []SkillResult           `json:"skills"`
+		Removed        []string                `json:"removed,omitempty"`
+		Groups         []resultGroup           `json:"groups,omitempty"`
+	}{
+		Marketplace:    r.marketplace,
+		DryRun:         r.dryRun,
+		SkillsPackaged: stats.SkillsPackaged,
+		SkillsFailed:   stats.SkillsFailed,
+		FilesAdded:     stats.FilesAdded,
+		Plugins:        stats.Plugins,
+		Skills:         stats.Results,
+		Removed:        stats.Removed,
+	}
+	if report.Plugins == nil {
+		report.Plugins = map[string]*PluginStats{}
+	}
+	if report.Skills == nil {
+		report.Skills = []SkillResult{}
+	}
+	if r.groupBy != "none" && r.groupBy != "" {
+		plugins := make([]string, len(stats.Results))
+		tags := make([][]string, len(stats.Results))
+		for i, result := range stats.Results {
+			plugins[i] = result.Plugin
+			if frontmatter := catalogFrontmatter(r.sources[result.Plugin+"/"+result.Skill], r.opts); frontmatter != nil {
+				tags[i] = frontmatter.List("tags")
+			}
 		}
-	} else {
-		// Dry run - just validate skills
-		for _, plugin := range marketplace.Plugins {
-			validatePlugin(plugin, *verbose, *usePrefix, stats)
+		report.Groups = []resultGroup{}
+		for _, group := range groupIndexes(plugins, tags, r.groupBy) {
+			skills := make([]SkillResult, len(group.members))
+			for i, index := range group.members {
+				skills[i] = stats.Results[index]
+			}
+			report.Groups = append(report.Groups, resultGroup{Name: group.name, Skills: skills})
 		}
 	}
 
-	// Print summary
-	printSummary(stats, absOutputDir, *dryRun)
+	data, err := marshalJSON(report, r.format)
+	if err != nil {
+		return err
+	}
+	return writeReportFile(r.path, append(data, '\n'))
 }
 
-func readMarketplace(path string) (*MarketplaceConfig, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
+// resultFileReporter writes only the per-skill results, as an artifact for
+// CI matrix jobs that each package one skill: a single object when one
+// skill was processed, otherwise an array.
+type resultFileReporter struct {
+	path   string
+	format JSONFormat
+}
+
+func (r resultFileReporter) Report(stats *PackageStats) error {
+	var results interface{} = stats.Results
+	if len(stats.Results) == 1 {
+		results = stats.Results[0]
+	} else if stats.Results == nil {
+		results = []SkillResult{}
 	}
 
-	var config MarketplaceConfig
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, err
+	data, err := marshalJSON(results, r.format)
+	if err != nil {
+		return err
 	}
+	return writeReportFile(r.path, append(data, '\n'))
+}
 
-	return &config, nil
+// junitReporter writes a JUnit XML test suite with one test case per skill,
+// so CI systems can show packaging failures per skill.
+type junitReporter struct {
+	path string
 }
 
-func createSkillZips(outputDir string, marketplace *MarketplaceConfig, verbose bool, usePrefix bool, stats *PackageStats) error {
-	// Process each plugin
-	for _, plugin := range marketplace.Plugins {
-		if err := packagePluginSkills(plugin, outputDir, verbose, usePrefix, stats); err != nil {
-			fmt.Printf("%s[ERROR]%s Failed to package plugin '%s': %v\n", colorRed, colorReset, plugin.Name, err)
-			return err
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+func (r junitReporter) Report(stats *PackageStats) error {
+	suite := junitTestSuite{Name: "package-skills"}
+	var total time.Duration
+	for _, result := range stats.Results {
+		testCase := junitTestCase{
+			ClassName: result.Plugin,
+			Name:      result.Skill,
+			Time:      junitSeconds(result.Duration),
 		}
+		switch result.Status {
+		case statusFailed:
+			testCase.Failure = &junitFailure{Message: result.Error, Text: result.Error}
+			suite.Failures++
+		case statusSkipped:
+			testCase.Skipped = &junitSkipped{Message: result.Error}
+			suite.Skipped++
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+		total += result.Duration
 	}
+	suite.Tests = len(suite.TestCases)
+	suite.Time = junitSeconds(total)
 
-	return nil
+	data, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeReportFile(r.path, append([]byte(xml.Header), append(data, '\n')...))
 }
 
-func validatePlugin(plugin Plugin, verbose bool, usePrefix bool, stats *PackageStats) {
-	if len(plugin.Skills) == 0 {
-		if verbose {
-			fmt.Printf("%s[SKIP]%s Plugin '%s' has no skills\n", colorYellow, colorReset, plugin.Name)
+// htmlReporter writes a static HTML catalog of the packaged zips, with a
+// download link for each, so the output directory can be hosted as is.
+type htmlReporter struct {
+	path        string
+	marketplace string
+	// groupBy is the -group-by setting: "tag", "plugin" or "none".
+	groupBy string
+	// sources maps each skill, keyed as "plugin/skill", to its source
+	// directory, where its frontmatter is read from.
+	sources map[string]string
+	opts    *PackageOptions
+}
+
+// skillSourceDirs maps every skill in the marketplace, keyed as
+// "plugin/skill", to its absolute source directory.
+func skillSourceDirs(marketplace *MarketplaceConfig) map[string]string {
+	sources := make(map[string]string)
+	for _, plugin := range marketplace.Plugins {
+		for _, skillPath := range plugin.Skills {
+			skillName := filepath.Base(skillPath)
+			if srcDir, err := filepath.Abs(filepath.Join(plugin.Source, "skills", skillName)); err == nil {
+				sources[plugin.Name+"/"+skillName] = srcDir
+			}
 		}
-		return
 	}
+	return sources
+}
 
-	fmt.Printf("\n%s=== Validating plugin: %s ===%s\n", colorBlue, plugin.Name, colorReset)
+// catalogEntry is a single row of the HTML catalog.
+type catalogEntry struct {
+	Name        string
+	Plugin      string
+	Description string
+	Size        string
+	Link        string
+	// Parts links each part of a zip split by -split-size, in place of Link.
+	Parts []catalogPart
+	Tags  []string
+}
 
-	for _, skillPath := range plugin.Skills {
-		// Extract skill name from the path (e.g., "./skills/commit-messages" -> "commit-messages")
-		skillName := filepath.Base(skillPath)
+// catalogPart is the download link for one part of a split zip.
+type catalogPart struct {
+	Name string
+	Link string
+}
 
-		// Construct the actual path by combining plugin source with skills directory
-		actualSkillPath := filepath.Join(plugin.Source, "skills", skillName)
+// catalogGroup is a section of the HTML catalog. The one group of an
+// ungrouped catalog has no name.
+type catalogGroup struct {
+	Name   string
+	Skills []catalogEntry
+}
 
-		var packagedName string
-		if usePrefix {
-			packagedName = fmt.Sprintf("%s-%s", plugin.Name, skillName)
-		} else {
-			packagedName = skillName
-		}
+// untaggedGroup holds the skills without tags under -group-by tag.
+const untaggedGroup = "other"
 
-		srcDir, err := filepath.Abs(actualSkillPath)
-		if err != nil {
-			fmt.Printf("%s[ERROR]%s Failed to resolve %s: %v\n", colorRed, colorReset, actualSkillPath, err)
-			stats.SkillsFailed++
-			continue
-		}
+// catalogTemplate renders the HTML catalog. html/template escapes every
+// value for the context it appears in.
+var catalogTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Marketplace}} skills</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem auto; max-width: 60rem; padding: 0 1rem; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.5rem; text-align: left; vertical-align: top; }
+td.size { white-space: nowrap; }
+</style>
+</head>
+<body>
+<h1>{{.Marketplace}} skills</h1>
+<p>{{len .Skills}} skills</p>
+{{- range .Groups}}
+{{- if .Name}}
+<h2 id="{{.Name}}">{{.Name}}</h2>
+{{- end}}
+<table>
+<thead><tr><th>Skill</th><th>Plugin</th><th>Description</th><th>Size</th></tr></thead>
+<tbody>
+{{- range .Skills}}
+<tr><td>{{if .Parts}}{{.Name}}{{range .Parts}}<br><a href="{{.Link}}" download>{{.Name}}</a>{{end}}{{else}}<a href="{{.Link}}" download>{{.Name}}</a>{{end}}</td><td>{{.Plugin}}</td><td>{{.Description}}</td><td class="size">{{.Size}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+</body>
+</html>
+`))
+
+func (r htmlReporter) Report(stats *PackageStats) error {
+	indexDir, err := filepath.Abs(filepath.Dir(r.path))
+	if err != nil {
+		return err
+	}
 
-		if _, err := os.Stat(srcDir); os.IsNotExist(err) {
-			fmt.Printf("%s[ERROR]%s Source directory does not exist: %s\n", colorRed, colorReset, srcDir)
-			stats.SkillsFailed++
+	var entries []catalogEntry
+	for _, result := range stats.Results {
+		// Resumed skills were skipped but still have a zip
+		if result.Status == statusFailed {
 			continue
 		}
-
-		skillFile := filepath.Join(srcDir, "SKILL.md")
-		if _, err := os.Stat(skillFile); os.IsNotExist(err) {
-			fmt.Printf("%s[ERROR]%s SKILL.md not found in %s\n", colorRed, colorReset, srcDir)
-			stats.SkillsFailed++
+		packagedName := packagedSkillName(result.Plugin, result.Skill, r.opts)
+		outputs, err := zipOutputs(filepath.Join(r.opts.OutputDir, packagedName+".zip"))
+		if err != nil {
+			return err
+		}
+		var size int64
+		var parts []catalogPart
+		for _, output := range outputs {
+			info, err := os.Stat(output)
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+			link, err := filepath.Rel(indexDir, output)
+			if err != nil {
+				return err
+			}
+			parts = append(parts, catalogPart{Name: filepath.Base(output), Link: (&url.URL{Path: filepath.ToSlash(link)}).EscapedPath()})
+		}
+		if len(parts) == 0 {
 			continue
 		}
+		entry := catalogEntry{
+			Name:   packagedName,
+			Plugin: result.Plugin,
+			Size:   formatBytes(size),
+			Link:   parts[0].Link,
+		}
+		if len(parts) > 1 {
+			entry.Parts = parts
+		}
+		if frontmatter := catalogFrontmatter(r.sources[result.Plugin+"/"+result.Skill], r.opts); frontmatter != nil {
+			entry.Description = frontmatter.String("description")
+			entry.Tags = frontmatter.List("tags")
+		}
+		entries = append(entries, entry)
+	}
 
-		fmt.Printf("%s[DRY RUN]%s Would package: %s\n", colorYellow, colorReset, packagedName)
-		stats.SkillsPackaged++
+	var buf bytes.Buffer
+	err = catalogTemplate.Execute(&buf, struct {
+		Marketplace string
+		Skills      []catalogEntry
+		Groups      []catalogGroup
+	}{r.marketplace, entries, groupCatalog(entries, r.groupBy)})
+	if err != nil {
+		return err
 	}
+	return writeReportFile(r.path, buf.Bytes())
 }
 
-func packagePluginSkills(plugin Plugin, outputDir string, verbose bool, usePrefix bool, stats *PackageStats) error {
-	if len(plugin.Skills) == 0 {
-		if verbose {
-			fmt.Printf("%s[SKIP]%s Plugin '%s' has no skills\n", colorYellow, colorReset, plugin.Name)
-		}
-		return nil
+// groupCatalog sections the catalog entries for -group-by.
+func groupCatalog(entries []catalogEntry, groupBy string) []catalogGroup {
+	if groupBy == "none" {
+		return []catalogGroup{{Skills: entries}}
 	}
 
-	fmt.Printf("\n%s=== Packaging plugin: %s ===%s\n", colorBlue, plugin.Name, colorReset)
-
-	for _, skillPath := range plugin.Skills {
-		// Extract skill name from the path (e.g., "./skills/commit-messages" -> "commit-messages")
-		skillName := filepath.Base(skillPath)
+	plugins := make([]string, len(entries))
+	tags := make([][]string, len(entries))
+	for i, entry := range entries {
+		plugins[i] = entry.Plugin
+		tags[i] = entry.Tags
+	}
+	var groups []catalogGroup
+	for _, group := range groupIndexes(plugins, tags, groupBy) {
+		skills := make([]catalogEntry, len(group.members))
+		for i, index := range group.members {
+			skills[i] = entries[index]
+		}
+		groups = append(groups, catalogGroup{Name: group.name, Skills: skills})
+	}
+	return groups
+}
 
-		// Construct the actual path by combining plugin source with skills directory
-		actualSkillPath := filepath.Join(plugin.Source, "skills", skillName)
+// indexGroup is one -group-by group: its name and the indexes of its
+// members.
+type indexGroup struct {
+	name    string
+	members []int
+}
 
-		if err := packageSkillToZip(plugin.Name, actualSkillPath, outputDir, verbose, usePrefix, stats); err != nil {
-			fmt.Printf("%s[ERROR]%s Failed to package %s: %v\n", colorRed, colorReset, skillPath, err)
-			stats.SkillsFailed++
-		} else {
-			stats.SkillsPackaged++
+// groupIndexes groups skills, given by their plugins and tags, for
+// -group-by. By plugin the groups follow the order plugins were packaged
+// in. By tag they are sorted, a skill appears under each of its tags, and
+// untagged skills come last under untaggedGroup.
+func groupIndexes(plugins []string, tags [][]string, groupBy string) []indexGroup {
+	var names []string
+	members := make(map[string][]int)
+	add := func(name string, index int) {
+		if _, ok := members[name]; !ok {
+			names = append(names, name)
 		}
+		members[name] = append(members[name], index)
+	}
+	for i := range plugins {
+		if groupBy == "plugin" {
+			add(plugins[i], i)
+			continue
+		}
+		if len(tags[i]) == 0 {
+			add(untaggedGroup, i)
+		}
+		seen := make(map[string]bool)
+		for _, tag := range tags[i] {
+			if !seen[tag] {
+				seen[tag] = true
+				add(tag, i)
+			}
+		}
+	}
+	if groupBy == "tag" {
+		sort.SliceStable(names, func(i, j int) bool {
+			if (names[i] == untaggedGroup) != (names[j] == untaggedGroup) {
+				return names[j] == untaggedGroup
+			}
+			return names[i] < names[j]
+		})
 	}
 
-	return nil
+	groups := make([]indexGroup, len(names))
+	for i, name := range names {
+		groups[i] = indexGroup{name: name, members: members[name]}
+	}
+	return groups
 }
 
-func packageSkillToZip(pluginName, skillPath string, outputDir string, verbose bool, usePrefix bool, stats *PackageStats) error {
-	// Extract skill name from path
-	skillName := filepath.Base(skillPath)
-
-	// Create packaged skill name (with optional plugin prefix)
-	var packagedName string
-	if usePrefix {
-		packagedName = fmt.Sprintf("%s-%s", pluginName, skillName)
-	} else {
-		packagedName = skillName
+// catalogFrontmatter returns the frontmatter of the skill at srcDir, with
+// its plugin's skillDefaults filled in. A skill packaged in this run was
+// parsed then and comes from the cache; one kept by -resume is read from
+// its source now. The zip itself is never read, as it may be encrypted.
+func catalogFrontmatter(srcDir string, opts *PackageOptions) *Frontmatter {
+	if srcDir == "" {
+		return nil
+	}
+	source, err := openSkillSource(srcDir, opts)
+	if err != nil {
+		return nil
+	}
+	frontmatter, err := readFrontmatter(source)
+	if err != nil {
+		return nil
 	}
+	return frontmatter
+}
 
-	// Source path
-	srcDir, err := filepath.Abs(skillPath)
+// junitSeconds formats a duration the way JUnit expects: seconds with
+// millisecond precision.
+func junitSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// JSONFormat controls how generated JSON is encoded.
+type JSONFormat struct {
+	// Canonical sorts object keys recursively so the output is stable
+	// regardless of struct field order or map iteration.
+	Canonical bool `json:"canonical"`
+	// Compact writes each document on a single line.
+	Compact bool `json:"compact"`
+}
+
+// marshalJSON encodes v for writing to disk, with two-space indentation
+// unless format is compact.
+func marshalJSON(v interface{}, format JSONFormat) ([]byte, error) {
+	data, err := json.Marshal(v)
 	if err != nil {
-		return fmt.Errorf("failed to resolve source path: %w", err)
+		return nil, err
 	}
 
-	// Check if source exists
-	if _, err := os.Stat(srcDir); os.IsNotExist(err) {
-		return fmt.Errorf("source directory does not exist: %s", srcDir)
+	if format.Canonical {
+		// Round-trip through generic values; encoding/json writes map keys
+		// in sorted order. UseNumber keeps numbers exactly as first encoded.
+		var generic interface{}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&generic); err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(generic); err != nil {
+			return nil, err
+		}
 	}
 
-	// Check if SKILL.md exists
-	skillFile := filepath.Join(srcDir, "SKILL.md")
-	if _, err := os.Stat(skillFile); os.IsNotExist(err) {
-		return fmt.Errorf("SKILL.md not found in %s", srcDir)
+	if format.Compact {
+		return data, nil
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, data, "", "  "); err != nil {
+		return nil, err
 	}
+	return indented.Bytes(), nil
+}
 
-	// Create individual zip file for this skill
-	zipPath := filepath.Join(outputDir, fmt.Sprintf("%s.zip", packagedName))
-	zipFile, err := os.Create(zipPath)
-	if err != nil {
-		return fmt.Errorf("failed to create zip file: %w", err)
+// writeReportFile writes a report, creating its parent directory if needed.
+// A path of "-" writes it to stdout.
+func writeReportFile(path string, data []byte) error {
+	if path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
 	}
-	defer zipFile.Close()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
 
-	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
+// printProfile reports how the run's time was spent. Skills are processed
+// sequentially, so the report separates wall time from the time spent on
+// skills, making overhead outside the per-skill work (config loading,
+// directory creation) visible.
+func printProfile(stats *PackageStats, wall time.Duration) {
+	fmt.Fprintf(stdout, "%s╔═══════════════════════════════════════════════════════╗%s\n", colorBlue, colorReset)
+	fmt.Fprintf(stdout, "%s║%s  %-50s %s║%s\n", colorBlue, colorReset, "Profile", colorBlue, colorReset)
+	fmt.Fprintf(stdout, "%s╚═══════════════════════════════════════════════════════╝%s\n", colorBlue, colorReset)
 
-	if verbose {
-		fmt.Printf("  Creating %s.zip...\n", packagedName)
+	if len(stats.Results) == 0 {
+		fmt.Fprintf(stdout, "\nNo skills were processed\n\n")
+		return
 	}
 
-	// Add all files from skill directory to zip
-	fileCount := 0
-	err = filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	var busy, min, max time.Duration
+	for i, result := range stats.Results {
+		d := result.Duration
+		busy += d
+		if i == 0 || d < min {
+			min = d
 		}
-
-		// Skip directories
-		if info.IsDir() {
-			return nil
+		if d > max {
+			max = d
 		}
+	}
+	avg := busy / time.Duration(len(stats.Results))
 
-		// Get relative path from source directory
-		relPath, err := filepath.Rel(srcDir, path)
-		if err != nil {
-			return err
-		}
+	fmt.Fprintf(stdout, "\n%sSkills:%s            %d\n", colorBlue, colorReset, len(stats.Results))
+	fmt.Fprintf(stdout, "%sMin duration:%s      %s\n", colorBlue, colorReset, min.Round(time.Microsecond))
+	fmt.Fprintf(stdout, "%sMax duration:%s      %s\n", colorBlue, colorReset, max.Round(time.Microsecond))
+	fmt.Fprintf(stdout, "%sAvg duration:%s      %s\n", colorBlue, colorReset, avg.Round(time.Microsecond))
+	fmt.Fprintf(stdout, "%sTotal busy time:%s   %s\n", colorBlue, colorReset, busy.Round(time.Microsecond))
+	fmt.Fprintf(stdout, "%sTotal wall time:%s   %s\n", colorBlue, colorReset, wall.Round(time.Microsecond))
+	fmt.Fprintln(stdout)
+}
 
-		// Create path in zip with skill name as root
-		zipEntryPath := filepath.Join(packagedName, relPath)
+// logOut tees the run's stdout and stderr into -log-file; nil without it.
+var logOut *logOutput
 
-		// Add file to zip
-		if err := addFileToZip(zipWriter, path, zipEntryPath); err != nil {
-			return fmt.Errorf("failed to add %s: %w", relPath, err)
-		}
+// tempOutputDir is the -dry-run-full output directory, removed by exit so a
+// run that stops early does not leave it behind.
+var tempOutputDir string
 
-		fileCount++
-		if verbose {
-			fmt.Printf("    %s✓%s Added: %s\n", colorGreen, colorReset, zipEntryPath)
+// logOutput copies everything written to stdout and stderr into a log
+// file, each line timestamped and stripped of color codes, while the
+// console output stays as it is.
+type logOutput struct {
+	file     *rotatingLog
+	console  []*os.File
+	pipes    []*os.File
+	done     []chan struct{}
+	restored bool
+}
+
+// startLogOutput replaces os.Stdout and os.Stderr with pipes whose output
+// is written both to the console and to the log at path.
+func startLogOutput(path string, maxSize int64) (*logOutput, error) {
+	file := &rotatingLog{path: path, maxSize: maxSize}
+	if err := file.open(os.O_APPEND); err != nil {
+		return nil, err
+	}
+	out := &logOutput{file: file}
+	for _, stream := range []**os.File{&os.Stdout, &os.Stderr} {
+		r, w, err := os.Pipe()
+		if err != nil {
+			out.Close()
+			return nil, err
 		}
+		console := *stream
+		*stream = w
+		out.console = append(out.console, console)
+		out.pipes = append(out.pipes, w)
+		done := make(chan struct{})
+		out.done = append(out.done, done)
+		go func() {
+			defer close(done)
+			lines := &logLines{log: file}
+			io.Copy(console, io.TeeReader(r, lines))
+			lines.Flush()
+			r.Close()
+		}()
+	}
+	return out, nil
+}
 
-		return nil
-	})
+// Close restores the console streams, waits for everything already
+// written to reach the log, and closes it. It is safe on a nil logOutput.
+func (o *logOutput) Close() {
+	if o == nil {
+		return
+	}
+	o.restore()
+	o.file.Close()
+}
 
-	if err != nil {
-		return err
+// WriteLine logs a line that was written to the console directly. It is
+// safe on a nil logOutput.
+func (o *logOutput) WriteLine(line string) {
+	if o != nil {
+		o.file.WriteLine(line)
 	}
+}
 
-	stats.FilesAdded += fileCount
-	fmt.Printf("%s[PACKAGED]%s %s.zip (%d files added)\n", colorGreen, colorReset, packagedName, fileCount)
+// restore points os.Stdout and os.Stderr back at the console and waits
+// until the pipes have been drained, so output written before is not
+// overtaken by output written after.
+func (o *logOutput) restore() {
+	if o == nil || o.restored {
+		return
+	}
+	o.restored = true
+	if len(o.console) > 0 {
+		os.Stdout = o.console[0]
+	}
+	if len(o.console) > 1 {
+		os.Stderr = o.console[1]
+	}
+	for _, w := range o.pipes {
+		w.Close()
+	}
+	for _, done := range o.done {
+		<-done
+	}
+}
 
-	return nil
+// logLines buffers one stream's output so only whole lines reach the log,
+// keeping stdout and stderr lines from interleaving mid-line. Text ended by
+// a lone carriage return, such as the -progress bar, is overwritten on the
+// console and left out of the log.
+type logLines struct {
+	log     *rotatingLog
+	pending []byte
 }
 
-func addFileToZip(zipWriter *zip.Writer, srcPath, zipPath string) error {
-	// Open source file
-	srcFile, err := os.Open(srcPath)
-	if err != nil {
-		return err
+func (l *logLines) Write(p []byte) (int, error) {
+	l.pending = append(l.pending, p...)
+	for {
+		i := bytes.IndexAny(l.pending, "\r\n")
+		if i < 0 {
+			break
+		}
+		end := i + 1
+		if l.pending[i] == '\r' {
+			// Wait to see whether a newline follows
+			if end == len(l.pending) {
+				break
+			}
+			if l.pending[end] != '\n' {
+				l.pending = l.pending[end:]
+				continue
+			}
+			end++
+		}
+		if i > 0 {
+			l.log.WriteLine(string(l.pending[:i]))
+		}
+		l.pending = l.pending[end:]
 	}
-	defer srcFile.Close()
+	return len(p), nil
+}
 
-	// Get file info for permissions
-	info, err := srcFile.Stat()
-	if err != nil {
-		return err
+// Flush writes a final line that did not end in a newline.
+func (l *logLines) Flush() {
+	if len(l.pending) > 0 {
+		l.log.WriteLine(string(l.pending))
+		l.pending = nil
 	}
+}
 
-	// Create zip file header
-	header, err := zip.FileInfoHeader(info)
-	if err != nil {
+// rotatingLog appends timestamped lines to a file. Once the file would
+// grow past maxSize it is renamed to <path>.1, replacing any earlier
+// backup, and a fresh file is started; 0 never rotates.
+type rotatingLog struct {
+	path    string
+	maxSize int64
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+}
+
+func (l *rotatingLog) open(flag int) error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
 		return err
 	}
-
-	// Use forward slashes for zip paths (platform independent)
-	header.Name = filepath.ToSlash(zipPath)
-	header.Method = zip.Deflate
-
-	// Create writer for this file in zip
-	writer, err := zipWriter.CreateHeader(header)
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|flag, 0644)
 	if err != nil {
 		return err
 	}
-
-	// Copy file contents to zip
-	if _, err := io.Copy(writer, srcFile); err != nil {
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
 		return err
 	}
-
+	l.file, l.size = file, info.Size()
 	return nil
 }
 
-func printHeader(title string) {
-	fmt.Println()
-	fmt.Printf("%s╔═══════════════════════════════════════════════════════╗%s\n", colorBlue, colorReset)
-	fmt.Printf("%s║%s  %-50s %s║%s\n", colorBlue, colorReset, title, colorBlue, colorReset)
-	fmt.Printf("%s╚═══════════════════════════════════════════════════════╝%s\n", colorBlue, colorReset)
-	fmt.Println()
+// WriteLine logs line with the current time, without color codes.
+// Errors are dropped so a failing log never interrupts the run.
+func (l *rotatingLog) WriteLine(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return
+	}
+	entry := time.Now().Format(time.RFC3339) + " " + stripANSI(line) + "\n"
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(entry)) > l.maxSize {
+		l.file.Close()
+		l.file = nil
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return
+		}
+		if err := l.open(os.O_TRUNC); err != nil {
+			return
+		}
+	}
+	n, _ := l.file.WriteString(entry)
+	l.size += int64(n)
 }
 
-func printSummary(stats *PackageStats, outputDir string, dryRun bool) {
-	fmt.Println()
-	fmt.Printf("%s╔═══════════════════════════════════════════════════════╗%s\n", colorGreen, colorReset)
-	fmt.Printf("%s║%s  %-50s %s║%s\n", colorGreen, colorReset, "Summary", colorGreen, colorReset)
-	fmt.Printf("%s╚═══════════════════════════════════════════════════════╝%s\n", colorGreen, colorReset)
-
-	if dryRun {
-		fmt.Printf("\n%sDry run completed - no files were created%s\n", colorYellow, colorReset)
+func (l *rotatingLog) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
 	}
+}
 
-	fmt.Printf("\n%sSkills packaged:%s   %d\n", colorBlue, colorReset, stats.SkillsPackaged)
-	if stats.SkillsFailed > 0 {
-		fmt.Printf("%sSkills failed:%s     %d\n", colorRed, colorReset, stats.SkillsFailed)
+// stripANSI removes the color escape sequences used for console output.
+func stripANSI(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\033' && i+1 < len(s) && s[i+1] == '[' {
+			j := i + 2
+			for j < len(s) && (s[j] == ';' || (s[j] >= '0' && s[j] <= '9')) {
+				j++
+			}
+			if j < len(s) {
+				i = j
+				continue
+			}
+		}
+		b.WriteByte(s[i])
 	}
-	if !dryRun {
-		fmt.Printf("%sFiles added:%s       %d\n", colorBlue, colorReset, stats.FilesAdded)
-		fmt.Printf("%sZip files created:%s %d\n", colorBlue, colorReset, stats.SkillsPackaged)
+	return b.String()
+}
+
+// watchChildArgs returns the command line for a -watch-config re-run:
+// the current arguments without -watch-config, and without the log flags
+// since the watching process already writes the log.
+func watchChildArgs() []string {
+	var args []string
+	skipValue := false
+	for _, arg := range os.Args[1:] {
+		if skipValue {
+			skipValue = false
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") {
+			args = append(args, arg)
+			continue
+		}
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		switch name {
+		case "watch-config":
+			continue
+		case "log-file", "log-max-size":
+			skipValue = !hasValue
+			continue
+		}
+		args = append(args, arg)
 	}
-	fmt.Println()
+	return args
+}
 
-	if stats.SkillsPackaged > 0 && !dryRun {
-		fmt.Printf("%s✓ Successfully created %d zip files!%s\n", colorGreen, stats.SkillsPackaged, colorReset)
-		fmt.Printf("  Location: %s\n\n", outputDir)
+// exit ends the process with code once the log output has been written
+// and any temporary output directory removed.
+func exit(code int) {
+	if tempOutputDir != "" {
+		os.RemoveAll(tempOutputDir)
 	}
+	logOut.Close()
+	os.Exit(code)
 }
 
 func fatal(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, "%sERROR: %s%s\n", colorRed, fmt.Sprintf(format, args...), colorReset)
-	os.Exit(1)
+	// The stdout and stderr pipes drain independently, so output still
+	// in them must reach the console before the error does
+	logOut.restore()
+	msg := fmt.Sprintf(format, args...)
+	fmt.Fprintf(os.Stderr, "%sERROR: %s%s\n", colorRed, msg, colorReset)
+	logOut.WriteLine("ERROR: " + msg)
+	exit(1)
 }
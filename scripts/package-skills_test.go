@@ -0,0 +1,831 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestOutputBufferKeepsBlocksTogether(t *testing.T) {
+	const writers, lines = 8, 50
+
+	var out bytes.Buffer
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			buf := newOutputBuffer(&out, &mu)
+			for i := 0; i < lines; i++ {
+				buf.Printf("skill-%d line %d\n", w, i)
+			}
+			if err := buf.Flush(); err != nil {
+				t.Errorf("Flush: %v", err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(got) != writers*lines {
+		t.Fatalf("got %d lines, want %d", len(got), writers*lines)
+	}
+	for start := 0; start < len(got); start += lines {
+		var w int
+		if _, err := fmt.Sscanf(got[start], "skill-%d line 0", &w); err != nil {
+			t.Fatalf("block at line %d starts with %q", start, got[start])
+		}
+		for i := 0; i < lines; i++ {
+			if want := fmt.Sprintf("skill-%d line %d", w, i); got[start+i] != want {
+				t.Fatalf("line %d = %q, want %q", start+i, got[start+i], want)
+			}
+		}
+	}
+}
+
+func TestOutputBufferFlushEmptiesBuffer(t *testing.T) {
+	var out bytes.Buffer
+	buf := newOutputBuffer(&out, &sync.Mutex{})
+	fmt.Fprint(buf, "first\n")
+	if err := buf.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	buf.Printf("second\n")
+	if err := buf.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "first\nsecond\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"ascii", "docs/guide.md", "docs/guide.md"},
+		{"composed", "caf\u00e9/r\u00e9sum\u00e9.md", "caf\u00e9/r\u00e9sum\u00e9.md"},
+		{"decomposed", "cafe\u0301/re\u0301sume\u0301.md", "caf\u00e9/r\u00e9sum\u00e9.md"},
+		{"two marks", "e\u0323\u0302.md", "\u1ec7.md"},
+		{"extended latin", "z\u030c", "\u017e"},
+		{"mark without base", "\u0301x", "\u0301x"},
+		{"greek left alone", "\u03b1\u0301", "\u03b1\u0301"},
+		{"hangul left alone", "\u1100\u1161", "\u1100\u1161"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizePath(tt.in); got != tt.want {
+				t.Errorf("normalizePath(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDirSourceNormalizesDecomposedNames(t *testing.T) {
+	walk := func(name string) []string {
+		root := t.TempDir()
+		dir := filepath.Join(root, name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+".md"), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		err := dirSource{root: root}.Walk(func(file SourceFile) error {
+			paths = append(paths, file.RelPath)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return paths
+	}
+
+	composed := walk("caf\u00e9")
+	decomposed := walk("cafe\u0301")
+	if strings.Join(composed, ",") != strings.Join(decomposed, ",") {
+		t.Errorf("decomposed names walked as %q, composed as %q", decomposed, composed)
+	}
+}
+
+// writeFiles creates each slash-separated path in files under dir with the
+// given contents.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadMarketplaceResolvesRefs(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "single plugin",
+			files: map[string]string{
+				"marketplace.json":  `{"plugins": [{"$ref": "./plugins/core.json"}, {"name": "web", "source": "./web"}]}`,
+				"plugins/core.json": `{"name": "core", "source": "./core"}`,
+			},
+			want: []string{"core", "web"},
+		},
+		{
+			name: "array of plugins",
+			files: map[string]string{
+				"marketplace.json": `{"plugins": [{"$ref": "plugins.json"}]}`,
+				"plugins.json":     `[{"name": "a", "source": "./a"}, {"name": "b", "source": "./b"}]`,
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "nested refs resolve relative to their file",
+			files: map[string]string{
+				"marketplace.json":     `{"plugins": [{"$ref": "team/all.json"}]}`,
+				"team/all.json":        `[{"$ref": "nested/one.json"}]`,
+				"team/nested/one.json": `{"name": "one", "source": "./one"}`,
+			},
+			want: []string{"one"},
+		},
+		{
+			name: "cycle",
+			files: map[string]string{
+				"marketplace.json": `{"plugins": [{"$ref": "a.json"}]}`,
+				"a.json":           `{"$ref": "b.json"}`,
+				"b.json":           `{"$ref": "a.json"}`,
+			},
+			wantErr: "cyclic $ref",
+		},
+		{
+			name: "self reference",
+			files: map[string]string{
+				"marketplace.json": `{"plugins": [{"$ref": "marketplace.json"}]}`,
+			},
+			wantErr: "cyclic $ref",
+		},
+		{
+			name: "missing file",
+			files: map[string]string{
+				"marketplace.json": `{"plugins": [{"$ref": "gone.json"}]}`,
+			},
+			wantErr: "failed to read $ref gone.json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+
+			marketplace, err := readMarketplace(filepath.Join(dir, "marketplace.json"), false)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, plugin := range marketplace.Plugins {
+				names = append(names, plugin.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("plugins = %q, want %q", names, tt.want)
+			}
+		})
+	}
+}
+
+// countingSource counts the reads of each file in a wrapped SkillSource.
+type countingSource struct {
+	SkillSource
+	reads map[string]int
+}
+
+func (s countingSource) ReadFile(relPath string) ([]byte, error) {
+	s.reads[relPath]++
+	return s.SkillSource.ReadFile(relPath)
+}
+
+// resetFrontmatterCache empties the run-wide frontmatter cache before and
+// after a test.
+func resetFrontmatterCache(t *testing.T) {
+	frontmatterCache = map[string]frontmatterResult{}
+	t.Cleanup(func() { frontmatterCache = map[string]frontmatterResult{} })
+}
+
+func TestReadFrontmatterReadsSkillOnce(t *testing.T) {
+	resetFrontmatterCache(t)
+	dirs := []string{t.TempDir(), t.TempDir()}
+	sources := make([]countingSource, len(dirs))
+	for i, dir := range dirs {
+		writeFiles(t, dir, map[string]string{"SKILL.md": fmt.Sprintf("---\nname: skill-%d\n---\n", i)})
+		sources[i] = countingSource{SkillSource: dirSource{root: dir}, reads: map[string]int{}}
+	}
+
+	for round := 0; round < 3; round++ {
+		for i, source := range sources {
+			frontmatter, err := readFrontmatter(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := frontmatter.String("name"), fmt.Sprintf("skill-%d", i); got != want {
+				t.Errorf("name = %q, want %q", got, want)
+			}
+		}
+	}
+	for i, source := range sources {
+		if n := source.reads["SKILL.md"]; n != 1 {
+			t.Errorf("skill %d: SKILL.md read %d times, want 1", i, n)
+		}
+	}
+}
+
+func TestFixInvalidatesFrontmatterCache(t *testing.T) {
+	resetFrontmatterCache(t)
+	oldStdout := stdout
+	stdout = io.Discard
+	t.Cleanup(func() { stdout = oldStdout })
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"SKILL.md": "---\nname: old-name\n---\n"})
+	source := dirSource{root: dir}
+
+	frontmatter, err := readFrontmatter(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := frontmatter.String("name"); got != "old-name" {
+		t.Fatalf("name = %q before -fix", got)
+	}
+
+	if err := checkSkillMarkdown(source, "new-name", true, &PackageOptions{Fix: true, Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	if frontmatter, err = readFrontmatter(source); err != nil {
+		t.Fatal(err)
+	}
+	if got := frontmatter.String("name"); got != "new-name" {
+		t.Errorf("name = %q after -fix rewrote SKILL.md, want %q", got, "new-name")
+	}
+}
+
+func TestLongPath(t *testing.T) {
+	tests := []struct {
+		in, windows string
+	}{
+		{`C:\skills\core\SKILL.md`, `\\?\C:\skills\core\SKILL.md`},
+		{`C:\skills\core\..\web\.\SKILL.md`, `\\?\C:\skills\web\SKILL.md`},
+		{`\\server\share\skills`, `\\?\UNC\server\share\skills`},
+		{`\\?\C:\already\long`, `\\?\C:\already\long`},
+		{`relative\path`, `relative\path`},
+		{"/usr/local/skills", "/usr/local/skills"},
+	}
+	for _, tt := range tests {
+		want := tt.in
+		if runtime.GOOS == "windows" {
+			want = tt.windows
+		}
+		if got := longPath(tt.in); got != want {
+			t.Errorf("longPath(%q) = %q on %s, want %q", tt.in, got, runtime.GOOS, want)
+		}
+	}
+}
+
+func TestLongPathCreatesDeepFile(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("MAX_PATH only applies on Windows")
+	}
+	dir := t.TempDir()
+	for len(dir) < 300 {
+		dir = filepath.Join(dir, strings.Repeat("d", 50))
+	}
+	if err := os.MkdirAll(longPath(dir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "SKILL.md")
+	if err := os.WriteFile(longPath(path), []byte("x"), 0644); err != nil {
+		t.Fatalf("writing %d character path: %v", len(path), err)
+	}
+}
+
+func TestErrorChain(t *testing.T) {
+	root := errors.New("disk full")
+	err := fmt.Errorf("failed to add docs/a.md: %w", &fs.PathError{Op: "write", Path: "/out/s.zip", Err: root})
+
+	want := []string{
+		"*fmt.wrapError: failed to add docs/a.md",
+		"*fs.PathError: write /out/s.zip",
+		"*errors.errorString: disk full",
+	}
+	if got := errorChain(err); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("errorChain = %q, want %q", got, want)
+	}
+	if !errors.Is(err, root) {
+		t.Error("wrapped error lost its cause")
+	}
+}
+
+func TestOpenSkillSourceErrorsNamePath(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"no-skill-md/README.md": "x",
+		"no-docs/SKILL.md":      "---\nname: no-docs\n---\n",
+	})
+
+	tests := []struct {
+		name  string
+		skill string
+		opts  PackageOptions
+		want  string
+	}{
+		{"missing directory", "gone", PackageOptions{}, "source directory does not exist: "},
+		{"missing SKILL.md", "no-skill-md", PackageOptions{}, "SKILL.md not found in "},
+		{"missing required directory", "no-docs", PackageOptions{RequiredDirs: []string{"docs"}}, "required directories missing in "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srcDir := filepath.Join(dir, tt.skill)
+			_, err := openSkillSource(srcDir, &tt.opts)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if want := tt.want + srcDir; !strings.Contains(err.Error(), want) {
+				t.Errorf("err = %q, want it to contain %q", err, want)
+			}
+		})
+	}
+}
+
+func TestSafeEntryName(t *testing.T) {
+	tests := []struct {
+		in, want, wantErr string
+	}{
+		{in: "skill/SKILL.md", want: "skill/SKILL.md"},
+		{in: "skill/./docs//guide.md", want: "skill/docs/guide.md"},
+		{in: "skill/a..b.md", want: "skill/a..b.md"},
+		{in: "/etc/passwd", wantErr: "is absolute"},
+		{in: "C:/Windows/win.ini", wantErr: "starts with a drive letter"},
+		{in: "skill/../../escape.md", wantErr: "contains a .. element"},
+		{in: "..", wantErr: "contains a .. element"},
+		{in: "skill\\docs\\guide.md", wantErr: "contains a backslash"},
+		{in: "./", wantErr: "is empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := safeEntryName(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("safeEntryName(%q) = %q, %v; want error %q", tt.in, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("safeEntryName(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddFileToZipRejectsUnsafeNames(t *testing.T) {
+	file := SourceFile{
+		RelPath: "evil.md",
+		Size:    1,
+		Mode:    0644,
+		Open:    func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("x")), nil },
+	}
+	for _, name := range []string{"../evil.md", "/evil.md", `skill\evil.md`} {
+		var buf bytes.Buffer
+		zipWriter := zip.NewWriter(&buf)
+		if _, err := addFileToZip(zipWriter, file, name, zip.Deflate); err == nil {
+			t.Errorf("addFileToZip accepted entry name %q", name)
+		}
+		if err := zipWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reader.File) != 0 {
+			t.Errorf("%q: zip has %d entries, want none", name, len(reader.File))
+		}
+	}
+}
+
+func TestCleanPluginPaths(t *testing.T) {
+	tests := []struct {
+		source     string
+		skills     []string
+		wantSource string
+		wantSkills []string
+	}{
+		{"./plugins/core/", []string{"./skills/tdd/"}, filepath.FromSlash("plugins/core"), []string{filepath.FromSlash("skills/tdd")}},
+		{"plugins//core", []string{"skills//tdd"}, filepath.FromSlash("plugins/core"), []string{filepath.FromSlash("skills/tdd")}},
+		{"./plugins/./core/../core", []string{"./skills/./tdd"}, filepath.FromSlash("plugins/core"), []string{filepath.FromSlash("skills/tdd")}},
+		{"./", []string{"./"}, ".", []string{"."}},
+		{"", nil, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			plugin := Plugin{Source: tt.source, Skills: append([]string(nil), tt.skills...)}
+			cleanPluginPaths(&plugin)
+			if plugin.Source != tt.wantSource {
+				t.Errorf("Source = %q, want %q", plugin.Source, tt.wantSource)
+			}
+			if strings.Join(plugin.Skills, ",") != strings.Join(tt.wantSkills, ",") {
+				t.Errorf("Skills = %q, want %q", plugin.Skills, tt.wantSkills)
+			}
+		})
+	}
+}
+
+func TestReadMarketplaceResolvesMessyPathsAlike(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"marketplace.json": `{"plugins": [
+			{"name": "a", "source": "./plugins/core/", "skills": ["./skills/tdd/"]},
+			{"name": "b", "source": "plugins//core", "skills": ["skills//tdd"]},
+			{"name": "c", "source": "./plugins/./core", "skills": ["./skills/./tdd"]}
+		]}`,
+	})
+
+	marketplace, err := readMarketplace(filepath.Join(dir, "marketplace.json"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := marketplace.Plugins[0]
+	want := filepath.Join(first.Source, first.Skills[0])
+	for _, plugin := range marketplace.Plugins[1:] {
+		if got := filepath.Join(plugin.Source, plugin.Skills[0]); got != want {
+			t.Errorf("plugin %s resolves its skill to %q, plugin %s to %q", plugin.Name, got, first.Name, want)
+		}
+		if filepath.Base(plugin.Source) != "core" {
+			t.Errorf("plugin %s: base of source %q is not core", plugin.Name, plugin.Source)
+		}
+	}
+}
+
+func TestExpandHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	tests := []struct {
+		in, want string
+	}{
+		{"~", home},
+		{"~/", home},
+		{"~/skills/tdd", filepath.Join(home, "skills", "tdd")},
+		{"~user/skills", "~user/skills"},
+		{"~user", "~user"},
+		{"./plugins/~/core", "./plugins/~/core"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := expandHome(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("expandHome(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandPluginHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	plugin := Plugin{Name: "p", Source: "~/plugins/p", SkillsFile: "~/skills.txt", Skills: []string{"~/shared/tdd", "./skills/web"}}
+	if err := expandPluginHome(&plugin); err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(home, "plugins", "p"); plugin.Source != want {
+		t.Errorf("Source = %q, want %q", plugin.Source, want)
+	}
+	if want := filepath.Join(home, "skills.txt"); plugin.SkillsFile != want {
+		t.Errorf("SkillsFile = %q, want %q", plugin.SkillsFile, want)
+	}
+	if want := []string{filepath.Join(home, "shared", "tdd"), "./skills/web"}; strings.Join(plugin.Skills, ",") != strings.Join(want, ",") {
+		t.Errorf("Skills = %q, want %q", plugin.Skills, want)
+	}
+}
+
+func TestResolveSkillRoot(t *testing.T) {
+	dir := t.TempDir()
+	// TempDir may itself sit behind a symlink, as /var does on macOS
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, map[string]string{
+		"real/SKILL.md": "---\nname: real\n---\n",
+		"file.md":       "x",
+	})
+	links := map[string]string{
+		"linked":  filepath.Join(dir, "real"),
+		"broken":  filepath.Join(dir, "gone"),
+		"to-file": filepath.Join(dir, "file.md"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Skipf("cannot create symlinks: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name, want, wantErr string
+	}{
+		{name: "real", want: filepath.Join(dir, "real")},
+		{name: "linked", want: filepath.Join(dir, "real")},
+		{name: "broken", wantErr: "is a broken symlink to " + filepath.Join(dir, "gone")},
+		{name: "to-file", wantErr: "which is not a directory"},
+		{name: "missing", wantErr: "source directory does not exist"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveSkillRoot(filepath.Join(dir, tt.name))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("resolveSkillRoot = %q, %v; want error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("resolveSkillRoot = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPruneLock(t *testing.T) {
+	lock := &Lockfile{Skills: map[string]string{
+		"core/tdd":  "sha256:a",
+		"core/gone": "sha256:b",
+		"old/skill": "sha256:c",
+		"web/react": "sha256:d",
+	}}
+	known := map[string]bool{"core/tdd": true, "web/react": true, "web/new": true}
+
+	removed := pruneLock(lock, known)
+	if want := []string{"core/gone", "old/skill"}; strings.Join(removed, ",") != strings.Join(want, ",") {
+		t.Errorf("removed = %q, want %q", removed, want)
+	}
+	if len(lock.Skills) != 2 || lock.Skills["core/tdd"] != "sha256:a" || lock.Skills["web/react"] != "sha256:d" {
+		t.Errorf("lock left with %v", lock.Skills)
+	}
+}
+
+func TestParseOutputMode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{in: "", want: 0},
+		{in: "0644", want: 0644},
+		{in: "755", want: 0755},
+		{in: "0600", want: 0600},
+		{in: "0", wantErr: true},
+		{in: "0000", wantErr: true},
+		{in: "0888", wantErr: true},
+		{in: "01777", wantErr: true},
+		{in: "rw-r--r--", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseOutputMode(tt.in)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseOutputMode(%q) = %o, %v; want %o, error %v", tt.in, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLatestChangelogVersion(t *testing.T) {
+	tests := []struct {
+		name, changelog, want string
+	}{
+		{"plain", "# Changelog\n\n## 1.2.0\n\n## 1.1.0\n", "1.2.0"},
+		{"v prefix", "## v1.2.0\n", "1.2.0"},
+		{"keep a changelog", "## [1.2.0] - 2024-01-01\n", "1.2.0"},
+		{"unreleased first", "# Changelog\n\n## [Unreleased]\n\n- wip\n\n## [1.2.0] - 2024-01-01\n", "1.2.0"},
+		{"unreleased without brackets", "## Unreleased\n## 1.2.0\n", "1.2.0"},
+		{"only unreleased", "## [Unreleased]\n- wip\n", ""},
+		{"no headings", "# Changelog\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := latestChangelogVersion([]byte(tt.changelog)); got != tt.want {
+				t.Errorf("latestChangelogVersion = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupIndexes(t *testing.T) {
+	plugins := []string{"web", "core", "web", "core"}
+	tags := [][]string{{"react", "testing"}, nil, {"react", "react"}, {"git"}}
+
+	format := func(groups []indexGroup) string {
+		var parts []string
+		for _, group := range groups {
+			parts = append(parts, fmt.Sprintf("%s:%v", group.name, group.members))
+		}
+		return strings.Join(parts, " ")
+	}
+	if got, want := format(groupIndexes(plugins, tags, "plugin")), "web:[0 2] core:[1 3]"; got != want {
+		t.Errorf("by plugin = %q, want %q", got, want)
+	}
+	if got, want := format(groupIndexes(plugins, tags, "tag")), "git:[3] react:[0 2] testing:[0] other:[1]"; got != want {
+		t.Errorf("by tag = %q, want %q", got, want)
+	}
+}
+
+func TestChecksumZipsCoversSplitParts(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"whole.zip":      "whole",
+		"big.part-1.zip": "first",
+		"big.part-2.zip": "second",
+		"big.parts.json": `{"zip": "big.zip", "parts": [{"file": "big.part-1.zip"}, {"file": "big.part-2.zip"}]}`,
+	})
+	stats := &PackageStats{Results: []SkillResult{
+		{Plugin: "p", Skill: "whole", Status: statusSuccess},
+		{Plugin: "p", Skill: "big", Status: statusSuccess},
+		{Plugin: "p", Skill: "missing", Status: statusSkipped},
+	}}
+	if err := checksumZips(stats, &PackageOptions{OutputDir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := func(data string) string {
+		hash := sha256.Sum256([]byte(data))
+		return "sha256:" + hex.EncodeToString(hash[:])
+	}
+	whole, big, missing := stats.Results[0], stats.Results[1], stats.Results[2]
+	if whole.SHA256 != sum("whole") || whole.Bytes != 5 || whole.Parts != nil {
+		t.Errorf("whole zip = %+v", whole)
+	}
+	if big.SHA256 != sum("firstsecond") || big.Bytes != 11 {
+		t.Errorf("split zip = %+v, want the parts joined", big)
+	}
+	wantParts := []PartChecksum{{"big.part-1.zip", 5, sum("first")}, {"big.part-2.zip", 6, sum("second")}}
+	if fmt.Sprint(big.Parts) != fmt.Sprint(wantParts) {
+		t.Errorf("parts = %v, want %v", big.Parts, wantParts)
+	}
+	if missing.SHA256 != "" {
+		t.Errorf("skill without a zip got checksum %s", missing.SHA256)
+	}
+}
+
+func TestCheckOutputPaths(t *testing.T) {
+	outputPath := func(pluginName, skillName string) string {
+		return filepath.Join("out", skillName)
+	}
+	marketplace := func(skills ...[]string) *MarketplaceConfig {
+		config := &MarketplaceConfig{}
+		for i, plugin := range skills {
+			config.Plugins = append(config.Plugins, Plugin{Name: fmt.Sprintf("p%d", i+1), Skills: plugin})
+		}
+		return config
+	}
+
+	clashes, err := checkOutputPaths(marketplace([]string{"./skills/a", "./skills/b"}), outputPath)
+	if err != nil || len(clashes) != 0 {
+		t.Errorf("distinct paths = %q, %v", clashes, err)
+	}
+
+	clashes, err = checkOutputPaths(marketplace([]string{"./skills/Review"}, []string{"./skills/review"}), outputPath)
+	if err != nil {
+		t.Fatalf("case-only clash failed: %v", err)
+	}
+	if len(clashes) != 1 || !strings.Contains(clashes[0], "p1/Review and p2/review") {
+		t.Errorf("case-only clash = %q", clashes)
+	}
+
+	// An exact clash with a path only seen through a case-only one
+	_, err = checkOutputPaths(marketplace([]string{"./skills/Review"}, []string{"./skills/review"}, []string{"./skills/review"}), outputPath)
+	if err == nil || !strings.Contains(err.Error(), "p2/review and p3/review would both be written to") {
+		t.Errorf("exact clash = %v", err)
+	}
+}
+
+func TestLogLinesDropsOverwrittenText(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.log")
+	log := &rotatingLog{path: path}
+	if err := log.open(os.O_APPEND); err != nil {
+		t.Fatal(err)
+	}
+	lines := &logLines{log: log}
+	for _, chunk := range []string{"1/2 skills\r", "\033[K[PACKAGED] a.zip\n", "2/2 skills", "\r\033[Kdone\r", "\n", "tail"} {
+		lines.Write([]byte(chunk))
+	}
+	lines.Flush()
+	log.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		_, text, _ := strings.Cut(line, " ")
+		got = append(got, text)
+	}
+	if want := []string{"[PACKAGED] a.zip", "done", "tail"}; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("logged %q, want %q", got, want)
+	}
+}
+
+func TestCheckSkillMarkdownWarnsUnlessFailing(t *testing.T) {
+	var out bytes.Buffer
+	oldStdout := stdout
+	stdout = &out
+	t.Cleanup(func() { stdout = oldStdout })
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"SKILL.md": "---\nname: bar\n---\nbody"})
+	source := dirSource{root: dir}
+
+	// A dry run only warns, since a real run packages the file as it is
+	if err := checkSkillMarkdown(source, "foo", false, &PackageOptions{}); err != nil {
+		t.Errorf("dry run failed: %v", err)
+	}
+	for _, want := range []string{`frontmatter name "bar" does not match directory "foo"`, "missing trailing newline"} {
+		if !strings.Contains(out.String(), "[WARN]") || !strings.Contains(out.String(), want) {
+			t.Errorf("output %q lacks a warning for %s", out.String(), want)
+		}
+	}
+
+	out.Reset()
+	err := checkSkillMarkdown(source, "foo", true, &PackageOptions{})
+	if err == nil || !strings.Contains(err.Error(), "does not match directory") {
+		t.Fatalf("-check error = %v", err)
+	}
+	if strings.Contains(err.Error(), "trailing newline") || !strings.Contains(out.String(), "missing trailing newline") {
+		t.Errorf("trailing newline should stay a warning: err %v, output %q", err, out.String())
+	}
+}
+
+func TestPurgeOrphanZipsRemovesSplitSkillOnce(t *testing.T) {
+	oldStdout := stdout
+	stdout = io.Discard
+	t.Cleanup(func() { stdout = oldStdout })
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"kept.zip":               "",
+		"kept.part-1.zip":        "",
+		"gone.part-1.zip":        "",
+		"gone.part-1.zip.sig":    "",
+		"gone.part-2.zip":        "",
+		"gone.parts.json":        "",
+		"gone.zip.manifest.json": "",
+		"old.zip":                "",
+		"notes.txt":              "",
+	})
+	marketplace := &MarketplaceConfig{Plugins: []Plugin{{Name: "p", Skills: []string{"./skills/kept"}}}}
+	stats := &PackageStats{}
+	if err := purgeOrphanZips(marketplace, &PackageOptions{OutputDir: dir, AssumeYes: true}, stats); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.OrphansPurged != 2 {
+		t.Errorf("purged %d orphans, want 2 (gone and old)", stats.OrphansPurged)
+	}
+	entries, _ := os.ReadDir(dir)
+	var left []string
+	for _, entry := range entries {
+		left = append(left, entry.Name())
+	}
+	if got, want := strings.Join(left, " "), "kept.part-1.zip kept.zip notes.txt"; got != want {
+		t.Errorf("left %q, want %q", got, want)
+	}
+}